@@ -0,0 +1,244 @@
+// BSD 3-Clause License
+
+// Copyright (c) 2021, James Bowes
+// Copyright (c) 2023, Alexander Taraymovich, OffBlocks
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package httpsig
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/dunglas/httpsfv"
+)
+
+// AcceptSignature is a signature requested by a message recipient using the Accept-Signature
+// header.
+//
+// https://www.ietf.org/archive/id/draft-ietf-httpbis-message-signatures-19.html#name-the-accept-signature-field
+type AcceptSignature struct {
+	// The name of the requested signature
+	Name string
+
+	// The HTTP fields / derived component names requested to be signed
+	Fields []string
+
+	// The signature parameters requested to be included in the signature
+	Params []Param
+
+	// The requested values of signature parameters, if any (eg: keyid, alg, tag)
+	ParamValues *SignatureParameters
+}
+
+// BuildAcceptSignature creates the value of an Accept-Signature header requesting the given
+// signatures.
+func BuildAcceptSignature(accepts ...AcceptSignature) (string, error) {
+	dict := httpsfv.NewDictionary()
+
+	for _, accept := range accepts {
+		if accept.Name == "" {
+			return "", errors.New("accept signature requires a name")
+		}
+
+		input := httpsfv.InnerList{
+			Items:  []httpsfv.Item{},
+			Params: httpsfv.NewParams(),
+		}
+		for _, f := range normaliseFields(accept.Fields) {
+			item, err := httpsfv.UnmarshalItem([]string{f})
+			if err != nil {
+				return "", err
+			}
+			input.Items = append(input.Items, item)
+		}
+
+		values := accept.ParamValues
+		if values == nil {
+			values = &SignatureParameters{}
+		}
+		for _, p := range accept.Params {
+			switch {
+			case p == ParamKeyID && values.KeyID != nil:
+				input.Params.Add(string(p), *values.KeyID)
+			case p == ParamAlg && values.Alg != nil:
+				input.Params.Add(string(p), string(*values.Alg))
+			case p == ParamTag && values.Tag != nil:
+				input.Params.Add(string(p), *values.Tag)
+			case p == ParamNonce && values.Nonce != nil:
+				input.Params.Add(string(p), *values.Nonce)
+			default:
+				// a bare parameter requests that the signer include a value of its choosing
+				input.Params.Add(string(p), true)
+			}
+		}
+
+		dict.Add(accept.Name, input)
+	}
+
+	return httpsfv.Marshal(dict)
+}
+
+// ParseAcceptSignature parses the Accept-Signature header of the given headers. Signature
+// parameters not defined by the standard are ignored.
+func ParseAcceptSignature(header http.Header) ([]AcceptSignature, error) {
+	values := header.Values(AcceptSignatureHeader)
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	dict, err := httpsfv.UnmarshalDictionary(values)
+	if err != nil {
+		return nil, err
+	}
+
+	accepts := make([]AcceptSignature, 0, len(dict.Names()))
+	for _, name := range dict.Names() {
+		member, _ := dict.Get(name)
+		input, ok := member.(httpsfv.InnerList)
+		if !ok {
+			return nil, errors.New("invalid accept signature")
+		}
+
+		accept := AcceptSignature{
+			Name:        name,
+			ParamValues: &SignatureParameters{},
+		}
+
+		for _, item := range input.Items {
+			marshalled, err := httpsfv.Marshal(item)
+			if err != nil {
+				return nil, err
+			}
+			accept.Fields = append(accept.Fields, marshalled)
+		}
+
+		for _, k := range input.Params.Names() {
+			v, _ := input.Params.Get(k)
+			p := Param(k)
+			switch p {
+			case ParamCreated, ParamExpires:
+			case ParamNonce:
+				// a bare nonce lets the signer generate one
+				if n, ok := v.(string); ok {
+					accept.ParamValues.Nonce = &n
+				}
+			case ParamKeyID, ParamAlg, ParamTag:
+				// these can't be chosen by the signer, so a value is required
+				str, ok := v.(string)
+				if !ok {
+					return nil, errors.New("invalid accept signature parameter")
+				}
+				switch p {
+				case ParamKeyID:
+					accept.ParamValues.KeyID = &str
+				case ParamAlg:
+					a := Algorithm(str)
+					accept.ParamValues.Alg = &a
+				case ParamTag:
+					accept.ParamValues.Tag = &str
+				}
+			default:
+				continue
+			}
+			accept.Params = append(accept.Params, p)
+		}
+
+		accepts = append(accepts, accept)
+	}
+
+	return accepts, nil
+}
+
+// acceptedConfig returns the signing configuration satisfying the first signature requested by
+// the Accept-Signature header of the request the message is responding to, based on the given
+// configuration. Requests naming a different key or algorithm are skipped, and the configured
+// signing parameters are used if no request can be met. It fails if a component of the accepted
+// request can't be derived for the message, rather than signing less than was requested.
+func acceptedConfig(base SignConfig, msg *Message) (SignConfig, error) {
+	if msg.IsRequest || msg.RequestHeader == nil {
+		return base, nil
+	}
+
+	accepts, err := ParseAcceptSignature(*msg.RequestHeader)
+	if err != nil {
+		return SignConfig{}, err
+	}
+
+	for _, accept := range accepts {
+		if accept.ParamValues.KeyID != nil && *accept.ParamValues.KeyID != base.Key.GetKeyID() {
+			continue
+		}
+		if accept.ParamValues.Alg != nil && *accept.ParamValues.Alg != base.Key.GetAlgorithm() {
+			continue
+		}
+
+		config := base
+		name := accept.Name
+		config.Name = &name
+
+		config.Fields = normaliseFields(accept.Fields)
+		for _, f := range config.Fields {
+			if _, err := createSignatureBaseWith([]string{f}, msg, baseOptions{components: base.Components}); err != nil {
+				return SignConfig{}, fmt.Errorf("unable to sign requested signature %s: %w", name, err)
+			}
+		}
+
+		// start from the configured values so that explicit overrides are kept
+		values := SignatureParameters{}
+		if base.ParamValues != nil {
+			values = *base.ParamValues
+		}
+		if slices.Contains(accept.Params, ParamCreated) && values.Created == nil {
+			now := time.Now()
+			values.Created = &now
+		}
+		if accept.ParamValues.Nonce != nil {
+			values.Nonce = accept.ParamValues.Nonce
+		}
+		if accept.ParamValues.Tag != nil {
+			values.Tag = accept.ParamValues.Tag
+		}
+
+		config.Params = make([]Param, 0, len(accept.Params))
+		for _, p := range accept.Params {
+			if p == ParamTag && values.Tag == nil {
+				continue
+			}
+			config.Params = append(config.Params, p)
+		}
+		config.ParamValues = &values
+
+		return config, nil
+	}
+
+	return base, nil
+}
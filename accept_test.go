@@ -0,0 +1,155 @@
+// BSD 3-Clause License
+
+// Copyright (c) 2021, James Bowes
+// Copyright (c) 2023, Alexander Taraymovich, OffBlocks
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package httpsig
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildAcceptSignature(t *testing.T) {
+	keyID := "test-key-rsa-pss"
+	tag := "app-123"
+	hdr, err := BuildAcceptSignature(AcceptSignature{
+		Name:   "sig1",
+		Fields: []string{"@method", "@target-uri", "@authority", "Content-Digest", "cache-control"},
+		Params: []Param{ParamKeyID, ParamCreated, ParamTag},
+		ParamValues: &SignatureParameters{
+			KeyID: &keyID,
+			Tag:   &tag,
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `sig1=("@method" "@target-uri" "@authority" "content-digest" "cache-control");keyid="test-key-rsa-pss";created;tag="app-123"`, hdr)
+
+	_, err = BuildAcceptSignature(AcceptSignature{Fields: []string{"@method"}})
+	assert.Error(t, err)
+}
+
+func TestParseAcceptSignature(t *testing.T) {
+	t.Run("parses the example from the standard", func(t *testing.T) {
+		hdr := http.Header{}
+		hdr.Set("Accept-Signature", `sig1=("@method" "@target-uri" "@authority" "content-digest" "cache-control");keyid="test-key-rsa-pss";created;tag="app-123"`)
+
+		accepts, err := ParseAcceptSignature(hdr)
+		assert.NoError(t, err)
+		assert.Len(t, accepts, 1)
+		assert.Equal(t, "sig1", accepts[0].Name)
+		assert.Equal(t, []string{`"@method"`, `"@target-uri"`, `"@authority"`, `"content-digest"`, `"cache-control"`}, accepts[0].Fields)
+		assert.Equal(t, []Param{ParamKeyID, ParamCreated, ParamTag}, accepts[0].Params)
+		assert.Equal(t, "test-key-rsa-pss", *accepts[0].ParamValues.KeyID)
+		assert.Equal(t, "app-123", *accepts[0].ParamValues.Tag)
+		assert.Nil(t, accepts[0].ParamValues.Nonce)
+	})
+	t.Run("ignores unknown parameters", func(t *testing.T) {
+		hdr := http.Header{}
+		hdr.Set("Accept-Signature", `sig1=("@status");created;unknown=1;nonce`)
+
+		accepts, err := ParseAcceptSignature(hdr)
+		assert.NoError(t, err)
+		assert.Equal(t, []Param{ParamCreated, ParamNonce}, accepts[0].Params)
+	})
+	t.Run("no header", func(t *testing.T) {
+		accepts, err := ParseAcceptSignature(http.Header{})
+		assert.NoError(t, err)
+		assert.Empty(t, accepts)
+	})
+	t.Run("error on malformed header", func(t *testing.T) {
+		for _, v := range []string{`sig1=:aGVsbG8=:`, `sig1=("@method");keyid`, `sig1=("@method"`} {
+			hdr := http.Header{}
+			hdr.Set("Accept-Signature", v)
+			_, err := ParseAcceptSignature(hdr)
+			assert.Error(t, err, v)
+		}
+	})
+}
+
+func TestSign_AcceptSignature(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	created := time.Unix(1618884473, 0)
+	signer := func(opts ...signOption) *Signer {
+		return NewSigner(append([]signOption{
+			WithHmacSha256("test-shared-secret", k),
+			WithSignFields("@status"),
+			WithSignParamValues(&SignatureParameters{Created: &created}),
+		}, opts...)...)
+	}
+
+	t.Run("signs the requested components", func(t *testing.T) {
+		resp := testResp()
+		resp.Request.Header.Set("Accept-Signature", `reqsig=("@status" "content-type" "@authority";req);keyid="test-shared-secret";created;tag="app-123"`)
+
+		hdr, err := signer(WithSignAcceptSignature(true)).Sign(MessageFromResponse(resp))
+		assert.NoError(t, err)
+		assert.Equal(t, `reqsig=("@status" "content-type" "@authority";req);created=1618884473;keyid="test-shared-secret";tag="app-123"`, hdr.Get("Signature-Input"))
+
+		resp.Header = hdr
+		v := NewVerifier(
+			WithHmacSha256("test-shared-secret", k),
+			withClock(&testClock{now: created}),
+		)
+		assert.NoError(t, v.Verify(MessageFromResponse(resp)))
+	})
+	t.Run("fails for components the response doesn't have", func(t *testing.T) {
+		resp := testResp()
+		resp.Request.Header.Set("Accept-Signature", `reqsig=("@status" "x-missing");keyid="test-shared-secret"`)
+
+		_, err := signer(WithSignAcceptSignature(true)).Sign(MessageFromResponse(resp))
+		assert.ErrorIs(t, err, ErrMissingCoveredComponent)
+		assert.ErrorContains(t, err, "reqsig")
+		assert.ErrorContains(t, err, "x-missing")
+	})
+	t.Run("falls back for a different key", func(t *testing.T) {
+		resp := testResp()
+		resp.Request.Header.Set("Accept-Signature", `reqsig=("content-type");keyid="other-key"`)
+
+		hdr, err := signer(WithSignAcceptSignature(true)).Sign(MessageFromResponse(resp))
+		assert.NoError(t, err)
+		assert.Equal(t, `sig=("@status");created=1618884473;keyid="test-shared-secret";alg="hmac-sha256"`, hdr.Get("Signature-Input"))
+	})
+	t.Run("ignored unless enabled", func(t *testing.T) {
+		resp := testResp()
+		resp.Request.Header.Set("Accept-Signature", `reqsig=("content-type")`)
+
+		hdr, err := signer().Sign(MessageFromResponse(resp))
+		assert.NoError(t, err)
+		assert.Equal(t, `sig=("@status");created=1618884473;keyid="test-shared-secret";alg="hmac-sha256"`, hdr.Get("Signature-Input"))
+	})
+}
@@ -34,6 +34,8 @@ package httpsig
 import (
 	"encoding/base64"
 	"errors"
+	"fmt"
+	"net/http"
 	"slices"
 	"strings"
 	"time"
@@ -136,25 +138,31 @@ func createSigningParameters(config *SignConfig) *httpsfv.Params {
 	return output
 }
 
+// maxSignatureParams is the most parameters accepted on a signature, well beyond those defined
+const maxSignatureParams = 64
+
 func parseParams(params *httpsfv.Params) (*SignatureParameters, error) {
 	output := SignatureParameters{}
 
 	if params == nil {
 		return nil, errors.New("no parameters provided")
 	}
+	if len(params.Names()) > maxSignatureParams {
+		return nil, fmt.Errorf("too many parameters: %d", len(params.Names()))
+	}
 
 	for _, k := range params.Names() {
 		p, _ := params.Get(k)
 
 		if k == "created" {
-			if v, ok := p.(int64); ok {
+			if v, ok := p.(int64); ok && v >= 0 {
 				t := time.Unix(v, 0)
 				output.Created = &t
 			} else {
 				return nil, errors.New("invalid created parameter")
 			}
 		} else if k == "expires" {
-			if v, ok := p.(int64); ok {
+			if v, ok := p.(int64); ok && v >= 0 {
 				t := time.Unix(v, 0)
 				output.Expires = &t
 			} else {
@@ -186,13 +194,188 @@ func parseParams(params *httpsfv.Params) (*SignatureParameters, error) {
 				return nil, errors.New("invalid tag parameter")
 			}
 		} else {
-			return nil, errors.New("unknown parameter")
+			// any other parameter is unknown, though still covered by the signature
+			if output.Extra == nil {
+				output.Extra = make(map[string]any)
+			}
+			output.Extra[k] = p
 		}
 	}
 
 	return &output, nil
 }
 
+// addExtraParams adds the given parameters other than those defined to the signature parameters,
+// in order of name
+func addExtraParams(params *httpsfv.Params, extra map[string]any) error {
+	names := make([]string, 0, len(extra))
+	for name := range extra {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		switch Param(name) {
+		case ParamCreated, ParamExpires, ParamNonce, ParamAlg, ParamKeyID, ParamTag:
+			return fmt.Errorf("invalid signature parameter %q: use the defined parameter", name)
+		}
+
+		value := extra[name]
+		switch v := value.(type) {
+		case string, bool, int64, float64, []byte, httpsfv.Token:
+		case int:
+			value = int64(v)
+		default:
+			return fmt.Errorf("invalid signature parameter %q: unsupported type %T", name, value)
+		}
+
+		// check the name and value are valid structured field syntax
+		check := httpsfv.NewParams()
+		check.Add(name, value)
+		if _, err := httpsfv.Marshal(httpsfv.Item{Value: true, Params: check}); err != nil {
+			return fmt.Errorf("invalid signature parameter %q: %w", name, err)
+		}
+
+		params.Add(name, value)
+	}
+
+	return nil
+}
+
+// SignatureInput describes a signature declared by the Signature-Input header of a message
+type SignatureInput struct {
+	SignatureParameters
+
+	// The name of the signature
+	Name string
+
+	// The HTTP fields / derived component names covered by the signature
+	Fields []string
+}
+
+// ParseSignatureHeaders parses the Signature-Input header of the given headers, describing each
+// signature without verifying it. Signatures are returned in the order they are declared. A
+// header sent on several lines is combined into a single dictionary, as by RFC 8941. A header that
+// can't be parsed returns an error wrapping ErrMalformedSignature.
+func ParseSignatureHeaders(header http.Header) ([]SignatureInput, error) {
+	values, ok := header[SignatureInputHeader]
+	if !ok {
+		return nil, ErrNoSignature
+	}
+
+	dict, err := httpsfv.UnmarshalDictionary(values)
+	if err != nil {
+		return nil, malformedSignature(err)
+	}
+	return signatureInputs(dict)
+}
+
+// signatureInputs describes each signature of the parsed Signature-Input header
+func signatureInputs(dict *httpsfv.Dictionary) ([]SignatureInput, error) {
+	inputs := make([]SignatureInput, 0, len(dict.Names()))
+	for _, name := range dict.Names() {
+		member, _ := dict.Get(name)
+		list, ok := member.(httpsfv.InnerList)
+		if !ok {
+			return nil, ErrMalformedSignature
+		}
+
+		input, err := signatureInput(name, list)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, input)
+	}
+
+	return inputs, nil
+}
+
+// signatureInput describes the signature with the given name and parsed inner list of the
+// Signature-Input header
+func signatureInput(name string, list httpsfv.InnerList) (SignatureInput, error) {
+	params, err := parseParams(list.Params)
+	if err != nil {
+		return SignatureInput{}, malformedSignature(err)
+	}
+
+	input := SignatureInput{
+		SignatureParameters: *params,
+		Name:                name,
+		Fields:              make([]string, 0, len(list.Items)),
+	}
+	for _, item := range list.Items {
+		marshalled, err := httpsfv.Marshal(item)
+		if err != nil {
+			return SignatureInput{}, malformedSignature(err)
+		}
+		input.Fields = append(input.Fields, marshalled)
+	}
+
+	return input, nil
+}
+
+// SignatureBase returns the signature base for a signature of the message with the given input,
+// exactly as it is signed. It can be used to check canonicalisation against other
+// implementations. Parameters that are set are serialised in the order created, expires, keyid,
+// alg, nonce, tag, followed by any extra parameters in order of name. The name of the input isn't
+// part of the signature base.
+func SignatureBase(msg *Message, input SignatureInput) (string, error) {
+	params := httpsfv.NewParams()
+	if input.Created != nil {
+		params.Add("created", input.Created.Unix())
+	}
+	if input.Expires != nil {
+		params.Add("expires", input.Expires.Unix())
+	}
+	if input.KeyID != nil {
+		params.Add("keyid", *input.KeyID)
+	}
+	if input.Alg != nil {
+		params.Add("alg", string(*input.Alg))
+	}
+	if input.Nonce != nil {
+		params.Add("nonce", *input.Nonce)
+	}
+	if input.Tag != nil {
+		params.Add("tag", *input.Tag)
+	}
+	if err := addExtraParams(params, input.Extra); err != nil {
+		return "", err
+	}
+
+	base, _, err := signatureBase(input.Fields, params, msg, baseOptions{})
+	return base, err
+}
+
+// signatureBase creates the signature base covering the given fields of the message, with the
+// given signature parameters. The signature input it ends with is also returned.
+func signatureBase(fields []string, params *httpsfv.Params, msg *Message, opts baseOptions) (string, *httpsfv.InnerList, error) {
+	items, err := createSignatureBaseWith(fields, msg, opts)
+	if err != nil {
+		return "", nil, err
+	}
+
+	input := httpsfv.InnerList{}
+	for _, item := range items {
+		input.Items = append(input.Items, item.key)
+	}
+	input.Params = params
+
+	marshalledInput, err := httpsfv.Marshal(input)
+	if err != nil {
+		return "", nil, err
+	}
+
+	items = append(items, signatureItem{httpsfv.NewItem("@signature-params"), []string{marshalledInput}})
+
+	base, err := formatSignatureBase(items)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return base, &input, nil
+}
+
 func normaliseParams(params *httpsfv.Params) *httpsfv.Params {
 	if params == nil {
 		return nil
@@ -216,9 +399,46 @@ func normaliseParams(params *httpsfv.Params) *httpsfv.Params {
 	return ps
 }
 
+// baseOptions changes how a signature base is created
+type baseOptions struct {
+	// accept derived component names that aren't lowercase, resolving them as if they were
+	lenientCase bool
+
+	// resolvers of application specific components, by lowercase name
+	components map[string]func(msg *Message) (string, error)
+
+	// reformat the media type of a raw content-type field canonically
+	canonicalContentType bool
+
+	// items already created for the message, by covered component, reused rather than created
+	// again when several signatures cover the same component, and added to as items are created
+	items map[string]signatureItem
+}
+
+// isRawField reports whether a field with the given parameters is covered by its raw value, rather
+// than serialised as a structured field or byte sequences
+func isRawField(params *httpsfv.Params) bool {
+	for _, name := range []string{"sf", "key", "bs"} {
+		if _, ok := params.Get(name); ok {
+			return false
+		}
+	}
+	return true
+}
+
 func createSignatureBase(fields []string, msg *Message) ([]signatureItem, error) {
-	items := make([]signatureItem, 0)
+	return createSignatureBaseWith(fields, msg, baseOptions{})
+}
+
+// createSignatureBaseWith is like createSignatureBase, but with the given options
+func createSignatureBaseWith(fields []string, msg *Message, opts baseOptions) ([]signatureItem, error) {
+	items := make([]signatureItem, 0, len(fields))
 	for _, f := range fields {
+		if item, ok := opts.items[f]; ok {
+			items = append(items, item)
+			continue
+		}
+
 		field, err := httpsfv.UnmarshalItem([]string{quoteString(f)})
 		if err != nil {
 			return nil, err
@@ -226,13 +446,28 @@ func createSignatureBase(fields []string, msg *Message) ([]signatureItem, error)
 
 		params := normaliseParams(field.Params)
 		lcName := strings.ToLower(field.Value.(string))
+		if !opts.lenientCase && strings.HasPrefix(lcName, "@") && lcName != field.Value.(string) {
+			return nil, fmt.Errorf("derived component name must be lowercase: %s", field.Value)
+		}
 
 		if lcName != "@signature-params" {
 			var value []string
-			if strings.HasPrefix(lcName, "@") {
+			if resolve, ok := opts.components[lcName]; ok {
+				var v string
+				v, err = resolve(msg)
+				if err != nil {
+					err = fmt.Errorf("unable to resolve component %s: %w", lcName, err)
+				}
+				value = []string{v}
+			} else if strings.HasPrefix(lcName, "@") {
 				value, err = canonicaliseComponent(lcName, params, msg)
 			} else {
 				value, err = canonicaliseHeader(lcName, params, msg)
+				if opts.canonicalContentType && lcName == "content-type" && isRawField(params) {
+					for i, v := range value {
+						value[i] = canonicalContentType(v)
+					}
+				}
 			}
 			if err != nil {
 				return nil, err
@@ -241,6 +476,9 @@ func createSignatureBase(fields []string, msg *Message) ([]signatureItem, error)
 			item.Params = params
 
 			items = append(items, signatureItem{item, value})
+			if opts.items != nil {
+				opts.items[f] = items[len(items)-1]
+			}
 		}
 	}
 
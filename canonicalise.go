@@ -35,18 +35,23 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"mime"
 	"net"
 	"net/http"
+	"net/textproto"
 	"net/url"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
 	"github.com/dunglas/httpsfv"
 )
 
-// message is a minimal representation of an HTTP request or response, containing the values
-// needed to construct a signature.
+// Message is a minimal representation of an HTTP request or response, containing the values
+// needed to construct a signature. Messages don't need to come from net/http: build one with
+// NewMessage, or set the fields directly, to sign and verify messages sent over other transports
+// with the same semantics, eg: a message queue or gRPC metadata.
 type Message struct {
 	Method        string
 	Authority     string
@@ -56,33 +61,132 @@ type Message struct {
 	RequestHeader *http.Header
 	IsRequest     bool
 	Context       context.Context
+	Trailer       http.Header
 }
 
+// MessageFromRequest creates a message for the given request. Requests made by a client and
+// requests received by a server are both supported: the authority is taken from the Host field,
+// or the URL if it is empty, and the URL of a received request, which only has a path and query,
+// is completed with the authority and a scheme according to whether it was received over TLS.
 func MessageFromRequest(r *http.Request) *Message {
+	authority, u := requestTarget(r)
 	return &Message{
 		Method:    r.Method,
-		Authority: r.Host,
-		URL:       r.URL,
+		Authority: authority,
+		URL:       u,
 		Header:    r.Header.Clone(),
 		IsRequest: true,
 		Context:   r.Context(),
 	}
 }
 
+// MessageFromResponse creates a message for the given response. Trailers are only available once
+// the response body has been read to the end, so read it before verifying signatures covering
+// trailer fields.
 func MessageFromResponse(r *http.Response) *Message {
 	requestHeader := r.Request.Header.Clone()
+	authority, u := requestTarget(r.Request)
 	return &Message{
 		Method:        r.Request.Method,
-		Authority:     r.Request.Host,
-		URL:           r.Request.URL,
+		Authority:     authority,
+		URL:           u,
 		Header:        r.Header.Clone(),
 		StatusCode:    r.StatusCode,
 		RequestHeader: &requestHeader,
 		IsRequest:     false,
 		Context:       r.Request.Context(),
+		Trailer:       r.Trailer.Clone(),
 	}
 }
 
+// NewMessage creates a request message with the given method, authority, path and query, for
+// transports other than net/http. The path and query are given as they'd appear in the request
+// target, percent-encoded and without the leading "?", and the scheme is https. The headers are
+// copied, as by MessageFromRequest.
+func NewMessage(method, authority, path, query string, header http.Header) (*Message, error) {
+	u := &url.URL{Scheme: "https", Host: authority}
+	if path != "" {
+		parsed, err := url.ParseRequestURI(path)
+		if err != nil || parsed.Scheme != "" || parsed.Host != "" || parsed.RawQuery != "" {
+			return nil, fmt.Errorf("invalid path: %q", path)
+		}
+		u.Path, u.RawPath = parsed.Path, parsed.RawPath
+	}
+	u.RawQuery = query
+
+	header = header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+
+	return &Message{
+		Method:    method,
+		Authority: authority,
+		URL:       u,
+		Header:    header,
+		IsRequest: true,
+		Context:   context.Background(),
+	}, nil
+}
+
+// MessageFromMetadata creates a request message for a gRPC or gRPC-Web call with the given
+// metadata, method and path, eg: `/package.Service/Method`, so calls can be signed and verified
+// without an *http.Request. Metadata keys are covered as HTTP fields of the same name, and the
+// authority is taken from the `:authority` pseudo-header, or else `host`. Other pseudo-headers
+// aren't copied. Values are covered as given, so binary `-bin` values must be given as sent, ie:
+// base64 encoded. Add the Signature and Signature-Input headers returned by Sign to the metadata
+// under their lowercase names.
+func MessageFromMetadata(md map[string][]string, method, path string) (*Message, error) {
+	header := make(http.Header, len(md))
+	var authority string
+	for key, values := range md {
+		key = strings.ToLower(key)
+		switch {
+		case key == ":authority":
+			if len(values) > 0 {
+				authority = values[0]
+			}
+		case strings.HasPrefix(key, ":"):
+		default:
+			for _, v := range values {
+				header.Add(key, v)
+			}
+		}
+	}
+	if authority == "" {
+		authority = header.Get("Host")
+	}
+
+	return NewMessage(method, authority, path, "", header)
+}
+
+// requestTarget returns the authority and URL of the request, whether it is to be sent by a
+// client or has been received by a server
+func requestTarget(r *http.Request) (string, *url.URL) {
+	authority := r.Host
+	if authority == "" && r.URL != nil {
+		// client requests may only have the authority in their URL
+		authority = r.URL.Host
+	}
+
+	u := r.URL
+	if u != nil && (u.Host == "" || u.Scheme == "") && authority != "" {
+		// server requests only have the path and query in their URL, or for CONNECT requests
+		// only the authority
+		target := *u
+		target.Host = authority
+		if target.Scheme == "" {
+			target.Scheme = "http"
+			if r.TLS != nil {
+				target.Scheme = "https"
+			}
+		}
+		u = &target
+	}
+
+	return authority, u
+}
+
 func parseHeader(values []string) (httpsfv.StructuredFieldValue, error) {
 	list, err := httpsfv.UnmarshalList(values)
 	if err == nil {
@@ -102,24 +206,41 @@ func parseHeader(values []string) (httpsfv.StructuredFieldValue, error) {
 	return nil, errors.New("unable to parse structured header")
 }
 
+// defaultPorts are the default ports of the schemes whose default port is removed from the
+// authority, by lowercase scheme
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ws":    "80",
+	"wss":   "443",
+}
+
 func canonicaliseComponent(component string, params *httpsfv.Params, message *Message) ([]string, error) {
 	_, isReq := params.Get("req")
 	switch component {
 	case "@method":
 		// Section 2.2.1 covers canonicalisation of the method.
 		// https://www.ietf.org/archive/id/draft-ietf-httpbis-message-signatures-19.html#name-method
-		// Method should always be uppercase.
+		// Methods are case-sensitive, so the method is used verbatim rather than uppercased: a
+		// lowercase method is only signed, and verified, as sent.
 		if !message.IsRequest && !isReq {
 			return nil, errors.New("method component not valid for responses")
 		}
-		return []string{strings.ToUpper(message.Method)}, nil
+		return []string{message.Method}, nil
 	case "@target-uri":
 		// Section 2.2.2 covers canonicalisation of the target-uri.
 		// https://www.ietf.org/archive/id/draft-ietf-httpbis-message-signatures-19.html#name-target-uri
 		if !message.IsRequest && !isReq {
 			return nil, errors.New("target-uri component not valid for responses")
 		}
-		return []string{message.URL.String()}, nil
+		// the scheme is case-insensitive, and only lowercased by url.Parse, not in URLs built directly
+		target := *message.URL
+		target.Scheme = strings.ToLower(target.Scheme)
+		if asteriskForm(message) || authorityForm(message) {
+			// the target uri of these requests has an empty path
+			return []string{(&url.URL{Scheme: target.Scheme, Host: target.Host}).String()}, nil
+		}
+		return []string{target.String()}, nil
 	case "@authority":
 		// Section 2.2.3 covers canonicalisation of the target-uri.
 		// https://www.ietf.org/archive/id/draft-ietf-httpbis-message-signatures-19.html#name-authority
@@ -131,15 +252,9 @@ func canonicaliseComponent(component string, params *httpsfv.Params, message *Me
 			// no port, just use the whole thing
 			return []string{strings.ToLower(message.Authority)}, nil
 		}
-		switch strings.ToLower(message.URL.Scheme) {
-		case "http":
-			if port == "80" {
-				return []string{strings.ToLower(host)}, nil
-			}
-		case "https":
-			if port == "443" {
-				return []string{strings.ToLower(host)}, nil
-			}
+		// only the default port of a known scheme can be left out, other schemes keep any port
+		if defaultPort, ok := defaultPorts[strings.ToLower(message.URL.Scheme)]; ok && port == defaultPort {
+			return []string{strings.ToLower(host)}, nil
 		}
 		return []string{strings.ToLower(message.Authority)}, nil
 	case "@scheme":
@@ -156,6 +271,11 @@ func canonicaliseComponent(component string, params *httpsfv.Params, message *Me
 		if !message.IsRequest && !isReq {
 			return nil, errors.New("request-target component not valid for responses")
 		}
+		if authorityForm(message) {
+			// CONNECT requests target the authority, eg: `example.com:443`
+			return []string{message.URL.Host}, nil
+		}
+		// `OPTIONS *` requests target `*`
 		return []string{message.URL.RequestURI()}, nil
 	case "@path":
 		// Section 2.2.6 covers canonicalisation of the path.
@@ -163,19 +283,19 @@ func canonicaliseComponent(component string, params *httpsfv.Params, message *Me
 		if !message.IsRequest && !isReq {
 			return nil, errors.New("path component not valid for responses")
 		}
-		// empty path means use `/`
-		path := message.URL.EscapedPath()
-		if path == "" || path[0] != '/' {
-			path = "/" + path
+		if asteriskForm(message) {
+			// the target uri has an empty path, which is normalised as `/`
+			return []string{"/"}, nil
 		}
-		return []string{path}, nil
+		return []string{canonicalPath(message.URL)}, nil
 	case "@query":
 		// Section 2.2.7 covers canonicalisation of the query.
 		// https://www.ietf.org/archive/id/draft-ietf-httpbis-message-signatures-19.html#name-query
 		if !message.IsRequest && !isReq {
 			return nil, errors.New("query component not valid for responses")
 		}
-		// absent query params means use `?`
+		// absent and empty query strings both mean use `?`; the query is
+		// otherwise used as sent, without reordering or re-encoding
 		return []string{"?" + message.URL.RawQuery}, nil
 	case "@query-param":
 		// Section 2.2.8 covers canonicalisation of the query-param.
@@ -190,21 +310,17 @@ func canonicaliseComponent(component string, params *httpsfv.Params, message *Me
 		if !ok {
 			return nil, errors.New("query-param must have a named parameter")
 		}
-		decodedName, err := url.PathUnescape(name.(string))
-		if err != nil {
-			return nil, fmt.Errorf("unable to decode query parameter name: %w", err)
-		}
-		query := message.URL.Query()
-		if !query.Has(decodedName) {
+		// the name is given encoded, and matches parameters whose decoded names are the same. A
+		// repeated parameter must not be covered, as its values can't be told apart: use @query.
+		values := queryParamValues(message.URL.RawQuery, formDecode(name.(string)))
+		if len(values) == 0 {
 			return nil, fmt.Errorf("expected query parameter \"%s\" not found", name)
 		}
-		var values []string
-		for _, v := range query[decodedName] {
-			decodedValue, err := url.PathUnescape(v)
-			if err != nil {
-				return nil, fmt.Errorf("unable to decode query parameter value: %w", err)
-			}
-			values = append(values, url.PathEscape(decodedValue))
+		if len(values) > 1 {
+			return nil, fmt.Errorf("query parameter %q is repeated", name)
+		}
+		for i, v := range values {
+			values[i] = formEncode(v)
 		}
 		return values, nil
 	case "@status":
@@ -219,22 +335,133 @@ func canonicaliseComponent(component string, params *httpsfv.Params, message *Me
 	}
 }
 
+// queryParamValues returns the decoded values of the query parameters with the given decoded name,
+// parsing the query as application/x-www-form-urlencoded as RFC 9421 requires. Unlike
+// url.ParseQuery, parameters are only separated by `&` and invalid percent-encodings are kept.
+func queryParamValues(query, name string) []string {
+	var values []string
+	for _, param := range strings.Split(query, "&") {
+		if param == "" {
+			continue
+		}
+		n, v, _ := strings.Cut(param, "=")
+		if formDecode(n) == name {
+			values = append(values, formDecode(v))
+		}
+	}
+	return values
+}
+
+// formDecode decodes an application/x-www-form-urlencoded query parameter name or value: `+` is a
+// space and a `%` not followed by two hex digits is kept as is
+func formDecode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '+':
+			b.WriteByte(' ')
+		case s[i] == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]):
+			b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+			i += 2
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// formEncode percent-encodes a query parameter name or value with the
+// application/x-www-form-urlencoded percent-encode set, as RFC 9421 requires. Unlike
+// url.QueryEscape, spaces are encoded as `%20` rather than `+`.
+func formEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '*' || c == '-' || c == '.' || c == '_' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
+
+// asteriskForm reports whether the request targets the whole server, as `OPTIONS *` requests do
+func asteriskForm(message *Message) bool {
+	return message.URL.Opaque == "" && message.URL.Path == "*" && message.URL.RawQuery == ""
+}
+
+// authorityForm reports whether the request targets an authority, as CONNECT requests without a
+// path do
+func authorityForm(message *Message) bool {
+	return message.Method == http.MethodConnect && message.URL.Opaque == "" && message.URL.Path == ""
+}
+
+// canonicalPath returns the percent-encoded path of u. The path as sent
+// (RawPath) is used when it is a valid encoding of Path, otherwise Path is
+// re-encoded, so that a signer and verifier holding differently constructed
+// URLs for the same request agree. Percent-encoded octets use uppercase hex
+// digits and an empty path is serialised as `/`.
+func canonicalPath(u *url.URL) string {
+	path := []byte(u.EscapedPath())
+	for i := 0; i+2 < len(path); i++ {
+		if path[i] == '%' {
+			path[i+1] = upperHex(path[i+1])
+			path[i+2] = upperHex(path[i+2])
+			i += 2
+		}
+	}
+	if len(path) == 0 || path[0] != '/' {
+		path = append([]byte{'/'}, path...)
+	}
+	return string(path)
+}
+
+func upperHex(c byte) byte {
+	if 'a' <= c && c <= 'f' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
+
 func canonicaliseHeader(header string, params *httpsfv.Params, message *Message) ([]string, error) {
 	var v []string
-	if _, isReq := params.Get("req"); isReq {
+	_, isReq := params.Get("req")
+	_, isTr := params.Get("tr")
+	if isTr {
+		// trailers of requests aren't available until after they are sent, so can't be signed
+		if message.IsRequest || isReq {
+			return nil, errors.New("tr parameter not valid for requests")
+		}
+		v = headerValues(message.Trailer, header)
+	} else if isReq {
 		if message.IsRequest {
 			return nil, errors.New("req parameter not valid for requests")
 		}
 		if message.RequestHeader == nil {
 			return nil, errors.New("req parameter requires a request header")
 		}
-		v = message.RequestHeader.Values(header)
+		v = headerValues(*message.RequestHeader, header)
 	} else {
-		v = message.Header.Values(header)
+		v = headerValues(message.Header, header)
 	}
 	if len(v) == 0 {
 		// empty values are permitted, but no values are not
-		return nil, fmt.Errorf("header not found: %s", header)
+		return nil, fmt.Errorf("%w: %s", ErrMissingCoveredComponent, header)
 	}
 
 	_, isBs := params.Get("bs")
@@ -245,34 +472,36 @@ func canonicaliseHeader(header string, params *httpsfv.Params, message *Message)
 		return nil, errors.New("cannot have both `bs` and (implicit) `sf` parameters")
 	}
 
-	if isSf || isKey {
-		// strict encoding of field
-		parsed, err := parseHeader(v)
+	if isKey {
+		// the key parameter always refers to a member of a dictionary, so parse it as one rather
+		// than guessing the type (a dictionary of bare keys, eg: `a, b`, is also a valid list)
+		dict, err := httpsfv.UnmarshalDictionary(v)
 		if err != nil {
-			return nil, err
+			return nil, errors.New("unable to parse header as dictionary")
 		}
 
-		if isKey {
-			dict, ok := parsed.(*httpsfv.Dictionary)
-			if !ok {
-				return nil, errors.New("unable to parse header as dictionary")
-			}
+		if _, ok := key.(string); !ok {
+			return nil, errors.New("key parameter must be a string")
+		}
 
-			if _, ok := key.(string); !ok {
-				return nil, errors.New("key parameter must be a string")
-			}
+		val, ok := dict.Get(key.(string))
+		if !ok {
+			return nil, fmt.Errorf("unable to find key \"%s\" in structured field", key)
+		}
 
-			val, ok := dict.Get(key.(string))
-			if !ok {
-				return nil, fmt.Errorf("unable to find key \"%s\" in structured field", key)
-			}
+		marshalled, err := httpsfv.Marshal(val)
+		if err != nil {
+			return nil, err
+		}
 
-			marshalled, err := httpsfv.Marshal(val)
-			if err != nil {
-				return nil, err
-			}
+		return []string{marshalled}, nil
+	}
 
-			return []string{marshalled}, nil
+	if isSf {
+		// strict encoding of field
+		parsed, err := parseHeader(v)
+		if err != nil {
+			return nil, err
 		}
 
 		marshalled, err := httpsfv.Marshal(parsed)
@@ -284,14 +513,11 @@ func canonicaliseHeader(header string, params *httpsfv.Params, message *Message)
 	}
 
 	if isBs {
+		// Section 2.1.3 only trims each value before encoding its bytes
+		// https://www.ietf.org/archive/id/draft-ietf-httpbis-message-signatures-19.html#name-binary-wrapped-http-fields
 		encoded := make([]string, len(v))
 		for i, sv := range v {
-			regex := regexp.MustCompile(`\s+`)
-			values := strings.Split(sv, ",")
-			for j, v := range values {
-				values[j] = regex.ReplaceAllString(strings.TrimSpace(v), " ")
-			}
-			item := httpsfv.NewItem([]byte(strings.Join(values, ", ")))
+			item := httpsfv.NewItem([]byte(strings.Trim(sv, ows)))
 			marshalled, err := httpsfv.Marshal(item)
 			if err != nil {
 				return nil, err
@@ -304,17 +530,97 @@ func canonicaliseHeader(header string, params *httpsfv.Params, message *Message)
 
 	// raw encoding
 	encoded := make([]string, len(v))
-	regex := regexp.MustCompile(`\s+`)
 	for i, sv := range v {
-		values := strings.Split(sv, ",")
-		for j, v := range values {
-			values[j] = regex.ReplaceAllString(strings.TrimSpace(v), " ")
-		}
-		encoded[i] = strings.Join(values, ", ")
+		encoded[i] = canonicalFieldValue(sv)
 	}
 	return encoded, nil
 }
 
+// ows is the optional whitespace around HTTP field values
+const ows = " \t"
+
+// obsFold matches obsolete line folding within an HTTP field value, a line break followed by
+// whitespace, with any whitespace before it
+var obsFold = regexp.MustCompile(`[ \t]*\r?\n[ \t]+`)
+
+// canonicalFieldValue returns the raw value of an HTTP field as covered by a signature. Section
+// 2.1 strips leading and trailing whitespace and replaces obsolete line folding with a single
+// space, leaving whitespace within the value as it is.
+// https://www.ietf.org/archive/id/draft-ietf-httpbis-message-signatures-19.html#name-http-fields
+func canonicalFieldValue(v string) string {
+	if strings.Contains(v, "\n") {
+		// only a line break can start a fold, so most values skip the slower regular expression
+		v = obsFold.ReplaceAllString(v, " ")
+	}
+	return strings.Trim(v, ows)
+}
+
+// canonicalContentType returns the value of a Content-Type header with its media type reformatted
+// canonically: the type, subtype, parameter names and charset lowercase, and the parameters
+// sorted. Values that can't be parsed as a media type are returned as they are.
+func canonicalContentType(v string) string {
+	mediaType, params, err := mime.ParseMediaType(v)
+	if err != nil {
+		return v
+	}
+	if charset, ok := params["charset"]; ok {
+		params["charset"] = strings.ToLower(charset)
+	}
+	if formatted := mime.FormatMediaType(mediaType, params); formatted != "" {
+		return formatted
+	}
+	return v
+}
+
+// headerValues returns every value of the named header. Values stored under the canonical key
+// come first, followed by any stored under non-canonical keys (eg: when the header map was
+// populated directly) so that no occurrence of a repeated field is dropped from the signature.
+func headerValues(header http.Header, name string) []string {
+	canonical := textproto.CanonicalMIMEHeaderKey(name)
+	values := header.Values(canonical)
+
+	var others []string
+	for k := range header {
+		if k != canonical && strings.EqualFold(k, name) {
+			others = append(others, k)
+		}
+	}
+	slices.Sort(others)
+	for _, k := range others {
+		values = append(values, header[k]...)
+	}
+
+	return values
+}
+
+// hasHeader reports whether the header has the named field, however it is cased, as headerValues
+// would find it
+func hasHeader(header http.Header, name string) bool {
+	for k := range header {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// validFieldName reports whether the name is a valid field name, a non-empty token of the
+// characters RFC 9110 allows
+func validFieldName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range []byte(name) {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 func quoteString(input string) string {
 	// if it's not quoted, attempt to quote
 	if !strings.HasPrefix(input, `"`) {
@@ -339,13 +645,13 @@ func formatSignatureBase(items []signatureItem) (string, error) {
 			return "", err
 		}
 
-		value := strings.Join(item.value, ", ")
-
-		_, err = b.WriteString(fmt.Sprintf("%s: %s\n", marshalledKey, value))
-		if err != nil {
-			return "", err
+		if b.Len() > 0 {
+			b.WriteByte('\n')
 		}
+		b.WriteString(marshalledKey)
+		b.WriteString(": ")
+		b.WriteString(strings.Join(item.value, ", "))
 	}
 
-	return strings.TrimRight(b.String(), "\n"), nil
+	return b.String(), nil
 }
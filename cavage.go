@@ -0,0 +1,389 @@
+// BSD 3-Clause License
+
+// Copyright (c) 2021, James Bowes
+// Copyright (c) 2023, Alexander Taraymovich, OffBlocks
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package httpsig
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dunglas/httpsfv"
+)
+
+// Signing and verifying compatible with draft-cavage-http-signatures-12, for peers that predate
+// RFC 9421. In this mode a single Signature header is used, with the key id, algorithm, covered
+// headers and signature as comma separated parameters:
+//
+//	Signature: keyId="key1",algorithm="hs2019",created=1402170695,headers="(request-target) (created) host",signature="..."
+//
+// Fields are named as in the draft: lowercase header names and the `(request-target)`, `(created)`
+// and `(expires)` pseudo-headers. ECDSA signatures are encoded as in RFC 9421.
+//
+// https://datatracker.ietf.org/doc/html/draft-cavage-http-signatures-12
+
+// cavageAlgorithms are the names of the algorithms defined by the Cavage draft. Signatures using
+// any other algorithm are marked as "hs2019", leaving the algorithm to be derived from the key.
+var cavageAlgorithms = map[Algorithm]string{
+	AlgorithmRsaPkcs1v15Sha256: "rsa-sha256",
+	AlgorithmEcdsaP256Sha256:   "ecdsa-sha256",
+	AlgorithmHmacSha256:        "hmac-sha256",
+}
+
+const cavageHs2019 = "hs2019"
+
+// cavageSignature is a parsed Cavage Signature header
+type cavageSignature struct {
+	KeyID     string
+	Algorithm string
+	Created   *time.Time
+	Expires   *time.Time
+	Headers   []string
+	Signature []byte
+}
+
+// createCavageSigningString creates the string signed for the given headers of the message
+func createCavageSigningString(headers []string, msg *Message, created, expires *time.Time) (string, error) {
+	lines := make([]string, 0, len(headers))
+	for _, h := range headers {
+		var value string
+		switch h {
+		case "(request-target)":
+			if !msg.IsRequest {
+				return "", errors.New("request-target component not valid for responses")
+			}
+			value = strings.ToLower(msg.Method) + " " + msg.URL.RequestURI()
+		case "(created)":
+			if created == nil {
+				return "", errors.New("created component requires a created time")
+			}
+			value = strconv.FormatInt(created.Unix(), 10)
+		case "(expires)":
+			if expires == nil {
+				return "", errors.New("expires component requires an expiry time")
+			}
+			value = strconv.FormatInt(expires.Unix(), 10)
+		default:
+			values := headerValues(msg.Header, h)
+			if len(values) == 0 && h == "host" && msg.Authority != "" {
+				values = []string{msg.Authority}
+			}
+			if len(values) == 0 {
+				return "", fmt.Errorf("%w: %s", ErrMissingCoveredComponent, h)
+			}
+			for i := range values {
+				values[i] = strings.TrimSpace(values[i])
+			}
+			value = strings.Join(values, ", ")
+		}
+		lines = append(lines, h+": "+value)
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// cavageHeaders returns the headers to cover for the given fields, defaulting to `(created)` as
+// the draft does when none are given
+func cavageHeaders(fields []string) []string {
+	if len(fields) == 0 {
+		return []string{"(created)"}
+	}
+	headers := make([]string, len(fields))
+	for i, f := range fields {
+		headers[i] = cavageHeader(f)
+	}
+	return headers
+}
+
+// cavageHeader returns the header name for a field, which may be given as a structured field string
+func cavageHeader(field string) string {
+	item, err := httpsfv.UnmarshalItem([]string{quoteString(field)})
+	if err != nil {
+		return strings.ToLower(field)
+	}
+	if name, ok := item.Value.(string); ok {
+		return strings.ToLower(name)
+	}
+	return strings.ToLower(field)
+}
+
+func (s *signer) signCavage(msg *Message, config SignConfig) (http.Header, error) {
+	if len(config.Signatures) > 0 {
+		return nil, errors.New("additional signatures not supported with cavage compatibility")
+	}
+
+	var created, expires *time.Time
+	if slices.Contains(config.Params, ParamCreated) {
+		now := time.Now()
+		created = &now
+		if config.ParamValues != nil && config.ParamValues.Created != nil {
+			created = config.ParamValues.Created
+		}
+	}
+	if slices.Contains(config.Params, ParamExpires) && config.ParamValues != nil {
+		expires = config.ParamValues.Expires
+	}
+
+	headers := cavageHeaders(config.Fields)
+	signingString, err := createCavageSigningString(headers, msg, created, expires)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := signWith(msg, config.Key, config.Rand, []byte(signingString))
+	if err != nil {
+		return nil, err
+	}
+
+	keyID := config.Key.GetKeyID()
+	if config.ParamValues != nil && config.ParamValues.KeyID != nil {
+		keyID = *config.ParamValues.KeyID
+	}
+	algorithm, ok := cavageAlgorithms[config.Key.GetAlgorithm()]
+	if !ok {
+		algorithm = cavageHs2019
+	}
+
+	params := []string{
+		"keyId=" + cavageQuote(keyID),
+		"algorithm=" + cavageQuote(algorithm),
+	}
+	if created != nil {
+		params = append(params, fmt.Sprintf("created=%d", created.Unix()))
+	}
+	if expires != nil {
+		params = append(params, fmt.Sprintf("expires=%d", expires.Unix()))
+	}
+	params = append(params,
+		"headers="+cavageQuote(strings.Join(headers, " ")),
+		"signature="+cavageQuote(base64.StdEncoding.EncodeToString(signature)),
+	)
+
+	msg.Header.Set(SignatureHeader, strings.Join(params, ","))
+
+	return msg.Header, nil
+}
+
+// cavageQuoter escapes the characters of a quoted string that must be sent as quoted pairs
+var cavageQuoter = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// cavageQuote returns the value as a quoted string, as the parameters of a Cavage Signature header
+// are sent, escaping any quotes and backslashes it has, eg: in key ids
+func cavageQuote(value string) string {
+	return `"` + cavageQuoter.Replace(value) + `"`
+}
+
+// cavageUnquote parses the quoted string at the start of s, returning its value with any quoted
+// pairs unescaped, and the rest of s after the closing quote
+func cavageUnquote(s string) (value, rest string, ok bool) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return b.String(), s[i+1:], true
+		case '\\':
+			i++
+			if i == len(s) {
+				return "", "", false
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return "", "", false
+}
+
+// parseCavageSignature parses a Cavage Signature header
+func parseCavageSignature(header string) (*cavageSignature, error) {
+	sig := cavageSignature{Headers: []string{"(created)"}}
+	var hasKeyID, hasSignature bool
+
+	rest := strings.TrimSpace(header)
+	for rest != "" {
+		name, raw, ok := strings.Cut(rest, "=")
+		if !ok {
+			return nil, ErrMalformedSignature
+		}
+		name = strings.TrimSpace(name)
+		raw = strings.TrimLeft(raw, " \t")
+
+		var value string
+		if strings.HasPrefix(raw, `"`) {
+			var ok bool
+			if value, rest, ok = cavageUnquote(raw); !ok {
+				return nil, ErrMalformedSignature
+			}
+		} else {
+			end := strings.IndexByte(raw, ',')
+			if end < 0 {
+				end = len(raw)
+			}
+			value, rest = strings.TrimSpace(raw[:end]), raw[end:]
+		}
+		rest = strings.TrimLeft(rest, " \t")
+		if rest != "" {
+			if rest[0] != ',' {
+				return nil, ErrMalformedSignature
+			}
+			rest = strings.TrimLeft(rest[1:], " \t")
+		}
+
+		switch name {
+		case "keyId":
+			sig.KeyID, hasKeyID = value, true
+		case "algorithm":
+			sig.Algorithm = value
+		case "created", "expires":
+			unix, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, malformedSignature(err)
+			}
+			t := time.Unix(unix, 0)
+			if name == "created" {
+				sig.Created = &t
+			} else {
+				sig.Expires = &t
+			}
+		case "headers":
+			sig.Headers = strings.Fields(strings.ToLower(value))
+		case "signature":
+			signature, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, malformedSignature(err)
+			}
+			sig.Signature, hasSignature = signature, true
+		}
+	}
+
+	if !hasKeyID || !hasSignature {
+		return nil, ErrMalformedSignature
+	}
+
+	return &sig, nil
+}
+
+func (v *verifier) verifyCavage(msg *Message, ev *VerifyEvent, verified *[]SignatureInput) error {
+	header := msg.Header.Get(SignatureHeader)
+	if header == "" {
+		return ErrNoSignature
+	}
+
+	sig, err := parseCavageSignature(header)
+	if err != nil {
+		return err
+	}
+	ev.KeyID = sig.KeyID
+
+	params := &SignatureParameters{KeyID: &sig.KeyID, Created: sig.Created, Expires: sig.Expires}
+	for alg, name := range cavageAlgorithms {
+		if name == sig.Algorithm {
+			alg := alg
+			params.Alg = &alg
+		}
+	}
+	if params.Alg == nil && sig.Algorithm != "" && sig.Algorithm != cavageHs2019 {
+		return ErrAlgorithmMismatch
+	}
+	if params.Alg != nil {
+		ev.Algorithm = *params.Alg
+	}
+
+	fields := make([]string, 0, len(sig.Headers))
+	for _, h := range sig.Headers {
+		fields = append(fields, quoteString(h))
+	}
+	input := SignatureInput{SignatureParameters: *params, Fields: fields}
+	keys, err := v.keysForInput(msg, input)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return ErrUnknownKeyID
+	}
+	ev.Algorithm = keys[0].GetAlgorithm()
+
+	for _, param := range v.config.RequiredParams {
+		var ok bool
+		switch param {
+		case string(ParamKeyID):
+			ok = true
+		case string(ParamAlg):
+			ok = sig.Algorithm != ""
+		case string(ParamCreated):
+			ok = sig.Created != nil
+		case string(ParamExpires):
+			ok = sig.Expires != nil
+		}
+		if !ok {
+			return ErrMalformedSignature
+		}
+	}
+
+	for _, field := range v.config.RequiredFields {
+		if !slices.Contains(sig.Headers, cavageHeader(field)) {
+			return ErrMalformedSignature
+		}
+	}
+	for _, field := range v.config.OptionalFields {
+		if hasHeader(msg.Header, field) && !slices.Contains(sig.Headers, field) {
+			return ErrMalformedSignature
+		}
+	}
+
+	if err := v.checkTimes(msg.Context, params); err != nil {
+		return err
+	}
+
+	signingString, err := createCavageSigningString(sig.Headers, msg, sig.Created, sig.Expires)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		ev.Algorithm = key.GetAlgorithm()
+		if err = key.Verify([]byte(signingString), sig.Signature); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return invalidSignature(err)
+	}
+
+	if verified != nil {
+		*verified = append(*verified, input)
+	}
+
+	return nil
+}
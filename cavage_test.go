@@ -0,0 +1,222 @@
+// BSD 3-Clause License
+
+// Copyright (c) 2021, James Bowes
+// Copyright (c) 2023, Alexander Taraymovich, OffBlocks
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package httpsig
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateCavageSigningString(t *testing.T) {
+	created := time.Unix(1618884473, 0)
+	expires := time.Unix(1618884773, 0)
+
+	t.Run("request target and headers", func(t *testing.T) {
+		str, err := createCavageSigningString([]string{"(request-target)", "host", "date", "content-type"}, MessageFromRequest(testReq()), nil, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "(request-target): post /foo?param=Value&Pet=dog\nhost: example.com\ndate: Tue, 20 Apr 2021 02:07:55 GMT\ncontent-type: application/json", str)
+	})
+	t.Run("created and expires", func(t *testing.T) {
+		str, err := createCavageSigningString([]string{"(created)", "(expires)"}, MessageFromRequest(testReq()), &created, &expires)
+		assert.NoError(t, err)
+		assert.Equal(t, "(created): 1618884473\n(expires): 1618884773", str)
+	})
+	t.Run("host from the authority", func(t *testing.T) {
+		msg := MessageFromRequest(testReq())
+		msg.Header.Del("Host")
+		str, err := createCavageSigningString([]string{"host"}, msg, nil, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "host: example.com", str)
+	})
+	t.Run("repeated headers", func(t *testing.T) {
+		msg := MessageFromRequest(testReq())
+		msg.Header["Cache-Control"] = []string{" max-age=60 ", "must-revalidate"}
+		str, err := createCavageSigningString([]string{"cache-control"}, msg, nil, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "cache-control: max-age=60, must-revalidate", str)
+	})
+	t.Run("errors", func(t *testing.T) {
+		_, err := createCavageSigningString([]string{"x-missing"}, MessageFromRequest(testReq()), nil, nil)
+		assert.EqualError(t, err, "header not found: x-missing")
+		_, err = createCavageSigningString([]string{"(created)"}, MessageFromRequest(testReq()), nil, nil)
+		assert.EqualError(t, err, "created component requires a created time")
+		_, err = createCavageSigningString([]string{"(request-target)"}, MessageFromResponse(testResp()), nil, nil)
+		assert.EqualError(t, err, "request-target component not valid for responses")
+	})
+}
+
+func TestParseCavageSignature(t *testing.T) {
+	t.Run("all parameters", func(t *testing.T) {
+		sig, err := parseCavageSignature(`keyId="test-key-a", algorithm="hs2019", created=1402170695, expires=1402170995, headers="(request-target) (created) Host", signature="c2ln"`)
+		assert.NoError(t, err)
+		created, expires := time.Unix(1402170695, 0), time.Unix(1402170995, 0)
+		assert.Equal(t, &cavageSignature{
+			KeyID:     "test-key-a",
+			Algorithm: "hs2019",
+			Created:   &created,
+			Expires:   &expires,
+			Headers:   []string{"(request-target)", "(created)", "host"},
+			Signature: []byte("sig"),
+		}, sig)
+	})
+	t.Run("quoted pairs", func(t *testing.T) {
+		sig, err := parseCavageSignature(`keyId="https://keys.example.com/k?\"weird\"\\",signature="c2ln"`)
+		assert.NoError(t, err)
+		assert.Equal(t, `https://keys.example.com/k?"weird"\`, sig.KeyID)
+		assert.Equal(t, []byte("sig"), sig.Signature)
+	})
+	t.Run("default headers", func(t *testing.T) {
+		sig, err := parseCavageSignature(`keyId="test-key-a",signature="c2ln"`)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"(created)"}, sig.Headers)
+	})
+	t.Run("errors", func(t *testing.T) {
+		for _, header := range []string{
+			`signature="c2ln"`,
+			`keyId="test-key-a"`,
+			`keyId="test-key-a,signature="c2ln"`,
+			`keyId="test-key-a" signature="c2ln"`,
+			`keyId="test-key-a",created=yesterday,signature="c2ln"`,
+			`keyId="test-key-a",signature="not base64"`,
+			`keyId="test-key-a\",signature="c2ln"`,
+			`keyId="test-key-a\`,
+		} {
+			_, err := parseCavageSignature(header)
+			assert.ErrorIs(t, err, ErrMalformedSignature, header)
+		}
+	})
+}
+
+func TestRoundtrip_Cavage(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	created := time.Unix(1618884473, 0)
+	s := NewSigner(
+		WithHmacSha256("test-shared-secret", k),
+		WithCavageCompat(true),
+		WithSignFields("(request-target)", "(created)", "host", "date"),
+		WithSignParams(ParamCreated),
+		WithSignParamValues(&SignatureParameters{Created: &created}),
+	)
+
+	signed := func(t *testing.T) *Message {
+		msg := MessageFromRequest(testReq())
+		hdr, err := s.Sign(msg)
+		assert.NoError(t, err)
+		msg.Header = hdr
+		return msg
+	}
+
+	v := NewVerifier(
+		WithHmacSha256("test-shared-secret", k),
+		WithCavageCompat(true),
+		WithVerifyRequiredFields("(request-target)", "date"),
+	)
+	v.clock = &testClock{now: created}
+
+	t.Run("signs in the draft format", func(t *testing.T) {
+		msg := signed(t)
+		assert.Equal(t, `keyId="test-shared-secret",algorithm="hmac-sha256",created=1618884473,headers="(request-target) (created) host date",signature="7wtbdw36sssC7/KhLQwmJ5dvgjkKXsSR8tbIO/ocNa4="`, msg.Header.Get(SignatureHeader))
+		assert.Empty(t, msg.Header.Values(SignatureInputHeader))
+	})
+	t.Run("verifies", func(t *testing.T) {
+		assert.NoError(t, v.Verify(signed(t)))
+	})
+	t.Run("rejects a modified message", func(t *testing.T) {
+		msg := signed(t)
+		msg.Method = "PUT"
+		assert.ErrorIs(t, v.Verify(msg), ErrSignatureInvalid)
+	})
+	t.Run("returns the signing string as verified", func(t *testing.T) {
+		str, err := v.SignatureBase(signed(t), "")
+		assert.NoError(t, err)
+		assert.Equal(t, "(request-target): post /foo?param=Value&Pet=dog\n(created): 1618884473\nhost: example.com\ndate: Tue, 20 Apr 2021 02:07:55 GMT", str)
+	})
+	t.Run("rejects missing required fields", func(t *testing.T) {
+		v := NewVerifier(
+			WithHmacSha256("test-shared-secret", k),
+			WithCavageCompat(true),
+			WithVerifyRequiredFields("digest"),
+		)
+		v.clock = &testClock{now: created}
+		assert.ErrorIs(t, v.Verify(signed(t)), ErrMalformedSignature)
+	})
+	t.Run("rejects expired signatures", func(t *testing.T) {
+		v := NewVerifier(
+			WithHmacSha256("test-shared-secret", k),
+			WithCavageCompat(true),
+			WithVerifyMaxAge(time.Minute),
+		)
+		v.clock = &testClock{now: created.Add(time.Hour)}
+		assert.ErrorIs(t, v.Verify(signed(t)), ErrSignatureExpired)
+	})
+	t.Run("rejects an unknown key", func(t *testing.T) {
+		v := NewVerifier(
+			WithHmacSha256("another-shared-secret", k),
+			WithCavageCompat(true),
+		)
+		assert.ErrorIs(t, v.Verify(signed(t)), ErrUnknownKeyID)
+	})
+	t.Run("rejects a declared algorithm that differs from the key", func(t *testing.T) {
+		msg := signed(t)
+		msg.Header.Set(SignatureHeader, `keyId="test-shared-secret",algorithm="rsa-sha256",created=1618884473,headers="(request-target) (created) host date",signature="7wtbdw36sssC7/KhLQwmJ5dvgjkKXsSR8tbIO/ocNa4="`)
+		assert.ErrorIs(t, v.Verify(msg), ErrAlgorithmMismatch)
+	})
+	t.Run("uses hs2019 for algorithms not in the draft", func(t *testing.T) {
+		pub, priv, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err)
+
+		s := NewSigner(
+			WithSignEd25519("ed-key", priv),
+			WithCavageCompat(true),
+		)
+		msg := MessageFromRequest(testReq())
+		hdr, err := s.Sign(msg)
+		assert.NoError(t, err)
+		assert.Contains(t, hdr.Get(SignatureHeader), `keyId="ed-key",algorithm="hs2019",created=`)
+		assert.Contains(t, hdr.Get(SignatureHeader), `headers="(created)"`)
+
+		v := NewVerifier(
+			WithVerifyEd25519("ed-key", pub),
+			WithCavageCompat(true),
+		)
+		msg.Header = hdr
+		assert.NoError(t, v.Verify(msg))
+	})
+}
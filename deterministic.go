@@ -0,0 +1,72 @@
+//go:build go1.24
+
+// BSD 3-Clause License
+
+// Copyright (c) 2021, James Bowes
+// Copyright (c) 2023, Alexander Taraymovich, OffBlocks
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package httpsig
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+)
+
+// signDeterministic signs the hash of the data with the private key, with the nonce derived from
+// the key and hash as described by RFC 6979, returning the concatenated r and low s values. The key
+// must be on the given curve, the curve of the algorithm signed with.
+// https://www.rfc-editor.org/rfc/rfc6979#section-3.2
+func signDeterministic(pk *ecdsa.PrivateKey, curve elliptic.Curve, hash crypto.Hash, data []byte) ([]byte, error) {
+	if pk.Curve != curve {
+		return nil, fmt.Errorf("key isn't on curve %s", curve.Params().Name)
+	}
+
+	h := hash.New()
+	h.Write(data)
+
+	// crypto/ecdsa signs deterministically without a source of randomness since Go 1.24
+	der, err := pk.Sign(nil, h.Sum(nil), hash)
+	if err != nil {
+		return nil, err
+	}
+	var sig struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, err
+	}
+
+	size := (pk.Curve.Params().N.BitLen() + 7) / 8
+	signature := make([]byte, 2*size)
+	sig.R.FillBytes(signature[:size])
+	lowS(pk.Curve, sig.S).FillBytes(signature[size:])
+	return signature, nil
+}
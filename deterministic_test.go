@@ -0,0 +1,139 @@
+//go:build go1.24
+
+// BSD 3-Clause License
+
+// Copyright (c) 2021, James Bowes
+// Copyright (c) 2023, Alexander Taraymovich, OffBlocks
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package httpsig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeterministicEcdsaSigningKey(t *testing.T) {
+	hexInt := func(s string) *big.Int {
+		i, ok := new(big.Int).SetString(s, 16)
+		if !ok {
+			panic("invalid hex integer")
+		}
+		return i
+	}
+	privateKey := func(curve elliptic.Curve, d string) *ecdsa.PrivateKey {
+		pk := &ecdsa.PrivateKey{D: hexInt(d)}
+		pk.Curve = curve
+		pk.X, pk.Y = curve.ScalarBaseMult(pk.D.Bytes())
+		return pk
+	}
+
+	// https://www.rfc-editor.org/rfc/rfc6979#appendix-A.2.5 and A.2.6, signing "sample"
+	for _, tc := range []struct {
+		name string
+		pk   *ecdsa.PrivateKey
+		r, s string
+	}{
+		{
+			name: "P-256",
+			pk:   privateKey(elliptic.P256(), "C9AFA9D845BA75166B5C215767B1D6934E50C3DB36E89B127B8A622B120F6721"),
+			r:    "EFD48B2AACB6A8FD1140DD9CD45E81D69D2C877B56AAF991C34D0EA84EAF3716",
+			s:    "F7CB1C942D657C41D436C7A1B6E29F65F3E900DBB9AFF4064DC4AB2F843ACDA8",
+		},
+		{
+			name: "P-384",
+			pk:   privateKey(elliptic.P384(), "6B9D3DAD2E1B8C1C05B19875B6659F4DE23C3B667BF297BA9AA47740787137D896D5724E4C70A825F872C9EA60D2EDF5"),
+			r:    "94EDBB92A5ECB8AAD4736E56C691916B3F88140666CE9FA73D64C4EA95AD133C81A648152E44ACF96E36DD1E80FABE46",
+			s:    "99EF4AEB15F178CEA1FE40DB2603138F130E740A19624526203B6351D0A3A94FA329C145786E679E7B82C71A38628AC8",
+		},
+	} {
+		t.Run("matches the RFC 6979 test vector for "+tc.name, func(t *testing.T) {
+			var key SigningKey = &DeterministicEcdsaP256SigningKey{tc.pk, "test-key"}
+			if tc.pk.Curve == elliptic.P384() {
+				key = &DeterministicEcdsaP384SigningKey{tc.pk, "test-key"}
+			}
+			signature, err := key.Sign([]byte("sample"))
+			assert.NoError(t, err)
+
+			size := len(signature) / 2
+			assert.Equal(t, hexInt(tc.r), new(big.Int).SetBytes(signature[:size]))
+			// signatures are always made with the low s value
+			s := hexInt(tc.s)
+			if n := tc.pk.Curve.Params().N; s.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+				s.Sub(n, s)
+			}
+			assert.Equal(t, s, new(big.Int).SetBytes(signature[size:]))
+		})
+	}
+
+	t.Run("signs identically every time", func(t *testing.T) {
+		for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384()} {
+			pk, err := ecdsa.GenerateKey(curve, rand.Reader)
+			assert.NoError(t, err)
+			sign, verify := WithSignEcdsaP256Sha256Deterministic("test-key", pk), WithVerifyEcdsaP256Sha256("test-key", &pk.PublicKey)
+			if curve == elliptic.P384() {
+				sign, verify = WithSignEcdsaP384Sha384Deterministic("test-key", pk), WithVerifyEcdsaP384Sha384("test-key", &pk.PublicKey)
+			}
+			s := NewSigner(sign, WithSignFields("@method", "@path"), WithSignParams(ParamKeyID, ParamAlg))
+
+			first, err := s.Sign(MessageFromRequest(testReq()))
+			assert.NoError(t, err)
+			second, err := s.Sign(MessageFromRequest(testReq()))
+			assert.NoError(t, err)
+			assert.Equal(t, first.Get(SignatureHeader), second.Get(SignatureHeader))
+
+			req := testReq()
+			req.Header = first
+			assert.NoError(t, NewVerifier(verify, WithVerifyRequireLowS(true)).Verify(MessageFromRequest(req)))
+		}
+	})
+	t.Run("rejects other curves", func(t *testing.T) {
+		p224, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+		assert.NoError(t, err)
+		p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.NoError(t, err)
+		p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		assert.NoError(t, err)
+
+		for _, key := range []SigningKey{
+			&DeterministicEcdsaP256SigningKey{p224, "test-key"},
+			&DeterministicEcdsaP384SigningKey{p224, "test-key"},
+			// a key of the other curve would sign a different hash than the algorithm declares
+			&DeterministicEcdsaP256SigningKey{p384, "test-key"},
+			&DeterministicEcdsaP384SigningKey{p256, "test-key"},
+		} {
+			_, err := key.Sign([]byte("sample"))
+			assert.ErrorContains(t, err, "key isn't on curve", key.GetAlgorithm())
+		}
+	})
+}
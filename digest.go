@@ -32,21 +32,200 @@
 package httpsig
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"crypto/sha512"
 	"crypto/subtle"
 	"errors"
+	"fmt"
+	"hash"
+	"io"
 	"net/http"
+	"slices"
+	"sync"
 
 	"github.com/dunglas/httpsfv"
 )
 
 type DigestConfig struct {
-	// List of digest algorithms to use when creating a digest header.
+	// List of digest algorithms to use when creating a digest header. When verifying, at least one
+	// of these must be present in the digest header and every one present must match.
 	// default: sha-256
 	Algorithms []DigestAlgorithm
+
+	// The header the digest is created in and verified from, either Content-Digest or Repr-Digest.
+	// The body is taken to be the representation, so both digest the same bytes.
+	// default: Content-Digest
+	Header string
+}
+
+// ErrDigestMismatch is returned when a body doesn't match its Content-Digest header
+var ErrDigestMismatch = errors.New("digest mismatch")
+
+// ErrDigestAlgorithmNotAllowed is returned when verifying a request whose Content-Digest header
+// has no digest using an allowed algorithm
+var ErrDigestAlgorithmNotAllowed = errors.New("digest algorithm not allowed")
+
+// ErrUnsignedDigest is returned when verifying a request whose digest header isn't covered
+// by a signature, with WithVerifyAllowUnsignedDigest(false)
+var ErrUnsignedDigest = errors.New("digest not signed")
+
+// errNoDigestAlgorithm is returned when a digest header has none of the configured algorithms
+var errNoDigestAlgorithm = errors.New("no supported digest algorithm in digest header")
+
+// ErrDigestRequired is returned when verifying a request without the digest header its content
+// type requires
+var ErrDigestRequired = errors.New("digest required")
+
+// ErrBodyTooLarge is returned when a request body is larger than the configured body buffer
+// limit
+var ErrBodyTooLarge = errors.New("body too large")
+
+// ErrBodyConsumed is returned when verifying the digest of a request whose body was already read,
+// eg: by a middleware that ran before verification, so is empty despite its Content-Length
+var ErrBodyConsumed = errors.New("body already read")
+
+// readBody reads the body of the request in full, replacing it so it can be read again. GetBody
+// is replaced too, so retries and redirects send the same bytes. If limit is positive, bodies
+// larger than limit bytes aren't read in full and fail with ErrBodyTooLarge.
+func readBody(r *http.Request, limit int64) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	if limit > 0 && r.ContentLength > limit {
+		return nil, ErrBodyTooLarge
+	}
+
+	body, err := readAll(r.Body, limit)
+	if err != nil {
+		return nil, err
+	}
+	setBody(r, body)
+
+	return body, nil
+}
+
+// readGetBody reads a new copy of the body of the request in full from getBody, eg: the GetBody
+// of the request before its body was read, replacing the body with it. If limit is positive,
+// bodies larger than limit bytes fail with ErrBodyTooLarge.
+func readGetBody(r *http.Request, getBody func() (io.ReadCloser, error), limit int64) ([]byte, error) {
+	rc, err := getBody()
+	if err != nil {
+		return nil, err
+	}
+	if rc == nil || rc == http.NoBody {
+		return nil, nil
+	}
+	body, err := readAll(rc, limit)
+	if err != nil {
+		return nil, err
+	}
+	setBody(r, body)
+
+	return body, nil
 }
 
+// setBody replaces the body of the request, and GetBody, with the given bytes
+func setBody(r *http.Request, body []byte) {
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+}
+
+// readAll reads the body in full and closes it. If limit is positive, bodies larger than limit
+// bytes fail with ErrBodyTooLarge.
+func readAll(body io.ReadCloser, limit int64) ([]byte, error) {
+	var reader io.Reader = body
+	if limit > 0 {
+		reader = io.LimitReader(body, limit+1)
+	}
+	b, err := io.ReadAll(reader)
+	if err != nil {
+		_ = body.Close()
+		return nil, err
+	}
+	if limit > 0 && int64(len(b)) > limit {
+		_ = body.Close()
+		return nil, ErrBodyTooLarge
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// builtinDigestAlgorithms are the digest algorithms supported without registering them
+var builtinDigestAlgorithms = []DigestAlgorithm{DigestAlgorithmSha256, DigestAlgorithmSha512}
+
+// registeredDigestAlgorithms are the digest algorithms added with RegisterDigestAlgorithm
+var registeredDigestAlgorithms struct {
+	sync.RWMutex
+	names  []DigestAlgorithm
+	hashes map[DigestAlgorithm]func() hash.Hash
+}
+
+// RegisterDigestAlgorithm adds a digest algorithm, eg: one added to the Hash Algorithms for HTTP
+// Digest Fields registry since RFC 9530, so that it can be used to create and verify digests like
+// the built-in algorithms. The name is the key of the algorithm in Content-Digest and Repr-Digest
+// headers, so must be a valid structured field key, eg: `sha3-256`. Each digest is the Sum of a
+// new hash of the body, serialised as a byte sequence, eg: `sha3-256=:...:`.
+//
+// Register algorithms before creating the signers, verifiers and digestors using them, typically
+// from an init function. Registering a name already in use, including sha-256 and sha-512, fails.
+func RegisterDigestAlgorithm(algorithm DigestAlgorithm, newHash func() hash.Hash) error {
+	if newHash == nil {
+		return fmt.Errorf("digest algorithm %s has no hash", algorithm)
+	}
+	dict := httpsfv.NewDictionary()
+	dict.Add(string(algorithm), httpsfv.NewItem(true))
+	if _, err := httpsfv.Marshal(dict); err != nil {
+		return fmt.Errorf("invalid digest algorithm %q: %w", algorithm, err)
+	}
+
+	registeredDigestAlgorithms.Lock()
+	defer registeredDigestAlgorithms.Unlock()
+	_, registered := registeredDigestAlgorithms.hashes[algorithm]
+	if registered || slices.Contains(builtinDigestAlgorithms, algorithm) {
+		return fmt.Errorf("digest algorithm already registered: %s", algorithm)
+	}
+	if registeredDigestAlgorithms.hashes == nil {
+		registeredDigestAlgorithms.hashes = make(map[DigestAlgorithm]func() hash.Hash)
+	}
+	registeredDigestAlgorithms.names = append(registeredDigestAlgorithms.names, algorithm)
+	registeredDigestAlgorithms.hashes[algorithm] = newHash
+	return nil
+}
+
+// supportedDigestAlgorithms returns all the digest algorithms that can be verified, the built-in
+// algorithms followed by those registered in the order they were registered
+func supportedDigestAlgorithms() []DigestAlgorithm {
+	registeredDigestAlgorithms.RLock()
+	defer registeredDigestAlgorithms.RUnlock()
+	return append(slices.Clone(builtinDigestAlgorithms), registeredDigestAlgorithms.names...)
+}
+
+// ContentDigest returns the Content-Digest header value for the given body, with a digest for each
+// of the given algorithms.
+// default: sha-256
+func ContentDigest(body []byte, algorithms ...DigestAlgorithm) (string, error) {
+	hdr, err := NewDigestor(WithDigestAlgorithms(algorithms...)).Digest(body)
+	if err != nil {
+		return "", err
+	}
+	return hdr.Get(ContentDigestHeader), nil
+}
+
+// VerifyContentDigest verifies the Content-Digest header value against the given body. At least
+// one supported algorithm must be present in the header, and every one present must match the
+// body, otherwise ErrDigestMismatch is returned.
+func VerifyContentDigest(body []byte, header string) error {
+	hdr := make(http.Header)
+	hdr.Set(ContentDigestHeader, header)
+	return NewDigestor(WithDigestAlgorithms(supportedDigestAlgorithms()...)).Verify(body, hdr)
+}
+
+// Digestor creates and verifies Content-Digest or Repr-Digest headers.
+//
+// A Digestor is safe for concurrent use by multiple goroutines.
 type Digestor struct {
 	*digestor
 }
@@ -62,6 +241,9 @@ func NewDigestor(opts ...digestOption) *Digestor {
 	if len(d.config.Algorithms) == 0 {
 		d.config.Algorithms = []DigestAlgorithm{DigestAlgorithmSha256}
 	}
+	if d.config.Header == "" {
+		d.config.Header = ContentDigestHeader
+	}
 
 	return &Digestor{&d}
 }
@@ -71,20 +253,55 @@ func (d *Digestor) Digest(body []byte) (http.Header, error) {
 	return d.digestor.Digest(body)
 }
 
+// DigestReader creates a digest header for the body read from the given reader. The body is
+// hashed as it is read, so it is never held in memory.
+func (d *Digestor) DigestReader(body io.Reader) (http.Header, error) {
+	return d.digestor.DigestReader(body)
+}
+
+// Verify verifies the digest header against the given body. At least one of the configured
+// algorithms must be present in the header, and every one present must match the body. The header
+// is parsed as a structured field dictionary and the decoded digests compared, so the order of its
+// members, whitespace and header lines don't matter.
+func (d *Digestor) Verify(body []byte, header http.Header) error {
+	return d.digestor.Verify(body, header)
+}
+
+// VerifyReader wraps the given body so that it is verified against the digest header as it is
+// read. The body is not buffered: once it has been read to the end a failed verification is
+// reported by Read in place of io.EOF, so consumers must check for read errors before trusting
+// the body. A body closed before being fully read is not verified.
+func (d *Digestor) VerifyReader(body io.ReadCloser, header http.Header) (io.ReadCloser, error) {
+	return d.digestor.VerifyReader(body, header)
+}
+
 type digestor struct {
 	config DigestConfig
+
+	// a configuration error reported on use, as the constructor returns none
+	err error
 }
 
 func (d *digestor) Digest(body []byte) (http.Header, error) {
-	dict := httpsfv.NewDictionary()
+	return d.DigestReader(bytes.NewReader(body))
+}
 
-	for _, algorithm := range d.config.Algorithms {
-		digest, err := calculateDigest(body, algorithm)
-		if err != nil {
-			return nil, err
-		}
+func (d *digestor) DigestReader(body io.Reader) (http.Header, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	hashes, err := newDigestHashes(d.config.Algorithms)
+	if err != nil {
+		return nil, err
+	}
 
-		dict.Add(string(algorithm), httpsfv.NewItem(digest))
+	if _, err := io.Copy(hashes, body); err != nil {
+		return nil, err
+	}
+
+	dict := httpsfv.NewDictionary()
+	for _, algorithm := range d.config.Algorithms {
+		dict.Add(string(algorithm), httpsfv.NewItem(hashes.hashes[algorithm].Sum(nil)))
 	}
 
 	marshalled, err := httpsfv.Marshal(dict)
@@ -93,54 +310,200 @@ func (d *digestor) Digest(body []byte) (http.Header, error) {
 	}
 
 	hdr := make(http.Header)
-	hdr.Set(ContentDigestHeader, marshalled)
+	hdr.Set(d.config.Header, marshalled)
 
 	return hdr, nil
 }
 
 func (d *digestor) Verify(body []byte, header http.Header) error {
-	dict, err := httpsfv.UnmarshalDictionary(header.Values(ContentDigestHeader))
+	expected, err := d.expectedDigests(header)
 	if err != nil {
 		return err
 	}
 
+	for algorithm, value := range expected {
+		digest, err := calculateDigest(body, algorithm)
+		if err != nil {
+			return err
+		}
+
+		if subtle.ConstantTimeCompare(digest, value) != 1 {
+			return ErrDigestMismatch
+		}
+	}
+
+	return nil
+}
+
+func (d *digestor) VerifyReader(body io.ReadCloser, header http.Header) (io.ReadCloser, error) {
+	expected, err := d.expectedDigests(header)
+	if err != nil {
+		return nil, err
+	}
+
+	algorithms := make([]DigestAlgorithm, 0, len(expected))
+	for algorithm := range expected {
+		algorithms = append(algorithms, algorithm)
+	}
+
+	hashes, err := newDigestHashes(algorithms)
+	if err != nil {
+		return nil, err
+	}
+
+	return &verifyingReader{body: body, hashes: hashes, expected: expected}, nil
+}
+
+// expectedDigests returns the digests in the header for each of the configured algorithms
+func (d *digestor) expectedDigests(header http.Header) (map[DigestAlgorithm][]byte, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	// read however the header is cased, as the signature base does
+	dict, err := httpsfv.UnmarshalDictionary(headerValues(header, d.config.Header))
+	if err != nil {
+		return nil, err
+	}
+
+	expected := make(map[DigestAlgorithm][]byte)
 	for _, algorithm := range d.config.Algorithms {
 		item, ok := dict.Get(string(algorithm))
 		if !ok {
 			continue
 		}
 
-		digest, err := calculateDigest(body, algorithm)
-		if err != nil {
-			return err
-		}
-
 		valueItem, ok := item.(httpsfv.Item)
 		if !ok {
-			return errors.New("invalid digest header")
+			return nil, errors.New("invalid digest header")
 		}
 
 		value, ok := valueItem.Value.([]byte)
 		if !ok {
-			return errors.New("invalid digest header")
+			return nil, errors.New("invalid digest header")
+		}
+
+		expected[algorithm] = value
+	}
+
+	if len(expected) == 0 {
+		return nil, errNoDigestAlgorithm
+	}
+
+	return expected, nil
+}
+
+// wantedDigestAlgorithm returns the supported digest algorithm most preferred by the given
+// Want-Content-Digest or Want-Repr-Digest header values. Algorithms with a preference of 0 aren't
+// acceptable, and ties go to the algorithm listed first.
+func wantedDigestAlgorithm(values []string) (DigestAlgorithm, bool) {
+	dict, err := httpsfv.UnmarshalDictionary(values)
+	if err != nil {
+		return "", false
+	}
+
+	var wanted DigestAlgorithm
+	var preference int64
+	for _, name := range dict.Names() {
+		algorithm := DigestAlgorithm(name)
+		if !slices.Contains(supportedDigestAlgorithms(), algorithm) {
+			continue
+		}
+
+		member, _ := dict.Get(name)
+		item, ok := member.(httpsfv.Item)
+		if !ok {
+			continue
+		}
+		if p, ok := item.Value.(int64); ok && p > preference && p <= 10 {
+			wanted, preference = algorithm, p
 		}
+	}
 
-		if subtle.ConstantTimeCompare([]byte(digest), []byte(value)) != 1 {
-			return errors.New("digest mismatch")
+	return wanted, preference > 0
+}
+
+// verifyingReader checks the digests of a body once it has been read to the end
+type verifyingReader struct {
+	body     io.ReadCloser
+	hashes   *digestHashes
+	expected map[DigestAlgorithm][]byte
+	err      error
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	n, err := r.body.Read(p)
+	_, _ = r.hashes.Write(p[:n])
+
+	if err == io.EOF {
+		for algorithm, value := range r.expected {
+			if subtle.ConstantTimeCompare(r.hashes.hashes[algorithm].Sum(nil), value) != 1 {
+				r.err = ErrDigestMismatch
+				return n, r.err
+			}
 		}
 	}
 
-	return nil
+	return n, err
+}
+
+func (r *verifyingReader) Close() error {
+	return r.body.Close()
+}
+
+// digestHashes is a writer that computes the digest for several algorithms at once
+type digestHashes struct {
+	hashes map[DigestAlgorithm]hash.Hash
+}
+
+func newDigestHashes(algorithms []DigestAlgorithm) (*digestHashes, error) {
+	hashes := make(map[DigestAlgorithm]hash.Hash, len(algorithms))
+	for _, algorithm := range algorithms {
+		h, err := newDigestHash(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		hashes[algorithm] = h
+	}
+
+	return &digestHashes{hashes}, nil
+}
+
+func (h *digestHashes) Write(p []byte) (int, error) {
+	for _, hash := range h.hashes {
+		// hash.Hash never returns an error
+		_, _ = hash.Write(p)
+	}
+
+	return len(p), nil
 }
 
 func calculateDigest(body []byte, algorithm DigestAlgorithm) ([]byte, error) {
+	h, err := newDigestHash(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	_, _ = h.Write(body)
+	return h.Sum(nil), nil
+}
+
+func newDigestHash(algorithm DigestAlgorithm) (hash.Hash, error) {
 	switch algorithm {
 	case DigestAlgorithmSha256:
-		digest := sha256.Sum256(body)
-		return digest[:], nil
+		return sha256.New(), nil
 	case DigestAlgorithmSha512:
-		digest := sha512.Sum512(body)
-		return digest[:], nil
+		return sha512.New(), nil
+	}
+
+	registeredDigestAlgorithms.RLock()
+	newHash, ok := registeredDigestAlgorithms.hashes[algorithm]
+	registeredDigestAlgorithms.RUnlock()
+	if ok {
+		return newHash(), nil
 	}
 
 	return nil, errors.New("unsupported digest algorithm")
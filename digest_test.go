@@ -32,7 +32,18 @@
 package httpsig
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -124,6 +135,8 @@ func TestDigest_SHA256_SHA512_Verify_SHA256_SHA512(t *testing.T) {
 	hdr, err := d.Digest(body)
 	assert.NoError(t, err)
 
+	assert.Equal(t, `sha-256=:RK/0qy18MlBSVnWgjwz6lZEWjP/lF5HF9bvEF8FabDg=:, sha-512=:YMAam51Jz/jOATT6/zvHrLVgOYTGFy1d6GJiOHTohq4yP+pgk4vf2aCsyRZOtw8MjkM7iw7yZ/WkppmM44T3qg==:`, hdr.Get(ContentDigestHeader))
+
 	err = d.Verify(body, hdr)
 	assert.NoError(t, err)
 
@@ -179,3 +192,281 @@ func TestDigest_SHA256_SHA512_Verify_SHA512(t *testing.T) {
 	err = d.Verify(body, hdr)
 	assert.NoError(t, err)
 }
+
+func TestDigestReader(t *testing.T) {
+	body := []byte("{\"hello\": \"world\"}\n")
+
+	d := NewDigestor(
+		WithDigestAlgorithms(DigestAlgorithmSha512),
+	)
+
+	hdr, err := d.DigestReader(iotest.OneByteReader(bytes.NewReader(body)))
+	assert.NoError(t, err)
+
+	assert.Equal(t, `sha-512=:YMAam51Jz/jOATT6/zvHrLVgOYTGFy1d6GJiOHTohq4yP+pgk4vf2aCsyRZOtw8MjkM7iw7yZ/WkppmM44T3qg==:`, hdr.Get(ContentDigestHeader))
+
+	_, err = d.DigestReader(iotest.ErrReader(io.ErrUnexpectedEOF))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestVerifyReader(t *testing.T) {
+	body := "{\"hello\": \"world\"}\n"
+
+	d := NewDigestor(
+		WithDigestAlgorithms(DigestAlgorithmSha256),
+	)
+
+	hdr, err := d.Digest([]byte(body))
+	assert.NoError(t, err)
+
+	t.Run("reads a matching body", func(t *testing.T) {
+		r, err := d.VerifyReader(io.NopCloser(iotest.HalfReader(strings.NewReader(body))), hdr)
+		assert.NoError(t, err)
+
+		read, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, body, string(read))
+		assert.NoError(t, r.Close())
+	})
+	t.Run("fails at the end of a mismatched body", func(t *testing.T) {
+		r, err := d.VerifyReader(io.NopCloser(strings.NewReader("{\"hello\": \"moon\"}\n")), hdr)
+		assert.NoError(t, err)
+
+		_, err = io.ReadAll(r)
+		assert.EqualError(t, err, "digest mismatch")
+
+		// the failure is sticky
+		_, err = r.Read(make([]byte, 1))
+		assert.EqualError(t, err, "digest mismatch")
+	})
+	t.Run("error on invalid header", func(t *testing.T) {
+		_, err := d.VerifyReader(io.NopCloser(strings.NewReader(body)), http.Header{ContentDigestHeader: []string{"sha-256=("}})
+		assert.Error(t, err)
+	})
+}
+
+func TestVerify_NoSupportedAlgorithm(t *testing.T) {
+	body := []byte("{\"hello\": \"world\"}\n")
+
+	hdr, err := NewDigestor(WithDigestAlgorithms(DigestAlgorithmSha256)).Digest(body)
+	assert.NoError(t, err)
+
+	err = NewDigestor(WithDigestAlgorithms(DigestAlgorithmSha512)).Verify(body, hdr)
+	assert.Error(t, err)
+
+	err = NewDigestor().Verify(body, http.Header{})
+	assert.Error(t, err)
+}
+
+func TestVerify_AnyMismatch(t *testing.T) {
+	body := []byte("{\"hello\": \"world\"}\n")
+
+	d := NewDigestor(
+		WithDigestAlgorithms(DigestAlgorithmSha256, DigestAlgorithmSha512),
+	)
+
+	// the sha-256 digest is valid, the sha-512 one is for a different body
+	hdr := http.Header{}
+	hdr.Set(ContentDigestHeader, `sha-256=:RK/0qy18MlBSVnWgjwz6lZEWjP/lF5HF9bvEF8FabDg=:, sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:`)
+
+	assert.EqualError(t, d.Verify(body, hdr), "digest mismatch")
+
+	// unrecognised algorithms are ignored
+	hdr.Set(ContentDigestHeader, `sha-256=:RK/0qy18MlBSVnWgjwz6lZEWjP/lF5HF9bvEF8FabDg=:, md5=:aGVsbG8=:`)
+	assert.NoError(t, d.Verify(body, hdr))
+}
+
+func TestVerify_EquivalentHeaders(t *testing.T) {
+	body := []byte("{\"hello\": \"world\"}\n")
+
+	d := NewDigestor(
+		WithDigestAlgorithms(DigestAlgorithmSha256, DigestAlgorithmSha512),
+	)
+
+	sha256Digest := `sha-256=:RK/0qy18MlBSVnWgjwz6lZEWjP/lF5HF9bvEF8FabDg=:`
+	sha512Digest := `sha-512=:YMAam51Jz/jOATT6/zvHrLVgOYTGFy1d6GJiOHTohq4yP+pgk4vf2aCsyRZOtw8MjkM7iw7yZ/WkppmM44T3qg==:`
+
+	for _, values := range [][]string{
+		{sha512Digest + ", " + sha256Digest},
+		{sha256Digest + "," + sha512Digest},
+		{"  " + sha512Digest + " ,\t" + sha256Digest + "  "},
+		{sha256Digest, sha512Digest},
+		{"md5=:aGVsbG8=:;q=1", sha512Digest + ";now=1", sha256Digest},
+	} {
+		hdr := http.Header{ContentDigestHeader: values}
+		assert.NoError(t, d.Verify(body, hdr), values)
+
+		r, err := d.VerifyReader(io.NopCloser(bytes.NewReader(body)), hdr)
+		assert.NoError(t, err, values)
+		_, err = io.ReadAll(r)
+		assert.NoError(t, err, values)
+	}
+
+	hdr := http.Header{ContentDigestHeader: {"  " + sha512Digest + " ,\t" + sha256Digest + "  "}}
+	assert.ErrorIs(t, d.Verify([]byte("{}"), hdr), ErrDigestMismatch)
+}
+
+func TestContentDigest(t *testing.T) {
+	body := []byte("{\"hello\": \"world\"}\n")
+
+	digest, err := ContentDigest(body)
+	assert.NoError(t, err)
+	assert.Equal(t, `sha-256=:RK/0qy18MlBSVnWgjwz6lZEWjP/lF5HF9bvEF8FabDg=:`, digest)
+
+	digest, err = ContentDigest(body, DigestAlgorithmSha512, DigestAlgorithmSha256)
+	assert.NoError(t, err)
+	assert.Equal(t, `sha-512=:YMAam51Jz/jOATT6/zvHrLVgOYTGFy1d6GJiOHTohq4yP+pgk4vf2aCsyRZOtw8MjkM7iw7yZ/WkppmM44T3qg==:, sha-256=:RK/0qy18MlBSVnWgjwz6lZEWjP/lF5HF9bvEF8FabDg=:`, digest)
+
+	_, err = ContentDigest(body, "md5")
+	assert.EqualError(t, err, "unsupported digest algorithm")
+}
+
+func TestVerifyContentDigest(t *testing.T) {
+	body := []byte("{\"hello\": \"world\"}\n")
+
+	assert.NoError(t, VerifyContentDigest(body, `sha-256=:RK/0qy18MlBSVnWgjwz6lZEWjP/lF5HF9bvEF8FabDg=:`))
+	assert.NoError(t, VerifyContentDigest(body, `sha-512=:YMAam51Jz/jOATT6/zvHrLVgOYTGFy1d6GJiOHTohq4yP+pgk4vf2aCsyRZOtw8MjkM7iw7yZ/WkppmM44T3qg==:`))
+	assert.ErrorIs(t, VerifyContentDigest([]byte("{}"), `sha-256=:RK/0qy18MlBSVnWgjwz6lZEWjP/lF5HF9bvEF8FabDg=:`), ErrDigestMismatch)
+	assert.EqualError(t, VerifyContentDigest(body, `md5=:aGVsbG8=:`), "no supported digest algorithm in digest header")
+}
+
+func TestDigest_ReprDigest(t *testing.T) {
+	body := []byte("{\"hello\": \"world\"}\n")
+
+	d := NewDigestor(WithDigestHeader("repr-digest"))
+	hdr, err := d.Digest(body)
+	assert.NoError(t, err)
+	assert.Equal(t, `sha-256=:RK/0qy18MlBSVnWgjwz6lZEWjP/lF5HF9bvEF8FabDg=:`, hdr.Get(ReprDigestHeader))
+	assert.Empty(t, hdr.Get(ContentDigestHeader))
+
+	assert.NoError(t, d.Verify(body, hdr))
+	assert.ErrorIs(t, d.Verify([]byte("{}"), hdr), ErrDigestMismatch)
+
+	// a Content-Digest header isn't read in place of Repr-Digest
+	cd := http.Header{}
+	cd.Set(ContentDigestHeader, hdr.Get(ReprDigestHeader))
+	assert.Error(t, d.Verify(body, cd))
+}
+
+func TestDigest_InvalidHeaderName(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	for _, name := range []string{"", "content digest", "content-digest:"} {
+		t.Run(strconv.Quote(name), func(t *testing.T) {
+			_, err := NewDigestor(WithDigestHeader(name)).Digest([]byte("hello"))
+			assert.EqualError(t, err, fmt.Sprintf("invalid digest header name %q", name))
+			assert.Error(t, NewDigestor(WithDigestHeader(name)).Verify([]byte("hello"), http.Header{}))
+
+			r := testReq()
+			err = NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields("@method", "content-digest"), WithDigestHeader(name)).SignRequest(r)
+			assert.EqualError(t, err, fmt.Sprintf("invalid digest header name %q", name))
+			assert.Equal(t, testReq().Header, r.Header)
+
+			r = testReq()
+			assert.NoError(t, NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields("@method", "content-digest")).SignRequest(r))
+			err = NewVerifier(WithHmacSha256("test-shared-secret", k), WithDigestHeader(name)).VerifyRequest(r)
+			assert.EqualError(t, err, fmt.Sprintf("invalid digest header name %q", name))
+		})
+	}
+}
+
+func TestWantedDigestAlgorithm(t *testing.T) {
+	tests := []struct {
+		name   string
+		want   string
+		result DigestAlgorithm
+		ok     bool
+	}{
+		{"single algorithm", `sha-512=3`, DigestAlgorithmSha512, true},
+		{"most preferred", `sha-256=3, sha-512=10`, DigestAlgorithmSha512, true},
+		{"first of equal preference", `sha-512=5, sha-256=5`, DigestAlgorithmSha512, true},
+		{"unsupported algorithms ignored", `md5=10, sha-256=1`, DigestAlgorithmSha256, true},
+		{"zero isn't acceptable", `sha-256=0, sha-512=0`, "", false},
+		{"out of range preference", `sha-256=11`, "", false},
+		{"only unsupported", `md5=10`, "", false},
+		{"malformed", `sha-256=:aGVsbG8=`, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			algorithm, ok := wantedDigestAlgorithm([]string{tt.want})
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.result, algorithm)
+		})
+	}
+}
+
+// testDigestAlgorithm is registered once for every test run in the process
+const testDigestAlgorithm DigestAlgorithm = "x-sha-384"
+
+var registerTestDigestAlgorithm = sync.OnceValue(func() error {
+	return RegisterDigestAlgorithm(testDigestAlgorithm, sha512.New384)
+})
+
+func TestRegisterDigestAlgorithm(t *testing.T) {
+	assert.NoError(t, registerTestDigestAlgorithm())
+	body := []byte("{\"hello\": \"world\"}\n")
+
+	t.Run("digests and verifies with the algorithm", func(t *testing.T) {
+		sum := sha512.Sum384(body)
+		header, err := ContentDigest(body, testDigestAlgorithm)
+		assert.NoError(t, err)
+		assert.Equal(t, "x-sha-384=:"+base64.StdEncoding.EncodeToString(sum[:])+":", header)
+
+		assert.NoError(t, VerifyContentDigest(body, header))
+		assert.ErrorIs(t, VerifyContentDigest([]byte("{}"), header), ErrDigestMismatch)
+	})
+	t.Run("signs and verifies requests end to end", func(t *testing.T) {
+		k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+		assert.NoError(t, err)
+		s := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithSignFields("@method", "content-digest"),
+			WithSignDigestAlgorithms(testDigestAlgorithm),
+		)
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewReader(body))
+		assert.NoError(t, err)
+		assert.NoError(t, s.SignRequest(req))
+		assert.True(t, strings.HasPrefix(req.Header.Get(ContentDigestHeader), "x-sha-384=:"))
+
+		v := NewVerifier(WithHmacSha256("test-shared-secret", k))
+		assert.NoError(t, v.VerifyRequest(req))
+
+		req.Body = io.NopCloser(strings.NewReader("{}"))
+		assert.ErrorIs(t, v.VerifyRequest(req), ErrDigestMismatch)
+
+		// the algorithm can be restricted like the built-in algorithms
+		v = NewVerifier(WithHmacSha256("test-shared-secret", k), WithVerifyDigestAlgorithms(DigestAlgorithmSha256))
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		assert.ErrorIs(t, v.VerifyRequest(req), ErrDigestAlgorithmNotAllowed)
+	})
+	t.Run("rejects names in use", func(t *testing.T) {
+		assert.EqualError(t, RegisterDigestAlgorithm(DigestAlgorithmSha256, sha256.New), "digest algorithm already registered: sha-256")
+		assert.EqualError(t, RegisterDigestAlgorithm(testDigestAlgorithm, sha512.New384), "digest algorithm already registered: x-sha-384")
+	})
+	t.Run("rejects invalid algorithms", func(t *testing.T) {
+		assert.ErrorContains(t, RegisterDigestAlgorithm("SHA-384", sha512.New384), `invalid digest algorithm "SHA-384"`)
+		assert.EqualError(t, RegisterDigestAlgorithm("x-none", nil), "digest algorithm x-none has no hash")
+	})
+}
+
+func TestDigest_RepeatedAlgorithms(t *testing.T) {
+	body := []byte("{\"hello\": \"world\"}\n")
+
+	// repeated options add to the algorithms
+	d := NewDigestor(
+		WithDigestAlgorithms(DigestAlgorithmSha512),
+		WithDigestAlgorithms(DigestAlgorithmSha256),
+	)
+	hdr, err := d.Digest(body)
+	assert.NoError(t, err)
+	assert.Equal(t, `sha-512=:YMAam51Jz/jOATT6/zvHrLVgOYTGFy1d6GJiOHTohq4yP+pgk4vf2aCsyRZOtw8MjkM7iw7yZ/WkppmM44T3qg==:, sha-256=:RK/0qy18MlBSVnWgjwz6lZEWjP/lF5HF9bvEF8FabDg=:`, hdr.Get(ContentDigestHeader))
+
+	// verifying needs one of the algorithms to be present
+	sha512, err := NewDigestor(WithDigestAlgorithms(DigestAlgorithmSha512)).Digest(body)
+	assert.NoError(t, err)
+	assert.NoError(t, NewDigestor(WithDigestAlgorithms(DigestAlgorithmSha256), WithDigestAlgorithms(DigestAlgorithmSha512)).Verify(body, sha512))
+	assert.ErrorIs(t, NewDigestor(WithDigestAlgorithms(DigestAlgorithmSha256)).Verify(body, sha512), errNoDigestAlgorithm)
+}
@@ -35,6 +35,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/offblocks/httpsig"
@@ -73,3 +74,28 @@ func Example_round_trip() {
 	// Output:
 	// 200 OK
 }
+
+func ExampleNewVerifyTestRequest() {
+	signer := httpsig.NewSigner(httpsig.WithHmacSha256("key1", []byte(secret)),
+		httpsig.WithSignFields("@method", "@authority", "@scheme", "@target-uri", "content-digest"))
+	verifier := httpsig.NewVerifier(httpsig.WithHmacSha256("key1", []byte(secret)))
+
+	req, _ := http.NewRequest("POST", "https://example.com/cats?colour=ginger", strings.NewReader(`{"bonnet": true}`))
+	if err := signer.SignRequest(req); err != nil {
+		fmt.Println("got err: ", err)
+		return
+	}
+
+	// the request as a handler receives it
+	received, err := httpsig.NewVerifyTestRequest(req)
+	if err != nil {
+		fmt.Println("got err: ", err)
+		return
+	}
+	fmt.Println(received.Host, received.URL, received.TLS != nil)
+	fmt.Println(verifier.VerifyRequest(received))
+
+	// Output:
+	// example.com /cats?colour=ginger true
+	// <nil>
+}
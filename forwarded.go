@@ -0,0 +1,102 @@
+// BSD 3-Clause License
+
+// Copyright (c) 2021, James Bowes
+// Copyright (c) 2023, Alexander Taraymovich, OffBlocks
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package httpsig
+
+import (
+	"net/http"
+	"strings"
+)
+
+// forwardedMessage returns a copy of the request message with its scheme and authority replaced
+// by those the client used to reach a reverse proxy, as described by the Forwarded header or the
+// X-Forwarded-Proto and X-Forwarded-Host headers. The Forwarded header takes precedence. Only the
+// first, client facing, element of each header is used. Messages without these headers are
+// returned as they are.
+func forwardedMessage(msg *Message) *Message {
+	if !msg.IsRequest || msg.URL == nil {
+		return msg
+	}
+
+	proto, host := forwardedHeader(msg.Header)
+	if proto == "" {
+		proto = firstValue(msg.Header, "X-Forwarded-Proto")
+	}
+	if host == "" {
+		host = firstValue(msg.Header, "X-Forwarded-Host")
+	}
+	if proto == "" && host == "" {
+		return msg
+	}
+
+	m := *msg
+	u := *msg.URL
+	if proto != "" {
+		u.Scheme = strings.ToLower(proto)
+	}
+	if host != "" {
+		u.Host = host
+		m.Authority = host
+	}
+	m.URL = &u
+
+	return &m
+}
+
+// forwardedHeader returns the proto and host parameters of the first element of the Forwarded
+// header, as defined by RFC 7239
+func forwardedHeader(header http.Header) (proto string, host string) {
+	value := firstValue(header, "Forwarded")
+	for _, pair := range strings.Split(value, ";") {
+		name, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		v = strings.Trim(v, `"`)
+		switch strings.ToLower(name) {
+		case "proto":
+			proto = v
+		case "host":
+			host = v
+		}
+	}
+	return proto, host
+}
+
+// firstValue returns the first element of a comma separated header
+func firstValue(header http.Header, name string) string {
+	values := header.Values(name)
+	if len(values) == 0 {
+		return ""
+	}
+	first, _, _ := strings.Cut(values[0], ",")
+	return strings.TrimSpace(first)
+}
@@ -0,0 +1,130 @@
+// BSD 3-Clause License
+
+// Copyright (c) 2021, James Bowes
+// Copyright (c) 2023, Alexander Taraymovich, OffBlocks
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package httpsig
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForwardedMessage(t *testing.T) {
+	proxied := func(header http.Header) *Message {
+		return MessageFromRequest(&http.Request{
+			Method: "GET",
+			Host:   "backend.internal:8080",
+			URL:    parse("/foo?a=b"),
+			Header: header,
+		})
+	}
+
+	t.Run("without forwarded headers", func(t *testing.T) {
+		msg := forwardedMessage(proxied(http.Header{}))
+		assert.Equal(t, "backend.internal:8080", msg.Authority)
+		assert.Equal(t, "http://backend.internal:8080/foo?a=b", msg.URL.String())
+	})
+	t.Run("from X-Forwarded headers", func(t *testing.T) {
+		msg := forwardedMessage(proxied(http.Header{
+			"X-Forwarded-Proto": []string{"https"},
+			"X-Forwarded-Host":  []string{"example.com, proxy.internal"},
+		}))
+		assert.Equal(t, "example.com", msg.Authority)
+		assert.Equal(t, "https://example.com/foo?a=b", msg.URL.String())
+	})
+	t.Run("from the Forwarded header", func(t *testing.T) {
+		msg := forwardedMessage(proxied(http.Header{
+			"Forwarded":        []string{`for=192.0.2.60;proto=HTTPS;host="example.com", for=198.51.100.17;host=proxy.internal`},
+			"X-Forwarded-Host": []string{"example.org"},
+		}))
+		assert.Equal(t, "example.com", msg.Authority)
+		assert.Equal(t, "https://example.com/foo?a=b", msg.URL.String())
+	})
+	t.Run("with only a forwarded scheme", func(t *testing.T) {
+		original := proxied(http.Header{"X-Forwarded-Proto": []string{"https"}})
+		msg := forwardedMessage(original)
+		assert.Equal(t, "backend.internal:8080", msg.Authority)
+		assert.Equal(t, "https://backend.internal:8080/foo?a=b", msg.URL.String())
+		assert.Equal(t, "http://backend.internal:8080/foo?a=b", original.URL.String(), "the message must not be modified")
+	})
+	t.Run("ignores responses", func(t *testing.T) {
+		msg := MessageFromResponse(testResp())
+		msg.Header.Set("X-Forwarded-Host", "example.org")
+		assert.Equal(t, msg, forwardedMessage(msg))
+	})
+}
+
+func TestVerify_TrustedProxyHeaders(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	s := NewSigner(
+		WithHmacSha256("test-shared-secret", k),
+		WithSignFields("@method", "@scheme", "@authority", "@target-uri"),
+	)
+	client := &http.Request{Method: "GET", URL: parse("https://example.com/foo"), Header: http.Header{}}
+	hdr, err := s.Sign(MessageFromRequest(client))
+	assert.NoError(t, err)
+
+	// the request as received by the backend from a reverse proxy terminating TLS
+	received := func(header http.Header) *Message {
+		r := &http.Request{Method: "GET", Host: "backend.internal:8080", URL: parse("/foo"), Header: hdr.Clone()}
+		for name, values := range header {
+			r.Header[name] = values
+		}
+		return MessageFromRequest(r)
+	}
+	forwarded := http.Header{"X-Forwarded-Proto": []string{"https"}, "X-Forwarded-Host": []string{"example.com"}}
+
+	t.Run("doesn't trust the headers by default", func(t *testing.T) {
+		v := NewVerifier(WithHmacSha256("test-shared-secret", k))
+		assert.ErrorIs(t, v.Verify(received(forwarded)), ErrSignatureInvalid)
+	})
+	t.Run("verifies using the forwarded headers", func(t *testing.T) {
+		v := NewVerifier(
+			WithHmacSha256("test-shared-secret", k),
+			WithVerifyTrustedProxyHeaders(true),
+		)
+		assert.NoError(t, v.Verify(received(forwarded)))
+		assert.NoError(t, v.Verify(received(http.Header{"Forwarded": []string{"proto=https;host=example.com"}})))
+	})
+	t.Run("fails without the forwarded headers", func(t *testing.T) {
+		v := NewVerifier(
+			WithHmacSha256("test-shared-secret", k),
+			WithVerifyTrustedProxyHeaders(true),
+		)
+		assert.ErrorIs(t, v.Verify(received(http.Header{})), ErrSignatureInvalid)
+	})
+}
@@ -32,9 +32,14 @@
 package httpsig
 
 const (
-	SignatureHeader      = "Signature"
-	SignatureInputHeader = "Signature-Input"
-	ContentDigestHeader  = "Content-Digest"
+	SignatureHeader       = "Signature"
+	SignatureInputHeader  = "Signature-Input"
+	ContentDigestHeader   = "Content-Digest"
+	AcceptSignatureHeader = "Accept-Signature"
+
+	ReprDigestHeader        = "Repr-Digest"
+	WantContentDigestHeader = "Want-Content-Digest"
+	WantReprDigestHeader    = "Want-Repr-Digest"
 )
 
 // Algorithm is the signature algorithm to use. Available algorithms are:
@@ -44,6 +49,9 @@ const (
 // - ECDSA using curve P-384 DSS and SHA-384 (ecdsa-p384-sha384)
 // - EdDSA using curve edwards25519 (ed25519)
 // - HMAC using SHA-256 (hmac-sha256)
+//
+// Also available, though not defined by RFC 9421, so only for peers agreeing to use it:
+// - EdDSA using curve edwards25519 over the SHA-512 hash of the signature base (ed25519ph)
 type Algorithm string
 
 const (
@@ -53,11 +61,16 @@ const (
 	AlgorithmEcdsaP384Sha384   Algorithm = "ecdsa-p384-sha384"
 	AlgorithmEd25519           Algorithm = "ed25519"
 	AlgorithmHmacSha256        Algorithm = "hmac-sha256"
+
+	// Ed25519ph (RFC 8032) is not registered for HTTP message signatures, so it doesn't
+	// interoperate with other implementations
+	AlgorithmEd25519ph Algorithm = "ed25519ph"
 )
 
 // DigestAlgorithm is the digest algorithm to use. Available algorithms are:
 // - SHA-256 (sha-256)
 // - SHA-512 (sha-512)
+// Others can be added with RegisterDigestAlgorithm.
 type DigestAlgorithm string
 
 const (
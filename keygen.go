@@ -0,0 +1,132 @@
+// BSD 3-Clause License
+
+// Copyright (c) 2021, James Bowes
+// Copyright (c) 2023, Alexander Taraymovich, OffBlocks
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package httpsig
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"fmt"
+)
+
+// GenerateKeyPair generates an ephemeral key for the given algorithm, returning the options to sign
+// and verify with it under a generated key id. For `hmac-sha256` both use the same generated
+// secret, as by GenerateSecret.
+//
+// It is meant for trying out the API, examples and tests: the keys only live in memory, so use
+// proper key management for anything else.
+func GenerateKeyPair(alg Algorithm) (signOption, verifyOption, error) {
+	keyID, err := generateKeyID(alg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch alg {
+	case AlgorithmRsaPkcs1v15Sha256, AlgorithmRsaPssSha512:
+		pk, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, nil, err
+		}
+		if alg == AlgorithmRsaPssSha512 {
+			return WithSignRsaPssSha512(keyID, pk), WithVerifyRsaPssSha512(keyID, &pk.PublicKey), nil
+		}
+		return WithSignRsaPkcs1v15Sha256(keyID, pk), WithVerifyRsaPkcs1v15Sha256(keyID, &pk.PublicKey), nil
+	case AlgorithmEcdsaP256Sha256:
+		pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return WithSignEcdsaP256Sha256(keyID, pk), WithVerifyEcdsaP256Sha256(keyID, &pk.PublicKey), nil
+	case AlgorithmEcdsaP384Sha384:
+		pk, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return WithSignEcdsaP384Sha384(keyID, pk), WithVerifyEcdsaP384Sha384(keyID, &pk.PublicKey), nil
+	case AlgorithmEd25519:
+		pub, pk, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return WithSignEd25519(keyID, pk), WithVerifyEd25519(keyID, pub), nil
+	case AlgorithmEd25519ph:
+		pub, pk, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return WithSignEd25519ph(keyID, pk), WithVerifyEd25519ph(keyID, pub), nil
+	case AlgorithmHmacSha256:
+		opt, err := generateSecret(keyID)
+		if err != nil {
+			return nil, nil, err
+		}
+		return opt, opt, nil
+	}
+
+	return nil, nil, fmt.Errorf("unsupported algorithm: %s", alg)
+}
+
+// GenerateSecret generates an ephemeral secret for the given HMAC algorithm, returning the option
+// to sign and verify with it under a generated key id.
+//
+// Like GenerateKeyPair, it is meant for trying out the API, examples and tests.
+func GenerateSecret(alg Algorithm) (signOrVerifyOption, error) {
+	if alg != AlgorithmHmacSha256 {
+		return nil, fmt.Errorf("unsupported algorithm: %s", alg)
+	}
+	keyID, err := generateKeyID(alg)
+	if err != nil {
+		return nil, err
+	}
+	return generateSecret(keyID)
+}
+
+// generateSecret generates a secret as long as the output of SHA-256, as recommended for HMAC
+func generateSecret(keyID string) (signOrVerifyOption, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return WithHmacSha256(keyID, secret), nil
+}
+
+// generateKeyID generates a random key id naming the algorithm, eg: `ed25519-3f9a0c1de85b4721`
+func generateKeyID(alg Algorithm) (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return string(alg) + "-" + hex.EncodeToString(id), nil
+}
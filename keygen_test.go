@@ -0,0 +1,92 @@
+// BSD 3-Clause License
+
+// Copyright (c) 2021, James Bowes
+// Copyright (c) 2023, Alexander Taraymovich, OffBlocks
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package httpsig
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateKeyPair(t *testing.T) {
+	for _, alg := range []Algorithm{
+		AlgorithmRsaPkcs1v15Sha256,
+		AlgorithmRsaPssSha512,
+		AlgorithmEcdsaP256Sha256,
+		AlgorithmEcdsaP384Sha384,
+		AlgorithmEd25519,
+		AlgorithmEd25519ph,
+		AlgorithmHmacSha256,
+	} {
+		t.Run(string(alg), func(t *testing.T) {
+			signOpt, verifyOpt, err := GenerateKeyPair(alg)
+			assert.NoError(t, err)
+
+			s := NewSigner(signOpt, WithSignFields("@method", "@authority"))
+			msg := MessageFromRequest(testReq())
+			hdr, err := s.Sign(msg)
+			assert.NoError(t, err)
+			msg.Header = hdr
+
+			inputs, err := ParseSignatureHeaders(hdr)
+			assert.NoError(t, err)
+			assert.Equal(t, alg, *inputs[0].Alg)
+			assert.Contains(t, *inputs[0].KeyID, string(alg)+"-")
+
+			assert.NoError(t, NewVerifier(verifyOpt).Verify(msg))
+
+			// every key is different
+			_, other, err := GenerateKeyPair(alg)
+			assert.NoError(t, err)
+			assert.ErrorIs(t, NewVerifier(other, WithVerifyAll(true)).Verify(msg), ErrUnknownKeyID)
+		})
+	}
+
+	t.Run("unsupported algorithm", func(t *testing.T) {
+		_, _, err := GenerateKeyPair("rsa-v1_5-sha1")
+		assert.EqualError(t, err, "unsupported algorithm: rsa-v1_5-sha1")
+	})
+}
+
+func TestGenerateSecret(t *testing.T) {
+	opt, err := GenerateSecret(AlgorithmHmacSha256)
+	assert.NoError(t, err)
+
+	msg := MessageFromRequest(testReq())
+	hdr, err := NewSigner(opt, WithSignFields("@method")).Sign(msg)
+	assert.NoError(t, err)
+	msg.Header = hdr
+	assert.NoError(t, NewVerifier(opt).Verify(msg))
+
+	_, err = GenerateSecret(AlgorithmEd25519)
+	assert.EqualError(t, err, "unsupported algorithm: ed25519")
+}
@@ -31,7 +31,12 @@
 
 package httpsig
 
-import "net/http"
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+)
 
 // NewVerifyMiddleware returns a configured http server middleware that can be used to wrap
 // multiple handlers for http message signature and digest verification.
@@ -39,28 +44,148 @@ import "net/http"
 // Use the `WithVerify*` option funcs to configure signature verification algorithms and verification
 // parameters.
 //
-// Requests with missing signatures, malformed signature headers, expired signatures, or
-// invalid signatures are rejected with a `400` response. Only one valid signature is required
-// from the known key ids by default.
+// Requests without signatures are rejected with a `401` response with a
+// `WWW-Authenticate: Signature` header. Requests with malformed signature headers, expired
+// signatures, invalid signatures, or a Content-Digest header not matching the body are rejected
+// with a `400` response, so that clients don't retry them. Verify requests before any middleware
+// reading their body, or have it set GetBody to read the body again: a body already read fails with
+// ErrBodyConsumed and a `500` response. Keys that fail to resolve, eg: as a key server is down, are
+// the fault of the server rather than the client, so are rejected with a `503` response. Bodies
+// larger than the limit set by WithBodyBufferLimit are rejected with a `413` response. By default,
+// only the first signature with a known key id is verified. Handlers can get what verification
+// established about the body of the request with VerificationResultFromContext. With
+// WithVerifyChallenge, every `400` rejection is a `401` response instead, challenging the client to
+// sign requests as described by its Accept-Signature header. Use WithVerifyErrorHandler to respond
+// differently.
+//
+// With WithVerifyResponseSigning, the responses of the wrapped handlers are signed too. The whole
+// response is buffered until the handler returns, so that a Content-Digest header can be added
+// and the headers signed before anything is written: flushing is not supported and nothing is
+// sent before the handler has finished. Use WithBodyBufferLimit in the signing options to cap the
+// size of the buffered body. Responses that are too large or can't be signed are replaced with a
+// `500` response.
 func NewVerifyMiddleware(opts ...verifyOption) func(http.Handler) http.Handler {
 	// TODO: form and multipart support
 	v := NewVerifier(opts...)
 
-	serveErr := func(rw http.ResponseWriter) {
-		// TODO: better error and custom error handler
-		rw.Header().Set("Content-Type", "text/plain")
-		rw.WriteHeader(http.StatusBadRequest)
+	var acceptSignature string
+	if v.challenge {
+		// an invalid challenge is left out, still rejecting with a 401
+		acceptSignature, _ = BuildAcceptSignature(v.challengeAccepts()...)
+	}
+
+	serveErr := v.errorHandler
+	if serveErr == nil {
+		serveErr = func(rw http.ResponseWriter, r *http.Request, err error) {
+			status, msg := http.StatusBadRequest, "invalid required signature"
+			switch {
+			case errors.Is(err, ErrNoSignature):
+				status, msg = http.StatusUnauthorized, "signature required"
+			case errors.Is(err, ErrKeyResolution):
+				// a key server that can't be reached is the fault of the server, so clients may retry
+				status, msg = http.StatusServiceUnavailable, "unable to resolve key"
+			case errors.Is(err, ErrBodyTooLarge):
+				// the signature may well be valid, the body is only too large to verify its digest
+				status, msg = http.StatusRequestEntityTooLarge, "request body too large"
+			case errors.Is(err, ErrBodyConsumed):
+				// the body was read by the server before verifying it, not sent wrong by the client
+				status, msg = http.StatusInternalServerError, "request body already read"
+			}
+			if status == http.StatusUnauthorized || (v.challenge && status == http.StatusBadRequest) {
+				status = http.StatusUnauthorized
+				rw.Header().Set("WWW-Authenticate", "Signature")
+				if acceptSignature != "" {
+					rw.Header().Set(AcceptSignatureHeader, acceptSignature)
+				}
+			}
+			rw.Header().Set("Content-Type", "text/plain")
+			rw.WriteHeader(status)
 
-		_, _ = rw.Write([]byte("invalid required signature"))
+			_, _ = rw.Write([]byte(msg))
+		}
 	}
 
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-			if err := v.Verify(MessageFromRequest(r)); err != nil {
-				serveErr(rw)
+			result, err := v.VerifyRequestResult(r)
+			if err != nil {
+				serveErr(rw, r, err)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), verificationResultKey{}, result))
+			if v.responseSigner == nil {
+				h.ServeHTTP(rw, r)
 				return
 			}
-			h.ServeHTTP(rw, r)
+
+			srw := &signingResponseWriter{rw: rw, limit: v.responseSigner.config.BodyBufferLimit}
+			h.ServeHTTP(srw, r)
+			srw.finish(v.responseSigner, r)
 		})
 	}
 }
+
+// signingResponseWriter buffers a response so that it can be signed once the handler is done
+type signingResponseWriter struct {
+	rw     http.ResponseWriter
+	status int
+	body   bytes.Buffer
+	limit  int64
+	err    error
+}
+
+func (w *signingResponseWriter) Header() http.Header {
+	return w.rw.Header()
+}
+
+func (w *signingResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *signingResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.limit > 0 && int64(w.body.Len()+len(p)) > w.limit {
+		w.err = ErrBodyTooLarge
+		return 0, w.err
+	}
+	return w.body.Write(p)
+}
+
+// finish signs the buffered response and writes it
+func (w *signingResponseWriter) finish(s *Signer, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+
+	serveErr := func() {
+		w.rw.Header().Set("Content-Type", "text/plain")
+		w.rw.WriteHeader(http.StatusInternalServerError)
+
+		_, _ = w.rw.Write([]byte("unable to sign response"))
+	}
+
+	if w.err != nil {
+		serveErr()
+		return
+	}
+
+	msg := MessageFromResponse(&http.Response{StatusCode: w.status, Header: w.rw.Header(), Request: r})
+	hdr, err := s.signBody(msg, s.config, w.body.Bytes())
+	if err != nil {
+		serveErr()
+		return
+	}
+
+	for name := range w.rw.Header() {
+		delete(w.rw.Header(), name)
+	}
+	for name, values := range hdr {
+		w.rw.Header()[name] = values
+	}
+
+	w.rw.WriteHeader(w.status)
+	_, _ = w.rw.Write(w.body.Bytes())
+}
@@ -0,0 +1,417 @@
+// BSD 3-Clause License
+
+// Copyright (c) 2021, James Bowes
+// Copyright (c) 2023, Alexander Taraymovich, OffBlocks
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package httpsig
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyMiddleware_ResponseSigning(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	signer := NewSigner(
+		WithHmacSha256("client-key", k),
+		WithSignFields("@method", "content-digest"),
+	)
+	responseVerifier := NewVerifier(WithHmacSha256("server-key", k))
+
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain")
+		rw.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(rw, "hello, ")
+		_, _ = io.WriteString(rw, "world")
+	})
+
+	serve := func(t *testing.T, mw func(http.Handler) http.Handler) *http.Response {
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString("{}"))
+		assert.NoError(t, err)
+		assert.NoError(t, signer.SignRequest(req))
+
+		rec := httptest.NewRecorder()
+		mw(handler).ServeHTTP(rec, req)
+
+		resp := rec.Result()
+		resp.Request = req
+		return resp
+	}
+
+	t.Run("signs the response", func(t *testing.T) {
+		mw := NewVerifyMiddleware(
+			WithHmacSha256("client-key", k),
+			WithVerifyResponseSigning(
+				WithHmacSha256("server-key", k),
+				WithSignFields("@status", "content-type", "content-digest"),
+			),
+		)
+
+		resp := serve(t, mw)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Equal(t, `sha-256=:Ccp+TqpuiunH0mEWcSkYSINkTQffuny/vEyKLgg2DVs=:`, resp.Header.Get(ContentDigestHeader))
+		assert.Contains(t, resp.Header.Get(SignatureInputHeader), `sig=("@status" "content-type" "content-digest")`)
+
+		body, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello, world", string(body))
+
+		assert.NoError(t, responseVerifier.Verify(MessageFromResponse(resp)))
+		assert.NoError(t, VerifyContentDigest(body, resp.Header.Get(ContentDigestHeader)))
+	})
+	t.Run("digests with the algorithm the request wants", func(t *testing.T) {
+		for _, tt := range []struct {
+			header, want, digest string
+		}{
+			{ContentDigestHeader, `sha-256=1, sha-512=5`, `sha-512=:`},
+			{ContentDigestHeader, `md5=10`, `sha-256=:`},
+			{ReprDigestHeader, `sha-512=5`, `sha-512=:`},
+		} {
+			mw := NewVerifyMiddleware(
+				WithHmacSha256("client-key", k),
+				WithVerifyResponseSigning(
+					WithHmacSha256("server-key", k),
+					WithSignFields("@status", strings.ToLower(tt.header)),
+					WithDigestHeader(tt.header),
+				),
+			)
+
+			req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString("{}"))
+			assert.NoError(t, err)
+			req.Header.Set("Want-"+tt.header, tt.want)
+			assert.NoError(t, signer.SignRequest(req))
+
+			rec := httptest.NewRecorder()
+			mw(handler).ServeHTTP(rec, req)
+			resp := rec.Result()
+			resp.Request = req
+
+			assert.True(t, strings.HasPrefix(resp.Header.Get(tt.header), tt.digest), tt.want)
+			assert.NoError(t, responseVerifier.Verify(MessageFromResponse(resp)))
+			assert.NoError(t, NewDigestor(WithDigestAlgorithms(DigestAlgorithmSha256, DigestAlgorithmSha512), WithDigestHeader(tt.header)).Verify([]byte("hello, world"), resp.Header))
+		}
+	})
+	t.Run("doesn't sign responses by default", func(t *testing.T) {
+		resp := serve(t, NewVerifyMiddleware(WithHmacSha256("client-key", k)))
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Empty(t, resp.Header.Values(SignatureHeader))
+	})
+	t.Run("fails responses over the buffer limit", func(t *testing.T) {
+		mw := NewVerifyMiddleware(
+			WithHmacSha256("client-key", k),
+			WithVerifyResponseSigning(
+				WithHmacSha256("server-key", k),
+				WithBodyBufferLimit(8),
+			),
+		)
+
+		resp := serve(t, mw)
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		assert.Empty(t, resp.Header.Values(SignatureHeader))
+
+		body, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.NotContains(t, string(body), "hello")
+	})
+	t.Run("fails responses that can't be signed", func(t *testing.T) {
+		mw := NewVerifyMiddleware(
+			WithHmacSha256("client-key", k),
+			WithVerifyResponseSigning(
+				WithHmacSha256("server-key", k),
+				WithSignFields("x-missing"),
+			),
+		)
+
+		resp := serve(t, mw)
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	})
+}
+
+func TestVerifyMiddleware_Challenge(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(rw, "hello, world")
+	})
+	serve := func(t *testing.T, opts ...verifyOption) *http.Response {
+		req, err := http.NewRequest("GET", "https://example.com/foo", nil)
+		assert.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		NewVerifyMiddleware(opts...)(handler).ServeHTTP(rec, req)
+		return rec.Result()
+	}
+
+	t.Run("doesn't request signatures by default", func(t *testing.T) {
+		resp := serve(t, WithHmacSha256("test-shared-secret", k))
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, "Signature", resp.Header.Get("WWW-Authenticate"))
+		assert.Empty(t, resp.Header.Get(AcceptSignatureHeader))
+	})
+	t.Run("challenges with the requirements", func(t *testing.T) {
+		resp := serve(t,
+			WithHmacSha256("test-shared-secret", k),
+			WithVerifyRequiredFields("@method", "@authority"),
+			WithVerifyRequiredParams("created"),
+			WithVerifyAllowedAlgorithms(AlgorithmHmacSha256),
+			WithVerifyChallenge(),
+		)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, "Signature", resp.Header.Get("WWW-Authenticate"))
+		assert.Equal(t, `sig=("@method" "@authority");created;alg="hmac-sha256"`, resp.Header.Get(AcceptSignatureHeader))
+
+		body, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "signature required", string(body))
+	})
+	t.Run("challenges invalid signatures", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "https://example.com/foo", nil)
+		assert.NoError(t, err)
+		req.Header.Set(SignatureInputHeader, `sig=("@method");keyid="test-shared-secret"`)
+		req.Header.Set(SignatureHeader, `sig=:AAAA:`)
+
+		rec := httptest.NewRecorder()
+		NewVerifyMiddleware(WithHmacSha256("test-shared-secret", k), WithVerifyChallenge())(handler).ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, `sig=()`, rec.Header().Get(AcceptSignatureHeader))
+		assert.Equal(t, "invalid required signature", rec.Body.String())
+	})
+	t.Run("challenges with the given signatures", func(t *testing.T) {
+		keyID := "test-shared-secret"
+		resp := serve(t,
+			WithHmacSha256("test-shared-secret", k),
+			WithVerifyChallenge(AcceptSignature{
+				Name:        "client",
+				Fields:      []string{"@method", "content-digest"},
+				Params:      []Param{ParamKeyID, ParamCreated},
+				ParamValues: &SignatureParameters{KeyID: &keyID},
+			}),
+		)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, `client=("@method" "content-digest");keyid="test-shared-secret";created`, resp.Header.Get(AcceptSignatureHeader))
+	})
+	t.Run("doesn't challenge valid requests", func(t *testing.T) {
+		s := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields("@method"))
+		req, err := http.NewRequest("GET", "https://example.com/foo", nil)
+		assert.NoError(t, err)
+		assert.NoError(t, s.SignRequest(req))
+
+		rec := httptest.NewRecorder()
+		NewVerifyMiddleware(WithHmacSha256("test-shared-secret", k), WithVerifyChallenge())(handler).ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Header().Get("WWW-Authenticate"))
+	})
+}
+
+func TestVerifyMiddleware_Errors(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(rw, "hello, world")
+	})
+	unsigned := func(t *testing.T) *http.Request {
+		req, err := http.NewRequest("GET", "https://example.com/foo", nil)
+		assert.NoError(t, err)
+		return req
+	}
+	invalid := func(t *testing.T) *http.Request {
+		req := unsigned(t)
+		req.Header.Set(SignatureInputHeader, `sig=("@method");keyid="test-shared-secret"`)
+		req.Header.Set(SignatureHeader, `sig=:AAAA:`)
+		return req
+	}
+	serve := func(req *http.Request, opts ...verifyOption) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		NewVerifyMiddleware(append([]verifyOption{WithHmacSha256("test-shared-secret", k)}, opts...)...)(handler).ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("requires a signature with a 401", func(t *testing.T) {
+		rec := serve(unsigned(t))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, "Signature", rec.Header().Get("WWW-Authenticate"))
+		assert.Equal(t, "signature required", rec.Body.String())
+	})
+	t.Run("rejects an invalid signature with a 400", func(t *testing.T) {
+		rec := serve(invalid(t))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Empty(t, rec.Header().Get("WWW-Authenticate"))
+		assert.Equal(t, "invalid required signature", rec.Body.String())
+	})
+	t.Run("rejects a malformed signature with a 400", func(t *testing.T) {
+		req := invalid(t)
+		req.Header.Set(SignatureHeader, `sig=(`)
+		assert.Equal(t, http.StatusBadRequest, serve(req).Code)
+	})
+	t.Run("rejects too many signatures with a 400", func(t *testing.T) {
+		req := unsigned(t)
+		for i := 0; i <= defaultMaxSignatures; i++ {
+			req.Header.Add(SignatureInputHeader, fmt.Sprintf(`sig%d=("@method");keyid="test-shared-secret"`, i))
+			req.Header.Add(SignatureHeader, fmt.Sprintf(`sig%d=:AAAA:`, i))
+		}
+		rec := serve(req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, "invalid required signature", rec.Body.String())
+	})
+	t.Run("rejects keys failing to resolve with a 503", func(t *testing.T) {
+		req := invalid(t)
+		req.Header.Set(SignatureInputHeader, `sig=("@method");keyid="remote-key"`)
+		resolver := &failingResolver{err: errors.New("key server unavailable")}
+		rec := serve(req, WithVerifyingKeyResolver(resolver), WithVerifyChallenge())
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Empty(t, rec.Header().Get("WWW-Authenticate"))
+		assert.Equal(t, "unable to resolve key", rec.Body.String())
+	})
+	t.Run("rejects a body too large to verify with a 413", func(t *testing.T) {
+		req, err := http.NewRequest("POST", "https://example.com/foo", strings.NewReader(`{"hello": "world"}`))
+		assert.NoError(t, err)
+		s := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields("@method", "content-digest"))
+		assert.NoError(t, s.SignRequest(req))
+
+		rec := serve(req, WithBodyBufferLimit(8))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+		assert.Equal(t, "request body too large", rec.Body.String())
+	})
+	t.Run("uses a custom error handler", func(t *testing.T) {
+		handleErr := func(rw http.ResponseWriter, r *http.Request, err error) {
+			if errors.Is(err, ErrSignatureInvalid) {
+				rw.WriteHeader(http.StatusForbidden)
+				return
+			}
+			rw.WriteHeader(http.StatusTeapot)
+		}
+		assert.Equal(t, http.StatusForbidden, serve(invalid(t), WithVerifyErrorHandler(handleErr)).Code)
+		assert.Equal(t, http.StatusTeapot, serve(unsigned(t), WithVerifyErrorHandler(handleErr)).Code)
+	})
+}
+
+func TestVerifyMiddleware_BodyAlreadyRead(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	const body = `{"hello": "world"}`
+	signer := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields("@method", "content-digest"))
+	var read string
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		read = string(b)
+	})
+	// logs the body as middleware running before verification could, leaving it read
+	logging := func(getBody bool) func(http.Handler) http.Handler {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				if getBody {
+					r.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(b)), nil }
+				}
+				h.ServeHTTP(rw, r)
+			})
+		}
+	}
+	send := func(t *testing.T, getBody bool) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		assert.NoError(t, signer.SignRequest(req))
+		// as received by a server, without the GetBody of the client
+		req.GetBody = nil
+
+		read = ""
+		rec := httptest.NewRecorder()
+		logging(getBody)(NewVerifyMiddleware(WithHmacSha256("test-shared-secret", k))(handler)).ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("rejects a body already read", func(t *testing.T) {
+		rec := send(t, false)
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Equal(t, "request body already read", rec.Body.String())
+
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		assert.NoError(t, signer.SignRequest(req))
+		req.GetBody = nil
+		_, _ = io.ReadAll(req.Body)
+		assert.ErrorIs(t, NewVerifier(WithHmacSha256("test-shared-secret", k)).VerifyRequest(req), ErrBodyConsumed)
+	})
+	t.Run("reads a body already read again from GetBody", func(t *testing.T) {
+		rec := send(t, true)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, body, read)
+	})
+}
+
+func TestVerifyMiddleware_Result(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	var result VerificationResult
+	var ok bool
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		result, ok = VerificationResultFromContext(r.Context())
+	})
+	mw := NewVerifyMiddleware(WithHmacSha256("test-shared-secret", k))(handler)
+
+	s := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields("@method", "content-digest"))
+	req, err := http.NewRequest("POST", "https://example.com/foo", strings.NewReader("hello, world"))
+	assert.NoError(t, err)
+	assert.NoError(t, s.SignRequest(req))
+
+	inputs, err := ParseSignatureHeaders(req.Header)
+	assert.NoError(t, err)
+
+	mw.ServeHTTP(httptest.NewRecorder(), req)
+	assert.True(t, ok)
+	assert.Equal(t, VerificationResult{Signatures: inputs, BodyCovered: true, DigestVerified: true}, result)
+
+	_, ok = VerificationResultFromContext(req.Context())
+	assert.False(t, ok)
+}
@@ -0,0 +1,132 @@
+// BSD 3-Clause License
+
+// Copyright (c) 2021, James Bowes
+// Copyright (c) 2023, Alexander Taraymovich, OffBlocks
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package httpsig
+
+import "time"
+
+// VerifyEvent describes the outcome of verifying a message, passed to the observer configured
+// with WithVerifyObserver.
+//
+// When a message has several signatures, the signature details describe the last signature that
+// was checked, which is the one that failed verification if any did.
+type VerifyEvent struct {
+	// The name of the signature
+	Name string
+
+	// The key id of the signature, if any
+	KeyID string
+
+	// The algorithm of the signature, either declared or of the key used to verify it, if known
+	Algorithm Algorithm
+
+	// Parameters of the signature other than those defined by RFC 9421, by name, if any
+	Extra map[string]any
+
+	// The error verification failed with, or nil if it succeeded
+	Err error
+
+	// How long verification took, including resolving keys
+	Duration time.Duration
+}
+
+// SignEvent describes the outcome of signing a message, passed to the observer configured with
+// WithSignObserver. It is a copy, so the observer can't change the message through it.
+type SignEvent struct {
+	// The configured name of the signature. The name used may have a number appended to make it
+	// unique within the message.
+	Name string
+
+	// The key id of the signature
+	KeyID string
+
+	// The algorithm of the signature
+	Algorithm Algorithm
+
+	// The method and path of the request signed, or of the request responded to
+	Method string
+	Path   string
+
+	// The signatures added to the message, including any added with WithSignSignature, in the
+	// order they were added. Empty if signing failed.
+	Signatures []SignedSignature
+
+	// The error signing failed with, or nil if it succeeded
+	Err error
+
+	// How long signing took
+	Duration time.Duration
+}
+
+// SignedSignature describes a signature added to a message
+type SignedSignature struct {
+	// The name of the signature in the message, empty in Cavage compatibility mode
+	Name string
+
+	// The key id of the signature
+	KeyID string
+
+	// The algorithm of the signature
+	Algorithm Algorithm
+}
+
+// newSignEvent describes signing the message with the given configuration
+func newSignEvent(msg *Message, config *SignConfig, signed []SignedSignature, err error, d time.Duration) SignEvent {
+	ev := SignEvent{Name: "sig", Err: err, Duration: d, Method: msg.Method}
+	if config.Name != nil {
+		ev.Name = *config.Name
+	}
+	ev.KeyID = signingKeyID(config)
+	ev.Algorithm = signingAlgorithm(config)
+	if msg.URL != nil {
+		ev.Path = msg.URL.Path
+	}
+	if err == nil {
+		ev.Signatures = signed
+	}
+	return ev
+}
+
+// newSignedSignature describes the signature added with the given name and configuration
+func newSignedSignature(name string, config *SignConfig) SignedSignature {
+	return SignedSignature{Name: name, KeyID: signingKeyID(config), Algorithm: signingAlgorithm(config)}
+}
+
+// signingAlgorithm returns the algorithm the configuration signs with, if any
+func signingAlgorithm(config *SignConfig) Algorithm {
+	if config.ParamValues != nil && config.ParamValues.Alg != nil {
+		return *config.ParamValues.Alg
+	}
+	if config.Key != nil {
+		return config.Key.GetAlgorithm()
+	}
+	return ""
+}
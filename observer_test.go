@@ -0,0 +1,171 @@
+// BSD 3-Clause License
+
+// Copyright (c) 2021, James Bowes
+// Copyright (c) 2023, Alexander Taraymovich, OffBlocks
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package httpsig
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyObserver(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	s := NewSigner(
+		WithHmacSha256("test-shared-secret", k),
+		WithSignFields("@method", "content-digest"),
+	)
+
+	var events []VerifyEvent
+	v := NewVerifier(
+		WithHmacSha256("test-shared-secret", k),
+		WithVerifyObserver(func(ev VerifyEvent) { events = append(events, ev) }),
+	)
+
+	signed := func(t *testing.T) *http.Request {
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString("{}"))
+		assert.NoError(t, err)
+		assert.NoError(t, s.SignRequest(req))
+		return req
+	}
+
+	t.Run("observes a successful verification", func(t *testing.T) {
+		events = nil
+		assert.NoError(t, v.VerifyRequest(signed(t)))
+
+		assert.Len(t, events, 1)
+		assert.Equal(t, "sig", events[0].Name)
+		assert.Equal(t, "test-shared-secret", events[0].KeyID)
+		assert.Equal(t, AlgorithmHmacSha256, events[0].Algorithm)
+		assert.NoError(t, events[0].Err)
+		assert.Positive(t, events[0].Duration)
+	})
+	t.Run("observes a failed verification", func(t *testing.T) {
+		events = nil
+		req := signed(t)
+		req.Body = io.NopCloser(bytes.NewBufferString("[]"))
+		assert.ErrorIs(t, v.VerifyRequest(req), ErrDigestMismatch)
+
+		assert.Len(t, events, 1)
+		assert.Equal(t, "test-shared-secret", events[0].KeyID)
+		assert.ErrorIs(t, events[0].Err, ErrDigestMismatch)
+	})
+	t.Run("observes a message without signatures", func(t *testing.T) {
+		events = nil
+		assert.ErrorIs(t, v.Verify(MessageFromRequest(testReq())), ErrNoSignature)
+
+		assert.Len(t, events, 1)
+		assert.Empty(t, events[0].Name)
+		assert.ErrorIs(t, events[0].Err, ErrNoSignature)
+	})
+}
+
+func TestSignObserver(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	var events []SignEvent
+	observer := WithSignObserver(func(ev SignEvent) { events = append(events, ev) })
+
+	t.Run("observes a successful signing", func(t *testing.T) {
+		events = nil
+		s := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithSignName("my-sig"),
+			observer,
+		)
+		_, err := s.Sign(MessageFromRequest(testReq()))
+		assert.NoError(t, err)
+
+		assert.Len(t, events, 1)
+		assert.Equal(t, "my-sig", events[0].Name)
+		assert.Equal(t, "test-shared-secret", events[0].KeyID)
+		assert.Equal(t, AlgorithmHmacSha256, events[0].Algorithm)
+		assert.NoError(t, events[0].Err)
+	})
+	t.Run("observes a failed signing", func(t *testing.T) {
+		events = nil
+		s := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithSignFields("@status"),
+			observer,
+		)
+		_, err := s.Sign(MessageFromRequest(testReq()))
+		assert.Error(t, err)
+
+		assert.Len(t, events, 1)
+		assert.Equal(t, err, events[0].Err)
+		assert.Empty(t, events[0].Signatures)
+	})
+	t.Run("describes the signatures added", func(t *testing.T) {
+		events = nil
+		_, priv, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err)
+		s := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithSignSignature(WithSignEd25519("test-key-ed25519", priv), WithSignName("proxy")),
+			observer,
+		)
+
+		req := testReq()
+		req.Header.Set(SignatureInputHeader, `sig=("@method");keyid="upstream"`)
+		req.Header.Set(SignatureHeader, `sig=:AAAA:`)
+		assert.NoError(t, s.SignRequest(req))
+
+		assert.Len(t, events, 1)
+		assert.Equal(t, "POST", events[0].Method)
+		assert.Equal(t, "/foo", events[0].Path)
+		assert.Equal(t, []SignedSignature{
+			{Name: "sig1", KeyID: "test-shared-secret", Algorithm: AlgorithmHmacSha256},
+			{Name: "proxy", KeyID: "test-key-ed25519", Algorithm: AlgorithmEd25519},
+		}, events[0].Signatures)
+	})
+	t.Run("describes cavage signatures", func(t *testing.T) {
+		events = nil
+		s := NewSigner(WithHmacSha256("test-shared-secret", k), WithCavageCompat(true), observer)
+		_, err := s.Sign(MessageFromRequest(testReq()))
+		assert.NoError(t, err)
+
+		assert.Len(t, events, 1)
+		assert.Equal(t, []SignedSignature{{KeyID: "test-shared-secret", Algorithm: AlgorithmHmacSha256}}, events[0].Signatures)
+	})
+}
@@ -35,6 +35,10 @@ import (
 	"crypto/ecdsa"
 	"crypto/ed25519"
 	"crypto/rsa"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
 	"time"
 )
 
@@ -55,6 +59,12 @@ type signOrVerifyOption interface {
 	verifyOption
 }
 
+type signVerifyOrDigestOption interface {
+	signOption
+	verifyOption
+	digestOption
+}
+
 type optImpl struct {
 	s func(s *signer)
 	v func(v *verifier)
@@ -73,7 +83,41 @@ func WithSignName(name string) signOption {
 	}
 }
 
-// WithSignParams sets the signature parameters to be included in signing.
+// WithSignNamePrefix sets the prefix of the names of the signature and any added with
+// WithSignSignature, other than those named with WithSignName. They are numbered from 0 in the
+// order of their key ids, eg: `sig-0`, `sig-1` for a prefix of `sig-`, so each keeps its name from
+// one message to the next.
+// default: each signature is named "sig"
+func WithSignNamePrefix(prefix string) signOption {
+	return &optImpl{
+		s: func(s *signer) { s.config.NamePrefix = prefix },
+	}
+}
+
+// WithSignReplaceExistingHeaders sets whether Signature and Signature-Input headers already on
+// the message are replaced when signing. By default the signature is added to them, so that a
+// signature added on the way, eg: by a client before a proxy signs the request, is kept.
+// default: false
+func WithSignReplaceExistingHeaders(replace bool) signOption {
+	return &optImpl{
+		s: func(s *signer) { s.config.ReplaceExistingHeaders = replace },
+	}
+}
+
+// WithSignIfAbsent sets whether requests already signed with the signature, ie: with a signature
+// of its name and key id in their Signature and Signature-Input headers, are left untouched when
+// signing requests, eg: by NewSignTransport, rather than signed again. The existing signature
+// isn't verified. Use it for requests signed before being sent, or passing through several
+// signing layers.
+// default: false
+func WithSignIfAbsent(ifAbsent bool) signOption {
+	return &optImpl{
+		s: func(s *signer) { s.config.IfAbsent = ifAbsent },
+	}
+}
+
+// WithSignParams sets the signature parameters to be included in signing. The alg parameter is
+// optional, leave out ParamAlg to sign without declaring the algorithm of the key.
 // default: created, keyid, alg
 func WithSignParams(params ...Param) signOption {
 	return &optImpl{
@@ -82,6 +126,9 @@ func WithSignParams(params ...Param) signOption {
 }
 
 // WithSignFields sets the HTTP fields / derived component names to be included in signing.
+// Component parameters may be included, eg: `"cache-control";sf` to sign the strict
+// structured field serialisation of a header. HTTP field names are lowercased and duplicate
+// components are dropped. Fields are signed in exactly the order given, and no fields are added.
 // default: none
 func WithSignFields(fields ...string) signOption {
 	return &optImpl{
@@ -89,13 +136,62 @@ func WithSignFields(fields ...string) signOption {
 	}
 }
 
-// WithSignParamValues sets the signature parameters to be included in signing.
+// WithSignParamValues sets the signature parameters to be included in signing. Extra parameters
+// are signed as if added with WithSignParam, which replaces any with the same name.
 func WithSignParamValues(params *SignatureParameters) signOption {
 	return &optImpl{
 		s: func(s *signer) { s.config.ParamValues = params },
 	}
 }
 
+// WithSignParam adds a parameter other than those defined by RFC 9421 to signatures, replacing any
+// previously added with the same name. The value must be a string, integer or boolean, and the
+// name a lowercase structured field key, otherwise signing fails. Verifiers reject signatures with
+// the parameter unless it is added with WithVerifyExtraParams.
+// default: no extra parameters
+func WithSignParam(name string, value any) signOption {
+	return &optImpl{
+		s: func(s *signer) {
+			if s.config.ExtraParams == nil {
+				s.config.ExtraParams = make(map[string]any)
+			}
+			s.config.ExtraParams[name] = value
+		},
+	}
+}
+
+// WithSignAcceptSignature sets whether responses are signed using the signature requested by
+// the Accept-Signature header of the request. Requests naming a different key id or algorithm
+// are ignored. Signing fails if the accepted request covers a component that can't be derived for
+// the response, eg: a header it doesn't have, wrapping ErrMissingCoveredComponent for headers.
+// default: false
+func WithSignAcceptSignature(accept bool) signOption {
+	return &optImpl{
+		s: func(s *signer) { s.config.AcceptSignature = accept },
+	}
+}
+
+// WithSignSignature adds another signature to every message signed, configured with the given
+// options as if creating a new signer. Use it to sign with several keys, or to cover different
+// fields with each signature. Give each signature a distinct name with WithSignName.
+func WithSignSignature(opts ...signOption) signOption {
+	return &optImpl{
+		s: func(s *signer) {
+			s.config.Signatures = append(s.config.Signatures, NewSigner(opts...).config)
+		},
+	}
+}
+
+// WithSignObserver sets a func called once for every message signed, eg: to record metrics, log
+// failures or audit the keys and algorithms signed with. The observer must be safe for concurrent
+// use.
+// default: nil
+func WithSignObserver(observer func(ev SignEvent)) signOption {
+	return &optImpl{
+		s: func(s *signer) { s.config.Observer = observer },
+	}
+}
+
 // WithSignRsaPkcs1v15Sha256 adds signing using `rsa-v1_5-sha256` with the given private key
 // using the given key id.
 func WithSignRsaPkcs1v15Sha256(keyID string, pk *rsa.PrivateKey) signOption {
@@ -128,6 +224,26 @@ func WithSignEcdsaP384Sha384(keyID string, pk *ecdsa.PrivateKey) signOption {
 	}
 }
 
+// WithSignEcdsaP256Sha256Deterministic adds signing using `ecdsa-p256-sha256` with the given
+// private key using the given key id, deriving nonces as described by RFC 6979 rather than
+// randomly, so that signing the same signature base always makes the same signature, eg: to
+// reproduce captured signatures for an audit. Verifiers verify them like any other signature.
+// Signing requires Go 1.24 or later, and fails when built with an earlier version.
+func WithSignEcdsaP256Sha256Deterministic(keyID string, pk *ecdsa.PrivateKey) signOption {
+	return &optImpl{
+		s: func(s *signer) { s.config.Key = &DeterministicEcdsaP256SigningKey{pk, keyID} },
+	}
+}
+
+// WithSignEcdsaP384Sha384Deterministic adds signing using `ecdsa-p384-sha384` with the given
+// private key using the given key id, deriving nonces as described by RFC 6979, as
+// WithSignEcdsaP256Sha256Deterministic, and likewise requires Go 1.24 or later.
+func WithSignEcdsaP384Sha384Deterministic(keyID string, pk *ecdsa.PrivateKey) signOption {
+	return &optImpl{
+		s: func(s *signer) { s.config.Key = &DeterministicEcdsaP384SigningKey{pk, keyID} },
+	}
+}
+
 // WithSignEd25519 adds signing using `ed25519` with the given private key
 // using the given key id.
 func WithSignEd25519(keyID string, pk ed25519.PrivateKey) signOption {
@@ -136,13 +252,55 @@ func WithSignEd25519(keyID string, pk ed25519.PrivateKey) signOption {
 	}
 }
 
-// WithVerifyingKeyResolver sets the resolver to use for verifying keys.
+// WithSignEd25519ph adds signing using `ed25519ph`, Ed25519 over the SHA-512 hash of the
+// signature base, with the given private key using the given key id. The algorithm isn't defined
+// by RFC 9421, so only use it with verifiers expecting it.
+func WithSignEd25519ph(keyID string, pk ed25519.PrivateKey) signOption {
+	return &optImpl{
+		s: func(s *signer) { s.config.Key = &Ed25519phSigningKey{pk, keyID} },
+	}
+}
+
+// WithVerifyingKeyResolver sets the resolver to use for verifying keys. Use a
+// MultiVerifyingKeyResolver to accept signatures from any of several keys for a key id.
 func WithVerifyingKeyResolver(resolver VerifyingKeyResolver) verifyOption {
 	return &optImpl{
 		v: func(v *verifier) { v.config.KeyResolver = resolver },
 	}
 }
 
+// WithVerifyKeyFunc sets a func selecting the key to verify each signature with, given the whole
+// message and the signature input, eg: to choose a key by a tenant in the path or a header when
+// key ids aren't unique across tenants. It takes precedence over the keys added with the other
+// `WithVerify*` options and the key resolver. Returning a nil key treats the key id as unknown.
+// The declared algorithm must still match the key's.
+// default: nil
+func WithVerifyKeyFunc(f func(msg *Message, input SignatureInput) (VerifyingKey, error)) verifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.config.KeyFunc = f },
+	}
+}
+
+// WithVerifyResolverErrorFailsClosed sets whether an error resolving a key, from the key resolver
+// or key func, fails verification with the error wrapped in ErrKeyResolution, so that a key server
+// outage can be told apart from an unknown key id. Otherwise the key id is treated as unknown, and
+// another signature with a known key id is verified if there is one.
+// default: true
+func WithVerifyResolverErrorFailsClosed(failClosed bool) verifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.config.ResolverErrorsAsUnknown = !failClosed },
+	}
+}
+
+// WithVerifyDefaultKeyID sets the key id used to verify signatures that don't declare one, for
+// peers that agree keys out of band.
+// default: signatures without a key id are only verified by a VerifyingKeyTagResolver
+func WithVerifyDefaultKeyID(keyID string) verifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.config.DefaultKeyID = keyID },
+	}
+}
+
 // WithVerifyNotAfter sets the time after which signatures are considered expired.
 // default: time.Now() + 5 mins
 func WithVerifyNotAfter(t time.Time) verifyOption {
@@ -151,7 +309,8 @@ func WithVerifyNotAfter(t time.Time) verifyOption {
 	}
 }
 
-// WithVerifyMaxAge sets the maximum age of a signature.
+// WithVerifyMaxAge sets the maximum age of a signature. Signatures without a created time have no
+// age to check, so are accepted unless WithVerifyRequireCreated is also set.
 // default: 0
 func WithVerifyMaxAge(d time.Duration) verifyOption {
 	return &optImpl{
@@ -159,6 +318,15 @@ func WithVerifyMaxAge(d time.Duration) verifyOption {
 	}
 }
 
+// WithVerifyRequireCreated sets whether signatures without a created parameter are rejected with
+// ErrCreatedRequired, so that the freshness of every signature can be checked.
+// default: false
+func WithVerifyRequireCreated(require bool) verifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.config.RequireCreated = require },
+	}
+}
+
 // WithVerifyTolerance sets the clock tolerance for verifying created and expires times.
 // default: 0
 func WithVerifyTolerance(d time.Duration) verifyOption {
@@ -183,7 +351,73 @@ func WithVerifyRequiredFields(fields ...string) verifyOption {
 	}
 }
 
-// WithVerifyAll sets whether all signatures must be valid.
+// WithOptionalFields sets the HTTP fields covered by signatures only when messages have them, eg:
+// Idempotency-Key, present on writes but not reads. Signers add each of these fields the message
+// has to the signed fields, and leave those it doesn't have out of the signature entirely, so the
+// signature base of a message without the field has no line for it at all rather than an empty
+// one. Verifiers reject signatures of messages with one of these fields not covering it with
+// ErrMalformedSignature, so that it can't be added unsigned, while accepting messages without it.
+// default: []
+func WithOptionalFields(fields ...string) signOrVerifyOption {
+	lower := make([]string, len(fields))
+	for i, f := range fields {
+		lower[i] = strings.ToLower(f)
+	}
+	return &optImpl{
+		s: func(s *signer) { s.config.OptionalFields = lower },
+		v: func(v *verifier) { v.config.OptionalFields = lower },
+	}
+}
+
+// WithVerifyRequireLowS sets whether ECDSA signatures with a high s value are rejected, so that
+// each signature has a single valid encoding. Signers in this package always use the low form.
+// default: false
+func WithVerifyRequireLowS(require bool) verifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.config.RequireLowS = require },
+	}
+}
+
+// WithVerifyLenientParams sets whether signatures with unknown parameters are verified, ignoring
+// them, rather than rejected with an error naming the parameter. Parameters added with
+// WithVerifyExtraParams are known, so allow-listing the parameters expected is usually better.
+// default: false
+func WithVerifyLenientParams(lenient bool) verifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.config.LenientParams = lenient },
+	}
+}
+
+// WithVerifyExtraParams adds names of parameters other than those defined by RFC 9421 that
+// signatures are expected to carry, so that they are accepted.
+// default: none
+func WithVerifyExtraParams(names ...string) verifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.config.ExtraParams = append(v.config.ExtraParams, names...) },
+	}
+}
+
+// WithVerifyLenientComponentCase sets whether signatures covering derived components whose names
+// aren't lowercase, eg: `@Method`, are accepted from non-conformant signers rather than rejected.
+// default: false
+func WithVerifyLenientComponentCase(lenient bool) verifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.config.LenientComponentCase = lenient },
+	}
+}
+
+// WithVerifyMaxSignatures sets the most signatures a message may have. Messages with more are
+// rejected with ErrTooManySignatures before any signature is verified or key resolved, and the
+// middleware responds to them with a `400`. A negative limit allows any number of signatures.
+// default: 8
+func WithVerifyMaxSignatures(n int) verifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.config.MaxSignatures = n },
+	}
+}
+
+// WithVerifyAll sets whether all signatures must be valid. Otherwise, signatures with unknown key
+// ids are skipped and the first with a known key id must be valid.
 // default: false
 func WithVerifyAll(all bool) verifyOption {
 	return &optImpl{
@@ -191,11 +425,145 @@ func WithVerifyAll(all bool) verifyOption {
 	}
 }
 
+// WithVerifyAllowedAlgorithms sets the algorithms signatures may use, regardless of the keys
+// configured. Signatures using any other algorithm fail with ErrAlgorithmNotAllowed.
+// default: all supported algorithms
+func WithVerifyAllowedAlgorithms(algs ...Algorithm) verifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.config.AllowedAlgorithms = algs },
+	}
+}
+
+// WithVerifyDigestAlgorithms sets the digest algorithms a Content-Digest header may be verified
+// with, replacing any previously set. Digests using other algorithms are ignored, and requests with
+// none using these algorithms fail with ErrDigestAlgorithmNotAllowed.
+// default: all supported digest algorithms
+func WithVerifyDigestAlgorithms(algorithms ...DigestAlgorithm) verifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.config.DigestAlgorithms = algorithms },
+	}
+}
+
+// WithVerifyBeforeBody sets whether the body of requests is verified against their digest as
+// it's read, once the signatures have been verified, rather than being read in full first. A body
+// that doesn't match its digest fails to read with ErrDigestMismatch, so must be read to the end
+// before being trusted. Bodies are never read when the digest isn't covered by a signature.
+// default: false
+func WithVerifyBeforeBody(beforeBody bool) verifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.config.BeforeBody = beforeBody },
+	}
+}
+
+// WithVerifyAllowUnsignedDigest sets whether requests with a digest header that no verified
+// signature covers are accepted, ignoring the header. Disallowed, they fail with
+// ErrUnsignedDigest, so that a body the client meant to protect is never trusted unprotected. A
+// covered digest header must be present and match the body either way.
+// default: true
+func WithVerifyAllowUnsignedDigest(allow bool) verifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.config.RejectUnsignedDigest = !allow },
+	}
+}
+
+// WithVerifyRequireDigestForContentTypes sets the media types of request bodies that must be
+// protected by a covered digest header matching the body, eg: application/json. Requests with
+// one of these content types fail with ErrDigestRequired without a digest, or ErrUnsignedDigest if
+// no verified signature covers it. Other content types are verified as usual.
+// default: [] (no content type requires a digest)
+func WithVerifyRequireDigestForContentTypes(mediaTypes ...string) verifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.config.DigestContentTypes = mediaTypes },
+	}
+}
+
+// WithVerifyRedactedFields sets the HTTP fields whose values are masked in signature bases
+// returned by Verifier.SignatureBase, eg: `authorization` to cover a bearer token without it being
+// logged when debugging a signature. Verification always uses the real values.
+// default: none
+func WithVerifyRedactedFields(fields ...string) verifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.config.RedactedFields = fields },
+	}
+}
+
+// WithVerifyTrustedProxyHeaders sets whether the scheme and authority of requests, used by the
+// `@scheme`, `@authority` and `@target-uri` components, are taken from the Forwarded,
+// X-Forwarded-Proto and X-Forwarded-Host headers. Only enable this behind a trusted reverse proxy
+// that sets these headers, as otherwise clients can spoof them.
+// default: false
+func WithVerifyTrustedProxyHeaders(trust bool) verifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.config.TrustProxyHeaders = trust },
+	}
+}
+
+// WithVerifyResponseSigning sets the options used by a middleware returned by NewVerifyMiddleware
+// to sign the responses of the handlers it wraps, adding a Content-Digest header for the body.
+// default: responses aren't signed
+func WithVerifyResponseSigning(opts ...signOption) verifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.responseSigner = NewSigner(opts...) },
+	}
+}
+
+// WithVerifyChallenge sets a middleware returned by NewVerifyMiddleware to reject requests with a
+// `401` response with a `WWW-Authenticate: Signature` header, and an Accept-Signature header
+// requesting the given signatures. Without any signatures given, a signature named `sig` covering
+// the required fields and parameters is requested.
+// default: requests are rejected with a `400` response
+func WithVerifyChallenge(accepts ...AcceptSignature) verifyOption {
+	return &optImpl{
+		v: func(v *verifier) {
+			v.challenge = true
+			v.accepts = accepts
+		},
+	}
+}
+
+// WithVerifyErrorHandler sets the func a middleware returned by NewVerifyMiddleware uses to
+// respond to requests that fail verification, eg: to map errors to other statuses. Use errors.Is
+// with the Err* errors to find why a request failed.
+// default: `401` for requests without signatures, `400` otherwise
+func WithVerifyErrorHandler(handler func(rw http.ResponseWriter, r *http.Request, err error)) verifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.errorHandler = handler },
+	}
+}
+
+// WithVerifyObserver sets a func called once for every message verified, eg: to record metrics or
+// log failures. The observer must be safe for concurrent use.
+// default: nil
+func WithVerifyObserver(observer func(ev VerifyEvent)) verifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.config.Observer = observer },
+	}
+}
+
+// WithVerifySignatureSelector sets a func choosing the one signature to verify from those declared
+// by a message, eg: to prefer a signature by its tag, algorithm or key id rather than its position.
+// Verification fails with ErrNoSignature if the selector doesn't choose a signature.
+// default: nil
+func WithVerifySignatureSelector(selector func(inputs []SignatureInput) (name string, ok bool)) verifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.config.SignatureSelector = selector },
+	}
+}
+
+// WithVerifyKeyUntil adds signature verification with the given key using the given key id, only
+// accepted until the given time inclusive, eg: for a key being rotated out. Signatures with the key
+// fail with ErrKeyExpired after then, unless another signature of the message can be verified.
+func WithVerifyKeyUntil(keyID string, key VerifyingKey, until time.Time) verifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.addKey(&expiringKey{key, keyID, until}) },
+	}
+}
+
 // WithVerifyRsaPkcs1v15Sha256 adds signature verification using `rsa-v1_5-sha256` with the
 // given public key using the given key id.
 func WithVerifyRsaPkcs1v15Sha256(keyID string, pk *rsa.PublicKey) verifyOption {
 	return &optImpl{
-		v: func(v *verifier) { v.config.Keys[keyID] = &RsaPkcs1v15Sha256VerifyingKey{pk, keyID} },
+		v: func(v *verifier) { v.addKey(&RsaPkcs1v15Sha256VerifyingKey{pk, keyID}) },
 	}
 }
 
@@ -203,7 +571,7 @@ func WithVerifyRsaPkcs1v15Sha256(keyID string, pk *rsa.PublicKey) verifyOption {
 // given public key using the given key id.
 func WithVerifyRsaPssSha512(keyID string, pk *rsa.PublicKey) verifyOption {
 	return &optImpl{
-		v: func(v *verifier) { v.config.Keys[keyID] = &RsaPssSha512VerifyingKey{pk, keyID} },
+		v: func(v *verifier) { v.addKey(&RsaPssSha512VerifyingKey{pk, keyID}) },
 	}
 }
 
@@ -211,7 +579,7 @@ func WithVerifyRsaPssSha512(keyID string, pk *rsa.PublicKey) verifyOption {
 // given public key using the given key id.
 func WithVerifyEcdsaP256Sha256(keyID string, pk *ecdsa.PublicKey) verifyOption {
 	return &optImpl{
-		v: func(v *verifier) { v.config.Keys[keyID] = &EcdsaP256VerifyingKey{pk, keyID} },
+		v: func(v *verifier) { v.addKey(&EcdsaP256VerifyingKey{pk, keyID}) },
 	}
 }
 
@@ -219,7 +587,7 @@ func WithVerifyEcdsaP256Sha256(keyID string, pk *ecdsa.PublicKey) verifyOption {
 // given public key using the given key id.
 func WithVerifyEcdsaP384Sha384(keyID string, pk *ecdsa.PublicKey) verifyOption {
 	return &optImpl{
-		v: func(v *verifier) { v.config.Keys[keyID] = &EcdsaP384VerifyingKey{pk, keyID} },
+		v: func(v *verifier) { v.addKey(&EcdsaP384VerifyingKey{pk, keyID}) },
 	}
 }
 
@@ -227,7 +595,16 @@ func WithVerifyEcdsaP384Sha384(keyID string, pk *ecdsa.PublicKey) verifyOption {
 // given public key using the given key id.
 func WithVerifyEd25519(keyID string, pk ed25519.PublicKey) verifyOption {
 	return &optImpl{
-		v: func(v *verifier) { v.config.Keys[keyID] = &Ed25519VerifyingKey{pk, keyID} },
+		v: func(v *verifier) { v.addKey(&Ed25519VerifyingKey{pk, keyID}) },
+	}
+}
+
+// WithVerifyEd25519ph adds signature verification using `ed25519ph`, Ed25519 over the SHA-512
+// hash of the signature base, with the given public key using the given key id. The algorithm
+// isn't defined by RFC 9421, so only signers expecting it use it.
+func WithVerifyEd25519ph(keyID string, pk ed25519.PublicKey) verifyOption {
+	return &optImpl{
+		v: func(v *verifier) { v.addKey(&Ed25519phVerifyingKey{pk, keyID}) },
 	}
 }
 
@@ -236,14 +613,156 @@ func WithVerifyEd25519(keyID string, pk ed25519.PublicKey) verifyOption {
 func WithHmacSha256(keyID string, secret []byte) signOrVerifyOption {
 	return &optImpl{
 		s: func(s *signer) { s.config.Key = &HmacSha256SigningKey{secret, keyID} },
-		v: func(v *verifier) { v.config.Keys[keyID] = &HmacSha256VerifyingKey{secret, keyID} },
+		v: func(v *verifier) { v.addKey(&HmacSha256VerifyingKey{secret, keyID}) },
+	}
+}
+
+// WithBodyBufferLimit sets the largest request body, in bytes, read in full to add or verify a
+// Content-Digest header. Requests with larger bodies fail with ErrBodyTooLarge rather than being
+// buffered.
+// default: 0 (no limit)
+func WithBodyBufferLimit(n int64) signOrVerifyOption {
+	return &optImpl{
+		s: func(s *signer) { s.config.BodyBufferLimit = n },
+		v: func(v *verifier) { v.config.BodyBufferLimit = n },
+	}
+}
+
+// WithCavageCompat sets whether messages are signed and verified using the format of
+// draft-cavage-http-signatures-12 rather than RFC 9421, for interoperating with peers that only
+// support the older draft. Fields are then named as in the draft, eg: `(request-target)`, `host`.
+// default: false
+func WithCavageCompat(compat bool) signOrVerifyOption {
+	return &optImpl{
+		s: func(s *signer) { s.config.Cavage = compat },
+		v: func(v *verifier) { v.config.Cavage = compat },
+	}
+}
+
+// WithComponentResolver adds a resolver for an application specific component with the given
+// name, eg: a value assembled from several sources. Signing or verifying a signature covering the
+// component uses the value it resolves for the message, and fails if it returns an error.
+// Component names are case insensitive. The resolver must be safe for concurrent use.
+func WithComponentResolver(name string, resolve func(msg *Message) (string, error)) signOrVerifyOption {
+	name = strings.ToLower(name)
+	return &optImpl{
+		s: func(s *signer) {
+			if s.config.Components == nil {
+				s.config.Components = make(map[string]func(msg *Message) (string, error))
+			}
+			s.config.Components[name] = resolve
+		},
+		v: func(v *verifier) {
+			if v.config.Components == nil {
+				v.config.Components = make(map[string]func(msg *Message) (string, error))
+			}
+			v.config.Components[name] = resolve
+		},
+	}
+}
+
+// WithCanonicalizeContentType sets whether the content-type field is signed and verified with its
+// media type reformatted canonically, ie: parsed with mime.ParseMediaType and formatted again with
+// the type, subtype, parameter names and charset lowercase. Values such as
+// `application/json;charset=UTF-8` and `application/json; charset=utf-8` then sign alike, so that
+// signatures survive reformatting by proxies. Signers and verifiers must both canonicalise the
+// field. Content types that can't be parsed, and the field with `sf`, `key` or `bs`, are covered
+// as they are.
+// default: false
+func WithCanonicalizeContentType(canonicalize bool) signOrVerifyOption {
+	return &optImpl{
+		s: func(s *signer) { s.config.CanonicalContentType = canonicalize },
+		v: func(v *verifier) { v.config.CanonicalContentType = canonicalize },
+	}
+}
+
+// WithSignRand sets the source of randomness for randomised signatures, so that tests can pin
+// the bytes of rsa-pss-sha512 signatures, or to use a specific entropy source. It has no effect
+// on deterministic algorithms (rsa-v1_5-sha256, ed25519, hmac-sha256) or on keys implementing
+// ContextSigningKey. ECDSA signatures draw on it too, but crypto/ecdsa doesn't make them
+// reproducible from a fixed source: use WithSignEcdsaP256Sha256Deterministic for that.
+// default: crypto/rand.Reader
+func WithSignRand(random io.Reader) signOption {
+	return &optImpl{
+		s: func(s *signer) { s.config.Rand = random },
+	}
+}
+
+// WithSignDigestAlgorithms sets the digest algorithms used for the Content-Digest header added
+// when signing requests. Multiple algorithms are included together in the header.
+// default: sha-256
+func WithSignDigestAlgorithms(algorithms ...DigestAlgorithm) signOption {
+	return &optImpl{
+		s: func(s *signer) { s.config.DigestAlgorithms = append(s.config.DigestAlgorithms, algorithms...) },
+	}
+}
+
+// WithSignSkipDigest sets whether signing requests skips the Content-Digest header entirely.
+// `content-digest` is also removed from the signing fields, leaving the body uncovered.
+// default: false
+func WithSignSkipDigest(skip bool) signOption {
+	return &optImpl{
+		s: func(s *signer) { s.config.SkipDigest = skip },
+	}
+}
+
+// WithSignSkipDigestMethods sets the methods of requests signed without a Content-Digest header,
+// eg: GET and HEAD for servers rejecting the header on requests without content. `content-digest`
+// is left out of the signing fields for these requests, which are still signed otherwise. Use
+// WithSignDigestOnlyWhenBody instead to skip the digest of any request without a body.
+// default: [] (requests with any method are digested)
+func WithSignSkipDigestMethods(methods ...string) signOption {
+	return &optImpl{
+		s: func(s *signer) { s.config.SkipDigestMethods = methods },
+	}
+}
+
+// WithSignTrustExistingDigest sets whether a digest header already on the message, eg: as
+// received by a proxy from upstream, is kept and covered rather than recomputed. Signing fails if
+// the existing digest doesn't match the body.
+// default: false
+func WithSignTrustExistingDigest(trust bool) signOption {
+	return &optImpl{
+		s: func(s *signer) { s.config.TrustExistingDigest = trust },
+	}
+}
+
+// WithSignDigestOnlyWhenBody sets whether the Content-Digest header is only added when signing
+// requests with a body. Requests without a body are then signed without `content-digest`, even if
+// it is one of the signing fields.
+// default: false
+func WithSignDigestOnlyWhenBody(only bool) signOption {
+	return &optImpl{
+		s: func(s *signer) { s.config.DigestOnlyWhenBody = only },
+	}
+}
+
+// WithDigestHeader sets the header digests are added to when signing and checked from when
+// verifying, either Content-Digest or Repr-Digest. Cover it by including its name, for example
+// `repr-digest`, in the signing fields. Responses signed by a middleware use the algorithm most
+// preferred by the Want-Content-Digest or Want-Repr-Digest header of the request, if any.
+// An empty name or one that isn't a valid field name fails signing, verifying or digesting.
+// default: Content-Digest
+func WithDigestHeader(name string) signVerifyOrDigestOption {
+	var err error
+	if !validFieldName(name) {
+		err = fmt.Errorf("invalid digest header name %q", name)
+	}
+	name = http.CanonicalHeaderKey(name)
+	return &optImpl{
+		s: func(s *signer) { s.config.DigestHeader, s.err = name, err },
+		v: func(v *verifier) { v.config.DigestHeader, v.err = name, err },
+		d: func(d *digestor) { d.config.Header, d.err = name, err },
 	}
 }
 
-// WithDigestAlgorithms sets the digest algorithms to use for signing or signature verification.
+// WithDigestAlgorithms adds digest algorithms to use for digest creation or verification. Using
+// the option again adds to the algorithms rather than replacing them. All algorithms are included
+// together when creating a digest header. Verifying fails unless at least one of them is in the
+// header, and every one of them present must match.
 // default: sha-256
 func WithDigestAlgorithms(algorithms ...DigestAlgorithm) digestOption {
 	return &optImpl{
-		d: func(d *digestor) { d.config.Algorithms = algorithms },
+		d: func(d *digestor) { d.config.Algorithms = append(d.config.Algorithms, algorithms...) },
 	}
 }
@@ -32,9 +32,12 @@
 package httpsig
 
 import (
+	"bytes"
+	"context"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/rsa"
@@ -43,7 +46,13 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"math/big"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dunglas/httpsfv"
@@ -71,6 +80,25 @@ type SignConfig struct {
 	// Default: 'sig'
 	Name *string
 
+	// The prefix of the names of this and any additional signatures without a name of their own.
+	// They are numbered from 0 in the order of their key ids, eg: `sig0`, `sig1`, so each is
+	// named the same for every message signed. Signatures with the same key id are numbered in the
+	// order they're configured.
+	// Default: "" (each signature is named 'sig' unless named otherwise)
+	NamePrefix string
+
+	// Replace any Signature and Signature-Input headers already on the message rather than adding
+	// the signature to them. Additional signatures are still added alongside this one.
+	// Default: false (signatures from upstream are kept, eg: when a proxy signs a signed request)
+	ReplaceExistingHeaders bool
+
+	// Leave requests already signed with this signature untouched when signing them, rather than
+	// adding another signature, eg: when a request signed before being sent is retried. A request
+	// is signed with it when its Signature and Signature-Input headers have a signature with its
+	// name and key id, which isn't verified.
+	// Default: false (requests are always signed)
+	IfAbsent bool
+
 	// The parameters to add to the signature
 	// Default: see defaultParams
 	Params []Param
@@ -79,10 +107,95 @@ type SignConfig struct {
 	// Default: none
 	Fields []string
 
+	// HTTP fields signed only when the message has them, eg: Idempotency-Key. They are added to
+	// the signed fields of messages having them, and left out of the signature entirely otherwise.
+	// Default: none
+	OptionalFields []string
+
+	// Resolvers of application specific components, by lowercase name. Fields with one of these
+	// names are signed with the value it resolves for the message. Additional signatures use
+	// these resolvers too, unless they configure their own for the same name.
+	// Default: nil
+	Components map[string]func(msg *Message) (string, error)
+
+	// Sign the content-type field with its media type reformatted canonically, so that signatures
+	// survive the header being reformatted, eg: `application/json;charset=UTF-8` signed as
+	// `application/json; charset=utf-8`. Verifiers must be configured to canonicalise it too.
+	// Additional signatures canonicalise it too.
+	// Default: false
+	CanonicalContentType bool
+
+	// The source of randomness for randomised signatures (rsa-pss-sha512 and ECDSA), eg: to pin
+	// signatures in tests. Deterministic algorithms don't use it. Additional signatures use it too,
+	// unless they configure their own.
+	// Default: crypto/rand.Reader
+	Rand io.Reader
+
+	// Parameters other than those defined by RFC 9421 to add to signatures, by name. Values are
+	// strings, integers or booleans (or other structured field bare items: float64, []byte and
+	// httpsfv.Token), and are serialised after the defined parameters in order of name. Signing
+	// fails with invalid names or values.
+	// Default: nil
+	ExtraParams map[string]any
+
 	// Specified parameter values to use (eg: created time, expires time, etc)
 	// This can be used by consumers to override the default expiration time or explicitly opt-out
 	// of adding creation time (by setting `created: nil`)
 	ParamValues *SignatureParameters
+
+	// The digest algorithms to use for the Content-Digest header added when signing requests
+	// Default: sha-256
+	DigestAlgorithms []DigestAlgorithm
+
+	// The largest request body, in bytes, read in full to add a Content-Digest header. Signing
+	// requests with larger bodies fails with ErrBodyTooLarge.
+	// Default: 0 (no limit)
+	BodyBufferLimit int64
+
+	// Never add a Content-Digest header when signing requests, and leave `content-digest` out of
+	// the signed fields.
+	// Default: false
+	SkipDigest bool
+
+	// Never add a Content-Digest header when signing requests with these methods, eg: GET for
+	// servers rejecting the header on requests without content, leaving `content-digest` out of
+	// the signed fields as with SkipDigest. Methods are case sensitive.
+	// Default: [] (requests with any method are digested)
+	SkipDigestMethods []string
+
+	// The header the digest of the body is added to when signing, either Content-Digest or
+	// Repr-Digest
+	// Default: Content-Digest
+	DigestHeader string
+
+	// Keep a digest header already on the message, as received from upstream, rather than replacing
+	// it. The existing digest must match the body, otherwise signing fails.
+	// Default: false (the digest is always recomputed)
+	TrustExistingDigest bool
+
+	// Only add a Content-Digest header when signing requests with a body. For requests without
+	// a body, `content-digest` is left out of the signed fields.
+	// Default: false
+	DigestOnlyWhenBody bool
+
+	// Sign responses using the signature requested by the Accept-Signature header of the request,
+	// if any. The fields and parameters requested replace the configured ones.
+	// Default: false
+	AcceptSignature bool
+
+	// Called once for every message signed, with the outcome of signing it
+	// Default: nil
+	Observer func(ev SignEvent)
+
+	// Sign using the format of draft-cavage-http-signatures-12 rather than RFC 9421, for peers
+	// that only support the older draft. Fields are named as in the draft, eg: `(request-target)`.
+	// Default: false
+	Cavage bool
+
+	// Additional signatures to add to every message signed, each with its own key, fields and
+	// parameters. They are added after the signature configured here, in order.
+	// Default: []
+	Signatures []SignConfig
 }
 
 // The key to use for signing
@@ -92,6 +205,13 @@ type SigningKey interface {
 	GetAlgorithm() Algorithm
 }
 
+// ContextSigningKey is a signing key that makes use of the context of the message being signed,
+// eg: to cancel a call to a remote signing service. It is preferred over Sign when implemented.
+type ContextSigningKey interface {
+	SigningKey
+	SignContext(ctx context.Context, data []byte) ([]byte, error)
+}
+
 // The signature parameters to include in signing
 type SignatureParameters struct {
 	// The created time for the signature. `nil` indicates not to populate the `created` time
@@ -113,8 +233,19 @@ type SignatureParameters struct {
 
 	// A tag parameter for the signature
 	Tag *string
+
+	// Parameters of the signature other than those defined by RFC 9421, by name, as parsed from a
+	// signature. Values are structured field bare items: string, int64, bool, float64, []byte or
+	// httpsfv.Token.
+	Extra map[string]any
 }
 
+// Signer signs HTTP messages.
+//
+// A Signer is safe for concurrent use by multiple goroutines. Its configuration is fixed when it
+// is created and signing never modifies it, so a single Signer can be shared, eg: by every
+// request made through a transport returned by NewSignTransport. The values referenced by
+// SignatureParameters passed to WithSignParamValues must not be modified after construction.
 type Signer struct {
 	*signer
 }
@@ -130,22 +261,401 @@ func NewSigner(opts ...signOption) *Signer {
 	}
 
 	if len(s.config.Params) == 0 {
-		s.config.Params = defaultParams[:]
+		s.config.Params = defaultParams
+	}
+	// take copies so callers (or later options) can't change the configuration from under us
+	s.config.Params = slices.Clone(s.config.Params)
+	s.config.ExtraParams = maps.Clone(s.config.ExtraParams)
+
+	s.config.Fields = normaliseFields(s.config.Fields)
+	s.config.Signatures = slices.Clone(s.config.Signatures)
+	for i := range s.config.Signatures {
+		s.config.Signatures[i].Components = inheritComponents(s.config.Signatures[i].Components, s.config.Components)
+		if s.config.Signatures[i].Rand == nil {
+			s.config.Signatures[i].Rand = s.config.Rand
+		}
+		s.config.Signatures[i].CanonicalContentType = s.config.Signatures[i].CanonicalContentType || s.config.CanonicalContentType
+	}
+	if s.config.NamePrefix != "" {
+		nameByKeyID(&s.config)
+	}
+	if s.config.DigestHeader == "" {
+		s.config.DigestHeader = ContentDigestHeader
+	}
+	if s.config.SkipDigest {
+		s.config = withoutField(s.config, s.config.DigestHeader)
 	}
 
 	return &Signer{&s}
 }
 
-// Sign signs the given message and returns updated request headers
+// nameByKeyID names the signatures of the configuration without a name of their own with its name
+// prefix, numbered in the order of their key ids
+func nameByKeyID(config *SignConfig) {
+	configs := []*SignConfig{config}
+	for i := range config.Signatures {
+		configs = append(configs, &config.Signatures[i])
+	}
+	slices.SortStableFunc(configs, func(a, b *SignConfig) int {
+		return strings.Compare(signingKeyID(a), signingKeyID(b))
+	})
+
+	n := 0
+	for _, c := range configs {
+		if c.Name == nil {
+			name := fmt.Sprintf("%s%d", config.NamePrefix, n)
+			c.Name = &name
+			n++
+		}
+	}
+}
+
+// signingKeyID returns the key id the configuration signs with, if any
+func signingKeyID(config *SignConfig) string {
+	if config.ParamValues != nil && config.ParamValues.KeyID != nil {
+		return *config.ParamValues.KeyID
+	}
+	if config.Key != nil {
+		return config.Key.GetKeyID()
+	}
+	return ""
+}
+
+// withOptionalFields returns the fields with the optional fields the header has added, unless
+// already signed
+func withOptionalFields(fields, optional []string, header http.Header) []string {
+	for _, field := range optional {
+		if hasHeader(header, field) && !coversField(fields, field) {
+			fields = append(slices.Clip(fields), quoteString(field))
+		}
+	}
+	return fields
+}
+
+// withoutField returns the configuration without the given HTTP field in the fields of any of its
+// signatures
+func withoutField(config SignConfig, name string) SignConfig {
+	config.Fields = removeField(config.Fields, strings.ToLower(name))
+	config.Signatures = slices.Clone(config.Signatures)
+	for i := range config.Signatures {
+		config.Signatures[i] = withoutField(config.Signatures[i], name)
+	}
+	return config
+}
+
+// removeField returns the fields without any component for the given HTTP field name
+func removeField(fields []string, name string) []string {
+	return slices.DeleteFunc(slices.Clone(fields), func(f string) bool {
+		field, err := httpsfv.UnmarshalItem([]string{quoteString(f)})
+		if err != nil {
+			return false
+		}
+		n, ok := field.Value.(string)
+		return ok && strings.EqualFold(n, name)
+	})
+}
+
+// normaliseFields lowercases HTTP field names and drops duplicate components, preserving the
+// order they were first given in. Derived component names are left as provided.
+func normaliseFields(fields []string) []string {
+	output := make([]string, 0, len(fields))
+	for _, f := range fields {
+		field, err := httpsfv.UnmarshalItem([]string{quoteString(f)})
+		if err != nil {
+			// leave it for signing to report the error
+			output = append(output, f)
+			continue
+		}
+
+		if name, ok := field.Value.(string); ok && !strings.HasPrefix(name, "@") {
+			field.Value = strings.ToLower(name)
+		}
+
+		marshalled, err := httpsfv.Marshal(field)
+		if err != nil {
+			output = append(output, f)
+			continue
+		}
+
+		if !slices.Contains(output, marshalled) {
+			output = append(output, marshalled)
+		}
+	}
+
+	return output
+}
+
+// inheritComponents returns the component resolvers of an additional signature, with those of the
+// parent signer added unless the signature overrides them
+func inheritComponents(components, parent map[string]func(msg *Message) (string, error)) map[string]func(msg *Message) (string, error) {
+	if len(parent) == 0 {
+		return components
+	}
+	merged := maps.Clone(parent)
+	maps.Copy(merged, components)
+	return merged
+}
+
+// Sign signs the given message and returns updated request headers. It doesn't depend on
+// net/http, so messages built with NewMessage for other transports can be signed too.
 func (s *Signer) Sign(m *Message) (http.Header, error) {
 	return s.signer.Sign(m)
 }
 
+// SignRequest adds a Content-Digest header for the body of the given request and signs it,
+// updating the request headers. The body is read in full and replaced so it can still be sent,
+// and GetBody is replaced so retries and redirects send the same bytes.
+// Include `content-digest` in the signing fields to cover the digest with the signature. With
+// WithSignSkipDigest, the body is left untouched and the request is signed as is. A covered
+// `content-length` is signed from the length of the body, and left out for requests without one
+// unless a length of 0 is sent, as for an empty POST, PUT or PATCH. An empty body is replaced with
+// http.NoBody and digested as empty.
+//
+// The digest and signatures cover the request as it is when signed, so sign requests once any
+// changes to them have been made. Signing a request again adds further signatures.
+func (s *Signer) SignRequest(r *http.Request) error {
+	return s.signer.SignRequest(r)
+}
+
+// SignRequestContext is like SignRequest, but signs using the given context rather than the
+// context of the request.
+func (s *Signer) SignRequestContext(ctx context.Context, r *http.Request) error {
+	return s.signer.SignRequestContext(ctx, r)
+}
+
+// SignRequestFields is like SignRequest, but covers the given fields rather than those configured,
+// eg: to cover a header only sent with some requests. Signatures added with WithSignSignature
+// still cover their own fields. The fields are checked as when signing with
+// WithSignFields, so signing fails if a covered header is missing or a derived component unknown.
+func (s *Signer) SignRequestFields(r *http.Request, fields ...string) error {
+	return s.signer.SignRequestFields(r, fields...)
+}
+
+// SignAll signs each of the given requests as by SignRequest, in order. It stops at the first
+// request that fails to sign, returning an error naming its index. Requests before it are signed and
+// requests after it are left untouched.
+func (s *Signer) SignAll(reqs []*http.Request) error {
+	return s.signer.SignAll(reqs)
+}
+
+// SignRequestBody is like SignRequest, but sets the body of the request to the given body before
+// signing it, replacing any existing body. Use it when the body is only known once it has been
+// transformed, rather than replacing the body of a signed request and leaving the digest stale.
+func (s *Signer) SignRequestBody(r *http.Request, body []byte) error {
+	return s.signer.SignRequestBody(r, body)
+}
+
+// SignDetached signs the given signature base with the key of the signer, rather than creating the
+// base from a message, returning the signature. It is the counterpart of Verifier.VerifyDetached.
+func (s *Signer) SignDetached(base []byte) ([]byte, error) {
+	return s.signer.SignDetached(base)
+}
+
 type signer struct {
 	config SignConfig
+
+	// a configuration error reported on use, as the constructor returns none
+	err error
+}
+
+func (s *signer) SignDetached(base []byte) ([]byte, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.config.Key == nil {
+		return nil, errors.New("signer not configured")
+	}
+	return signWith(&Message{}, s.config.Key, s.config.Rand, base)
 }
 
-func updateHeaders(hdr http.Header, config *SignConfig, signature []byte, signatureInput *httpsfv.InnerList) (http.Header, error) {
+func (s *signer) SignRequest(r *http.Request) error {
+	return s.SignRequestContext(r.Context(), r)
+}
+
+func (s *signer) SignRequestContext(ctx context.Context, r *http.Request) error {
+	return s.signRequestWith(ctx, r, s.config)
+}
+
+func (s *signer) SignRequestFields(r *http.Request, fields ...string) error {
+	config := s.config
+	config.Fields = normaliseFields(fields)
+	if config.SkipDigest {
+		config = withoutField(config, config.DigestHeader)
+	}
+	return s.signRequestWith(r.Context(), r, config)
+}
+
+// signRequestWith reads the body of the request, unless configured not to, and signs it with the
+// configuration
+func (s *signer) signRequestWith(ctx context.Context, r *http.Request, config SignConfig) error {
+	if config.IfAbsent && signedWith(r.Header, &config) {
+		return nil
+	}
+
+	var body []byte
+	if !config.SkipDigest {
+		var err error
+		body, err = readBody(r, config.BodyBufferLimit)
+		if err != nil {
+			return err
+		}
+		if r.Body != nil && r.Body != http.NoBody {
+			// the length is known once the body is buffered, so send it rather than a chunked body
+			r.ContentLength = int64(len(body))
+			if len(body) == 0 {
+				r.Body = http.NoBody
+				r.GetBody = func() (io.ReadCloser, error) { return http.NoBody, nil }
+			}
+		}
+	}
+
+	return s.signRequest(ctx, r, config, body)
+}
+
+func (s *signer) SignAll(reqs []*http.Request) error {
+	for i, r := range reqs {
+		if err := s.SignRequest(r); err != nil {
+			return fmt.Errorf("unable to sign request %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (s *signer) SignRequestBody(r *http.Request, body []byte) error {
+	r.ContentLength = int64(len(body))
+	if len(body) == 0 {
+		r.Body = http.NoBody
+		r.GetBody = func() (io.ReadCloser, error) { return http.NoBody, nil }
+	} else {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	}
+
+	return s.signRequest(r.Context(), r, s.config, body)
+}
+
+// signedWith reports whether the headers have a signature with the name and key id of the
+// configuration
+func signedWith(hdr http.Header, config *SignConfig) bool {
+	name := "sig"
+	if config.Name != nil {
+		name = *config.Name
+	}
+	if _, ok := hdr[SignatureHeader]; !ok {
+		return false
+	}
+	signatures, err := httpsfv.UnmarshalDictionary(hdr.Values(SignatureHeader))
+	if err != nil {
+		return false
+	}
+	if _, ok := signatures.Get(name); !ok {
+		return false
+	}
+
+	inputs, err := ParseSignatureHeaders(hdr)
+	if err != nil {
+		return false
+	}
+	keyID := signingKeyID(config)
+	return slices.ContainsFunc(inputs, func(input SignatureInput) bool {
+		return input.Name == name && input.KeyID != nil && *input.KeyID == keyID
+	})
+}
+
+// signRequest signs the request with the configuration and given body, updating its headers.
+// Clients send the Content-Length header from the length of the request rather than its headers, so
+// it is set from the length to sign what is sent. Without a body, a length of 0 is only sent for
+// methods expecting a body, eg: an empty POST, so `content-length` isn't signed for other methods.
+func (s *signer) signRequest(ctx context.Context, r *http.Request, config SignConfig, body []byte) error {
+	if r.Header == nil {
+		r.Header = make(http.Header)
+	}
+	if slices.Contains(config.SkipDigestMethods, r.Method) {
+		config.SkipDigest = true
+		config = withoutField(config, config.DigestHeader)
+	}
+
+	msg := MessageFromRequest(r)
+	msg.Context = ctx
+	if _, ok := r.Header["Content-Length"]; !ok {
+		switch {
+		case r.ContentLength > 0:
+			msg.Header.Set("Content-Length", strconv.FormatInt(r.ContentLength, 10))
+		case r.ContentLength == 0 && (r.Body == nil || r.Body == http.NoBody):
+			if sendsEmptyLength(r.Method) {
+				msg.Header.Set("Content-Length", "0")
+			} else {
+				config = withoutField(config, "Content-Length")
+			}
+		}
+	}
+
+	hdr, err := s.signBody(msg, config, body)
+	if err != nil {
+		return err
+	}
+	r.Header = hdr
+
+	return nil
+}
+
+// sendsEmptyLength reports whether net/http clients send a Content-Length of 0 for requests with
+// the given method and no body
+func sendsEmptyLength(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		return true
+	}
+	return false
+}
+
+// signBody signs the message with the configuration, first adding a digest header for the given
+// body unless configured not to. Responses are digested with the algorithm the request asks for,
+// if any.
+func (s *signer) signBody(msg *Message, config SignConfig, body []byte) (http.Header, error) {
+	if s.err != nil {
+		// fail before a digest is added under a bogus name
+		return nil, s.err
+	}
+	if config.SkipDigest {
+		return s.sign(msg, config)
+	}
+
+	if hasHeader(msg.Header, config.DigestHeader) && config.TrustExistingDigest {
+		// keep the existing digest as is, but never cover one that doesn't match the body
+		err := NewDigestor(WithDigestAlgorithms(supportedDigestAlgorithms()...), WithDigestHeader(config.DigestHeader)).Verify(body, msg.Header)
+		if err != nil {
+			return nil, fmt.Errorf("existing %s header: %w", config.DigestHeader, err)
+		}
+	} else if len(body) == 0 && config.DigestOnlyWhenBody {
+		// nothing to digest, so don't sign a digest either
+		config = withoutField(config, config.DigestHeader)
+	} else {
+		algorithms := config.DigestAlgorithms
+		if !msg.IsRequest && msg.RequestHeader != nil {
+			if algorithm, ok := wantedDigestAlgorithm(msg.RequestHeader.Values("Want-" + config.DigestHeader)); ok {
+				algorithms = []DigestAlgorithm{algorithm}
+			}
+		}
+
+		digest, err := NewDigestor(WithDigestAlgorithms(algorithms...), WithDigestHeader(config.DigestHeader)).Digest(body)
+		if err != nil {
+			return nil, err
+		}
+		// replace the digest however it is cased, so the signature doesn't cover a stale one too
+		for name := range msg.Header {
+			if strings.EqualFold(name, config.DigestHeader) {
+				delete(msg.Header, name)
+			}
+		}
+		msg.Header.Set(config.DigestHeader, digest.Get(config.DigestHeader))
+	}
+
+	return s.sign(msg, config)
+}
+
+// updateHeaders adds the signature to the signature headers, returning them with the name used
+func updateHeaders(hdr http.Header, config *SignConfig, signature []byte, signatureInput *httpsfv.InnerList) (http.Header, string, error) {
 	var err error
 
 	// check to see if there are already signature/signature-input headers
@@ -153,6 +663,9 @@ func updateHeaders(hdr http.Header, config *SignConfig, signature []byte, signat
 	// and we want to parse out the current values so we can append our new signature
 	signatureHeader, signaturePresent := hdr[SignatureHeader]
 	inputHeader, inputPresent := hdr[SignatureInputHeader]
+	if config.ReplaceExistingHeaders {
+		signaturePresent, inputPresent = false, false
+	}
 
 	var signatureHeaderDict *httpsfv.Dictionary
 	var inputHeaderDict *httpsfv.Dictionary
@@ -160,7 +673,7 @@ func updateHeaders(hdr http.Header, config *SignConfig, signature []byte, signat
 	if signaturePresent {
 		signatureHeaderDict, err = httpsfv.UnmarshalDictionary(signatureHeader)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 	} else {
 		signatureHeaderDict = httpsfv.NewDictionary()
@@ -169,7 +682,7 @@ func updateHeaders(hdr http.Header, config *SignConfig, signature []byte, signat
 	if inputPresent {
 		inputHeaderDict, err = httpsfv.UnmarshalDictionary(inputHeader)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 	} else {
 		inputHeaderDict = httpsfv.NewDictionary()
@@ -178,17 +691,16 @@ func updateHeaders(hdr http.Header, config *SignConfig, signature []byte, signat
 	// find a unique signature name for the header. Check if any existing headers already use
 	// the name we intend to use, if there are, add incrementing numbers to the signature name
 	// until we have a unique name to use
-	var signatureName string
+	name := "sig"
 	if config.Name != nil {
-		signatureName = *config.Name
-	} else {
-		signatureName = "sig"
+		name = *config.Name
 	}
+	signatureName := name
 	count := 1
 	_, hasName := signatureHeaderDict.Get(signatureName)
 	_, hasInput := inputHeaderDict.Get(signatureName)
 	for hasName || hasInput {
-		signatureName = fmt.Sprintf("%s%d", signatureName, count)
+		signatureName = fmt.Sprintf("%s%d", name, count)
 		_, hasName = signatureHeaderDict.Get(signatureName)
 		_, hasInput = inputHeaderDict.Get(signatureName)
 		count++
@@ -200,54 +712,121 @@ func updateHeaders(hdr http.Header, config *SignConfig, signature []byte, signat
 
 	marshalledSignatureHeader, err := httpsfv.Marshal(signatureHeaderDict)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	marshalledInputHeader, err := httpsfv.Marshal(inputHeaderDict)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	hdr.Set(SignatureHeader, marshalledSignatureHeader)
 	hdr.Set(SignatureInputHeader, marshalledInputHeader)
 
-	return hdr, nil
+	return hdr, signatureName, nil
 }
 
 func (s *signer) Sign(msg *Message) (http.Header, error) {
-	if s.config.Key == nil {
+	return s.sign(msg, s.config)
+}
+
+func (s *signer) sign(msg *Message, config SignConfig) (http.Header, error) {
+	return s.signAll(msg, config, nil)
+}
+
+// signAll signs the message with the configuration and its additional signatures, appending the
+// signatures added to signed if it isn't nil
+func (s *signer) signAll(msg *Message, config SignConfig, signed *[]SignedSignature) (hdr http.Header, err error) {
+	var added []SignedSignature
+	if signed != nil {
+		defer func() { *signed = append(*signed, added...) }()
+	}
+	if config.Observer != nil {
+		start := time.Now()
+		defer func() { config.Observer(newSignEvent(msg, &config, added, err, time.Since(start))) }()
+	}
+
+	if s.err != nil {
+		return nil, s.err
+	}
+	if config.Key == nil {
 		return nil, errors.New("signer not configured")
 	}
 
-	signingParameters := createSigningParameters(&s.config)
-	signatureBase, err := createSignatureBase(s.config.Fields, msg)
-	if err != nil {
-		return nil, err
+	if config.AcceptSignature {
+		config, err = acceptedConfig(config, msg)
+		if err != nil {
+			return nil, err
+		}
 	}
+	config.Fields = withOptionalFields(config.Fields, config.OptionalFields, msg.Header)
 
-	input := httpsfv.InnerList{}
-	for _, field := range signatureBase {
-		input.Items = append(input.Items, field.key)
+	if config.Cavage {
+		if hdr, err = s.signCavage(msg, config); err != nil {
+			return nil, err
+		}
+		added = append(added, newSignedSignature("", &config))
+		return hdr, nil
 	}
-	input.Params = signingParameters
 
-	marshalledInput, err := httpsfv.Marshal(input)
-	if err != nil {
+	params := createSigningParameters(&config)
+	extra := config.ExtraParams
+	if config.ParamValues != nil && len(config.ParamValues.Extra) > 0 {
+		// parameters added with WithSignParam replace those of the values with the same name
+		extra = maps.Clone(config.ParamValues.Extra)
+		maps.Copy(extra, config.ExtraParams)
+	}
+	if err := addExtraParams(params, extra); err != nil {
 		return nil, err
 	}
 
-	signatureBase = append(signatureBase, signatureItem{httpsfv.NewItem("@signature-params"), []string{marshalledInput}})
+	base, input, err := signatureBase(config.Fields, params, msg, baseOptions{
+		components:           config.Components,
+		canonicalContentType: config.CanonicalContentType,
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	base, err := formatSignatureBase(signatureBase)
+	signature, err := signWith(msg, config.Key, config.Rand, []byte(base))
 	if err != nil {
 		return nil, err
 	}
 
-	signature, err := s.config.Key.Sign([]byte(base))
+	hdr, name, err := updateHeaders(msg.Header, &config, signature, input)
 	if err != nil {
 		return nil, err
 	}
+	added = append(added, newSignedSignature(name, &config))
 
-	return updateHeaders(msg.Header, &s.config, signature, &input)
+	for _, c := range config.Signatures {
+		if hdr, err = s.signAll(msg, c, &added); err != nil {
+			return nil, err
+		}
+	}
+
+	return hdr, nil
+}
+
+// signWith signs the data with the key, using the context of the message if the key supports it,
+// and the given source of randomness if set and the key's signatures are randomised
+func signWith(msg *Message, key SigningKey, random io.Reader, data []byte) ([]byte, error) {
+	if key, ok := key.(ContextSigningKey); ok {
+		ctx := msg.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		return key.SignContext(ctx, data)
+	}
+	if key, ok := key.(randSigningKey); ok && random != nil {
+		return key.signRand(random, data)
+	}
+	return key.Sign(data)
+}
+
+// randSigningKey is a signing key making randomised signatures, which can be given the source of
+// randomness to use
+type randSigningKey interface {
+	signRand(random io.Reader, data []byte) ([]byte, error)
 }
 
 type RsaPssSha512SigningKey struct {
@@ -256,6 +835,10 @@ type RsaPssSha512SigningKey struct {
 }
 
 func (k *RsaPssSha512SigningKey) Sign(data []byte) ([]byte, error) {
+	return k.signRand(rand.Reader, data)
+}
+
+func (k *RsaPssSha512SigningKey) signRand(random io.Reader, data []byte) ([]byte, error) {
 	hash := sha512.New()
 	_, err := hash.Write(data)
 	if err != nil {
@@ -263,7 +846,7 @@ func (k *RsaPssSha512SigningKey) Sign(data []byte) ([]byte, error) {
 	}
 
 	bytes := hash.Sum(nil)
-	return rsa.SignPSS(rand.Reader, k.PrivateKey, crypto.SHA512, bytes, nil)
+	return rsa.SignPSS(random, k.PrivateKey, crypto.SHA512, bytes, nil)
 }
 
 func (k *RsaPssSha512SigningKey) GetKeyID() string {
@@ -287,7 +870,7 @@ func (k *RsaPkcs1v15Sha256SigningKey) Sign(data []byte) ([]byte, error) {
 	}
 
 	bytes := hash.Sum(nil)
-	return rsa.SignPKCS1v15(rand.Reader, k.PrivateKey, crypto.SHA512, bytes)
+	return rsa.SignPKCS1v15(rand.Reader, k.PrivateKey, crypto.SHA256, bytes)
 }
 
 func (k *RsaPkcs1v15Sha256SigningKey) GetKeyID() string {
@@ -304,6 +887,10 @@ type EcdsaP256SigningKey struct {
 }
 
 func (k *EcdsaP256SigningKey) Sign(data []byte) ([]byte, error) {
+	return k.signRand(rand.Reader, data)
+}
+
+func (k *EcdsaP256SigningKey) signRand(random io.Reader, data []byte) ([]byte, error) {
 	hash := sha256.New()
 	_, err := hash.Write(data)
 	if err != nil {
@@ -311,10 +898,11 @@ func (k *EcdsaP256SigningKey) Sign(data []byte) ([]byte, error) {
 	}
 
 	bytes := hash.Sum(nil)
-	r, s, err := ecdsa.Sign(rand.Reader, k.PrivateKey, bytes)
+	r, s, err := ecdsa.Sign(random, k.PrivateKey, bytes)
 	if err != nil {
 		return nil, err
 	}
+	s = lowS(k.Curve, s)
 
 	rBytes := make([]byte, 32)
 	sBytes := make([]byte, 32)
@@ -338,6 +926,10 @@ type EcdsaP384SigningKey struct {
 }
 
 func (k *EcdsaP384SigningKey) Sign(data []byte) ([]byte, error) {
+	return k.signRand(rand.Reader, data)
+}
+
+func (k *EcdsaP384SigningKey) signRand(random io.Reader, data []byte) ([]byte, error) {
 	hash := sha512.New384()
 	_, err := hash.Write(data)
 	if err != nil {
@@ -345,10 +937,11 @@ func (k *EcdsaP384SigningKey) Sign(data []byte) ([]byte, error) {
 	}
 
 	bytes := hash.Sum(nil)
-	r, s, err := ecdsa.Sign(rand.Reader, k.PrivateKey, bytes)
+	r, s, err := ecdsa.Sign(random, k.PrivateKey, bytes)
 	if err != nil {
 		return nil, err
 	}
+	s = lowS(k.Curve, s)
 
 	rBytes := make([]byte, 48)
 	sBytes := make([]byte, 48)
@@ -366,6 +959,58 @@ func (k *EcdsaP384SigningKey) GetAlgorithm() Algorithm {
 	return AlgorithmEcdsaP384Sha384
 }
 
+// DeterministicEcdsaP256SigningKey signs using `ecdsa-p256-sha256` like EcdsaP256SigningKey, but
+// derives the nonce of each signature from the key and signature base as described by RFC 6979,
+// rather than randomly, so that signing the same base always makes the same signature, eg: to
+// reproduce a signature for an audit. Keys that aren't on the P-256 curve fail to sign, as does
+// every key when built with a Go version before 1.24.
+type DeterministicEcdsaP256SigningKey struct {
+	*ecdsa.PrivateKey
+	KeyID string
+}
+
+func (k *DeterministicEcdsaP256SigningKey) Sign(data []byte) ([]byte, error) {
+	return signDeterministic(k.PrivateKey, elliptic.P256(), crypto.SHA256, data)
+}
+
+func (k *DeterministicEcdsaP256SigningKey) GetKeyID() string {
+	return k.KeyID
+}
+
+func (k *DeterministicEcdsaP256SigningKey) GetAlgorithm() Algorithm {
+	return AlgorithmEcdsaP256Sha256
+}
+
+// DeterministicEcdsaP384SigningKey signs using `ecdsa-p384-sha384` like EcdsaP384SigningKey, but
+// derives the nonce of each signature as described by RFC 6979, as DeterministicEcdsaP256SigningKey.
+// Keys that aren't on the P-384 curve fail to sign.
+type DeterministicEcdsaP384SigningKey struct {
+	*ecdsa.PrivateKey
+	KeyID string
+}
+
+func (k *DeterministicEcdsaP384SigningKey) Sign(data []byte) ([]byte, error) {
+	return signDeterministic(k.PrivateKey, elliptic.P384(), crypto.SHA384, data)
+}
+
+func (k *DeterministicEcdsaP384SigningKey) GetKeyID() string {
+	return k.KeyID
+}
+
+func (k *DeterministicEcdsaP384SigningKey) GetAlgorithm() Algorithm {
+	return AlgorithmEcdsaP384Sha384
+}
+
+// lowS returns the low form of the s value of an ECDSA signature, so that signatures aren't
+// malleable: s and n-s are both valid for a signature
+func lowS(curve elliptic.Curve, s *big.Int) *big.Int {
+	n := curve.Params().N
+	if s.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		return new(big.Int).Sub(n, s)
+	}
+	return s
+}
+
 type Ed25519SigningKey struct {
 	ed25519.PrivateKey
 	KeyID string
@@ -383,6 +1028,26 @@ func (k *Ed25519SigningKey) GetAlgorithm() Algorithm {
 	return AlgorithmEd25519
 }
 
+// Ed25519phSigningKey signs using the non-standard `ed25519ph` algorithm, signing the SHA-512 hash
+// of the signature base rather than the base itself
+type Ed25519phSigningKey struct {
+	ed25519.PrivateKey
+	KeyID string
+}
+
+func (k *Ed25519phSigningKey) Sign(data []byte) ([]byte, error) {
+	digest := sha512.Sum512(data)
+	return k.PrivateKey.Sign(nil, digest[:], &ed25519.Options{Hash: crypto.SHA512})
+}
+
+func (k *Ed25519phSigningKey) GetKeyID() string {
+	return k.KeyID
+}
+
+func (k *Ed25519phSigningKey) GetAlgorithm() Algorithm {
+	return AlgorithmEd25519ph
+}
+
 type HmacSha256SigningKey struct {
 	Secret []byte
 	KeyID  string
@@ -0,0 +1,897 @@
+// BSD 3-Clause License
+
+// Copyright (c) 2021, James Bowes
+// Copyright (c) 2023, Alexander Taraymovich, OffBlocks
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package httpsig
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormaliseFields(t *testing.T) {
+	t.Run("lowercases header names", func(t *testing.T) {
+		assert.Equal(t, []string{`"content-type"`, `"x-custom"`}, normaliseFields([]string{"Content-Type", `"X-Custom"`}))
+	})
+	t.Run("leaves derived components intact", func(t *testing.T) {
+		assert.Equal(t, []string{`"@method"`, `"@query-param";name="Pet"`}, normaliseFields([]string{"@method", `"@query-param";name="Pet"`}))
+	})
+	t.Run("keeps component parameters", func(t *testing.T) {
+		assert.Equal(t, []string{`"example-dict";key="a"`, `"cache-control";sf`}, normaliseFields([]string{`"Example-Dict";key="a"`, "Cache-Control;sf"}))
+	})
+	t.Run("drops duplicates preserving order", func(t *testing.T) {
+		assert.Equal(t, []string{`"date"`, `"content-type"`, `"@path"`}, normaliseFields([]string{"date", "Content-Type", "@path", "content-type", "Date", "@path"}))
+	})
+	t.Run("does not treat different parameters as duplicates", func(t *testing.T) {
+		assert.Equal(t, []string{`"example-dict"`, `"example-dict";sf`}, normaliseFields([]string{"example-dict", "example-dict;sf"}))
+	})
+}
+
+func TestSign_PreservesFieldOrder(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	s := NewSigner(
+		WithHmacSha256("test-shared-secret", k),
+		WithSignParams(ParamKeyID),
+		WithSignFields("content-type", "@query", "content-digest", "@authority", "@path", "@method"),
+	)
+
+	req, err := http.NewRequest("POST", "https://example.com/foo?bar=baz", bytes.NewBufferString("{}"))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	assert.NoError(t, s.SignRequest(req))
+
+	assert.Equal(t, `sig=("content-type" "@query" "content-digest" "@authority" "@path" "@method");keyid="test-shared-secret"`, req.Header.Get(SignatureInputHeader))
+}
+
+func TestSign_NormalisesFields(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	created := time.Unix(1618884473, 0)
+	s := NewSigner(
+		WithSignParams(ParamCreated, ParamKeyID),
+		WithSignParamValues(&SignatureParameters{
+			Created: &created,
+		}),
+		WithHmacSha256("test-shared-secret", k),
+		WithSignFields("Date", "@authority", "Content-Type", "content-type"),
+	)
+
+	hdr, err := s.Sign(MessageFromRequest(testReq()))
+	assert.NoError(t, err, "signing failed")
+
+	// matches the B.2.5 example, which was signed over lowercase names
+	assert.Equal(t, `sig=("date" "@authority" "content-type");created=1618884473;keyid="test-shared-secret"`, hdr.Get("Signature-Input"))
+	assert.Equal(t, `sig=:pxcQw6G3AjtMBQjwo8XzkZf/bws5LelbaMk5rGIGtE8=:`, hdr.Get("Signature"))
+}
+
+func TestSign_Concurrent(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	fields := []string{"@method", "@path", "x-request-id"}
+	s := NewSigner(
+		WithHmacSha256("test-shared-secret", k),
+		WithSignFields(fields...),
+		WithSignParams(ParamCreated, ParamKeyID, ParamNonce),
+	)
+	v := NewVerifier(
+		WithHmacSha256("test-shared-secret", k),
+	)
+
+	// changing the slices passed as options must not affect the signer
+	fields[2] = "x-other"
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			req := testReq()
+			req.URL.Path = fmt.Sprintf("/foo/%d", i)
+			req.Header.Set("X-Request-Id", fmt.Sprint(i))
+			hdr, err := s.Sign(MessageFromRequest(req))
+			if err != nil {
+				errs <- err
+				return
+			}
+			req.Header = hdr
+
+			errs <- v.Verify(MessageFromRequest(req))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+}
+
+func TestSign_Rand(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	// a fresh reader of the same bytes for every signature
+	pinned := func() io.Reader { return bytes.NewReader(make([]byte, 1024)) }
+	sign := func(t *testing.T, opts ...signOption) string {
+		s := NewSigner(append([]signOption{WithSignFields("@method", "@authority"), WithSignParams(ParamKeyID, ParamAlg)}, opts...)...)
+		hdr, err := s.Sign(MessageFromRequest(testReq()))
+		assert.NoError(t, err)
+		return hdr.Get(SignatureHeader)
+	}
+
+	t.Run("pins rsa-pss-sha512 signatures", func(t *testing.T) {
+		first := sign(t, WithSignRsaPssSha512("test-key", rsaKey), WithSignRand(pinned()))
+		assert.Equal(t, first, sign(t, WithSignRsaPssSha512("test-key", rsaKey), WithSignRand(pinned())))
+		assert.NotEqual(t, first, sign(t, WithSignRsaPssSha512("test-key", rsaKey)))
+
+		msg := MessageFromRequest(testReq())
+		msg.Header.Set(SignatureHeader, first)
+		msg.Header.Set(SignatureInputHeader, `sig=("@method" "@authority");keyid="test-key";alg="rsa-pss-sha512"`)
+		assert.NoError(t, NewVerifier(WithVerifyRsaPssSha512("test-key", &rsaKey.PublicKey)).Verify(msg))
+	})
+	t.Run("signs ecdsa with the source", func(t *testing.T) {
+		sig := sign(t, WithSignEcdsaP256Sha256("test-key", ecKey), WithSignRand(pinned()))
+
+		msg := MessageFromRequest(testReq())
+		msg.Header.Set(SignatureHeader, sig)
+		msg.Header.Set(SignatureInputHeader, `sig=("@method" "@authority");keyid="test-key";alg="ecdsa-p256-sha256"`)
+		assert.NoError(t, NewVerifier(WithVerifyEcdsaP256Sha256("test-key", &ecKey.PublicKey)).Verify(msg))
+	})
+	t.Run("doesn't affect deterministic algorithms", func(t *testing.T) {
+		k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+		assert.NoError(t, err)
+
+		assert.Equal(t, sign(t, WithHmacSha256("test-key", k)), sign(t, WithHmacSha256("test-key", k), WithSignRand(pinned())))
+	})
+	t.Run("is used by additional signatures", func(t *testing.T) {
+		signer := func() signOption {
+			return WithSignSignature(WithSignRsaPssSha512("test-key", rsaKey), WithSignName("sig2"), WithSignFields("@method"))
+		}
+		k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+		assert.NoError(t, err)
+
+		first := sign(t, WithHmacSha256("test-key", k), signer(), WithSignRand(pinned()))
+		assert.Equal(t, first, sign(t, WithHmacSha256("test-key", k), signer(), WithSignRand(pinned())))
+	})
+}
+
+func TestSign_ExtraParams(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	assert.NoError(t, err)
+
+	t.Run("adds the parameters after the defined ones", func(t *testing.T) {
+		s := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithSignFields("@method"),
+			WithSignParams(ParamKeyID, ParamAlg),
+			WithSignParam("zone", "eu-1"),
+			WithSignParam("attempt", int64(3)),
+			WithSignParam("zone", "eu-2"),
+		)
+		hdr, err := s.Sign(MessageFromRequest(testReq()))
+		assert.NoError(t, err)
+		assert.Equal(t, `sig=("@method");keyid="test-shared-secret";alg="hmac-sha256";attempt=3;zone="eu-2"`, hdr.Get(SignatureInputHeader))
+	})
+	t.Run("adds the extra parameters of the values", func(t *testing.T) {
+		s := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithSignFields("@method"),
+			WithSignParams(ParamKeyID),
+			WithSignParamValues(&SignatureParameters{Extra: map[string]any{"zone": "eu-1", "attempt": 3}}),
+			WithSignParam("zone", "eu-2"),
+		)
+		hdr, err := s.Sign(MessageFromRequest(testReq()))
+		assert.NoError(t, err)
+		assert.Equal(t, `sig=("@method");keyid="test-shared-secret";attempt=3;zone="eu-2"`, hdr.Get(SignatureInputHeader))
+	})
+	t.Run("error on invalid parameters", func(t *testing.T) {
+		for _, tt := range []struct {
+			name  string
+			value any
+		}{
+			{"Upper", "value"},
+			{"has space", "value"},
+			{"keyid", "other"},
+			{"created", 1618884473},
+			{"text", "caf\u00e9"},
+			{"large", int64(1e15)},
+			{"float", float32(1.5)},
+			{"time", time.Now()},
+		} {
+			s := NewSigner(
+				WithHmacSha256("test-shared-secret", k),
+				WithSignFields("@method"),
+				WithSignParam(tt.name, tt.value),
+			)
+			_, err := s.Sign(MessageFromRequest(testReq()))
+			assert.ErrorContains(t, err, "invalid signature parameter", tt.name)
+		}
+	})
+}
+
+func TestSignRequest(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	body := "{\"hello\": \"world\"}\n"
+
+	t.Run("adds a digest of the body", func(t *testing.T) {
+		s := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithSignFields("@method", "content-digest"),
+		)
+
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+
+		assert.NoError(t, s.SignRequest(req))
+		assert.Equal(t, `sha-256=:RK/0qy18MlBSVnWgjwz6lZEWjP/lF5HF9bvEF8FabDg=:`, req.Header.Get(ContentDigestHeader))
+		assert.Contains(t, req.Header.Get(SignatureInputHeader), `sig=("@method" "content-digest")`)
+		assert.NotEmpty(t, req.Header.Get(SignatureHeader))
+
+		// the body can still be sent
+		read, err := io.ReadAll(req.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, body, string(read))
+	})
+	t.Run("replaces GetBody for a stream", func(t *testing.T) {
+		s := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithSignFields("@method", "content-digest"),
+		)
+
+		// a reader that can only be read once, so NewRequest doesn't set GetBody
+		req, err := http.NewRequest("POST", "https://example.com/foo", io.NopCloser(bytes.NewBufferString(body)))
+		assert.NoError(t, err)
+		assert.Nil(t, req.GetBody)
+
+		assert.NoError(t, s.SignRequest(req))
+		assert.NotNil(t, req.GetBody)
+
+		// retries and redirects send the signed bytes, however often they're asked for
+		for i := 0; i < 2; i++ {
+			rc, err := req.GetBody()
+			assert.NoError(t, err)
+			read, err := io.ReadAll(rc)
+			assert.NoError(t, err)
+			assert.Equal(t, body, string(read))
+			assert.NoError(t, VerifyContentDigest(read, req.Header.Get(ContentDigestHeader)))
+		}
+	})
+	t.Run("includes every digest algorithm", func(t *testing.T) {
+		s := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithSignFields("content-digest"),
+			WithSignDigestAlgorithms(DigestAlgorithmSha256, DigestAlgorithmSha512),
+		)
+
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+
+		assert.NoError(t, s.SignRequest(req))
+		assert.Equal(t, `sha-256=:RK/0qy18MlBSVnWgjwz6lZEWjP/lF5HF9bvEF8FabDg=:, sha-512=:YMAam51Jz/jOATT6/zvHrLVgOYTGFy1d6GJiOHTohq4yP+pgk4vf2aCsyRZOtw8MjkM7iw7yZ/WkppmM44T3qg==:`, req.Header.Get(ContentDigestHeader))
+	})
+	t.Run("adds a representation digest", func(t *testing.T) {
+		s := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithSignFields("@method", "repr-digest"),
+			WithDigestHeader(ReprDigestHeader),
+		)
+
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+
+		assert.NoError(t, s.SignRequest(req))
+		assert.Equal(t, `sha-256=:RK/0qy18MlBSVnWgjwz6lZEWjP/lF5HF9bvEF8FabDg=:`, req.Header.Get(ReprDigestHeader))
+		assert.Empty(t, req.Header.Get(ContentDigestHeader))
+		assert.Contains(t, req.Header.Get(SignatureInputHeader), `sig=("@method" "repr-digest")`)
+
+		v := NewVerifier(WithHmacSha256("test-shared-secret", k), WithDigestHeader(ReprDigestHeader))
+		assert.NoError(t, v.VerifyRequest(req))
+
+		req.Body = io.NopCloser(bytes.NewBufferString("{}"))
+		assert.ErrorIs(t, v.VerifyRequest(req), ErrDigestMismatch)
+	})
+	t.Run("keeps a trusted existing digest", func(t *testing.T) {
+		sha512 := `sha-512=:YMAam51Jz/jOATT6/zvHrLVgOYTGFy1d6GJiOHTohq4yP+pgk4vf2aCsyRZOtw8MjkM7iw7yZ/WkppmM44T3qg==:`
+		newReq := func(t *testing.T, digest string) *http.Request {
+			req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString(body))
+			assert.NoError(t, err)
+			req.Header.Set(ContentDigestHeader, digest)
+			return req
+		}
+
+		trusting := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithSignFields("@method", "content-digest"),
+			WithSignTrustExistingDigest(true),
+		)
+		req := newReq(t, sha512)
+		assert.NoError(t, trusting.SignRequest(req))
+		assert.Equal(t, sha512, req.Header.Get(ContentDigestHeader))
+		assert.NoError(t, NewVerifier(WithHmacSha256("test-shared-secret", k)).VerifyRequest(req))
+
+		// an existing digest that doesn't match the body is never covered
+		req = newReq(t, `sha-256=:aGVsbG8=:`)
+		assert.ErrorIs(t, trusting.SignRequest(req), ErrDigestMismatch)
+		assert.Empty(t, req.Header.Get(SignatureHeader))
+
+		// by default the digest is recomputed
+		recomputing := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithSignFields("@method", "content-digest"),
+		)
+		req = newReq(t, sha512)
+		assert.NoError(t, recomputing.SignRequest(req))
+		assert.Equal(t, `sha-256=:RK/0qy18MlBSVnWgjwz6lZEWjP/lF5HF9bvEF8FabDg=:`, req.Header.Get(ContentDigestHeader))
+	})
+	t.Run("skips the digest entirely", func(t *testing.T) {
+		s := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithSignFields("@method", "content-digest"),
+			WithSignSkipDigest(true),
+		)
+
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+
+		assert.NoError(t, s.SignRequest(req))
+		assert.Empty(t, req.Header.Values(ContentDigestHeader))
+		assert.Contains(t, req.Header.Get(SignatureInputHeader), `sig=("@method")`)
+
+		read, err := io.ReadAll(req.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, body, string(read))
+	})
+	t.Run("limits the body buffered", func(t *testing.T) {
+		s := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithSignFields("@method", "content-digest"),
+			WithBodyBufferLimit(int64(len(body))),
+		)
+
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		assert.NoError(t, s.SignRequest(req))
+
+		req, err = http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString(body+"!"))
+		assert.NoError(t, err)
+		assert.ErrorIs(t, s.SignRequest(req), ErrBodyTooLarge)
+		assert.Empty(t, req.Header.Values(SignatureHeader))
+
+		// without a known length
+		req, err = http.NewRequest("POST", "https://example.com/foo", io.NopCloser(bytes.NewBufferString(body+"!")))
+		assert.NoError(t, err)
+		assert.ErrorIs(t, s.SignRequest(req), ErrBodyTooLarge)
+	})
+	t.Run("digests an empty body by default", func(t *testing.T) {
+		s := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithSignFields("@method", "content-digest"),
+		)
+
+		req, err := http.NewRequest("GET", "https://example.com/foo", nil)
+		assert.NoError(t, err)
+
+		assert.NoError(t, s.SignRequest(req))
+		assert.Equal(t, `sha-256=:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=:`, req.Header.Get(ContentDigestHeader))
+		assert.Contains(t, req.Header.Get(SignatureInputHeader), `sig=("@method" "content-digest")`)
+	})
+	t.Run("skips the digest without a body", func(t *testing.T) {
+		s := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithSignFields("@method", "content-digest"),
+			WithSignDigestOnlyWhenBody(true),
+		)
+
+		for _, b := range []io.Reader{nil, bytes.NewBufferString("")} {
+			req, err := http.NewRequest("GET", "https://example.com/foo", b)
+			assert.NoError(t, err)
+
+			assert.NoError(t, s.SignRequest(req))
+			assert.Empty(t, req.Header.Values(ContentDigestHeader))
+			assert.Contains(t, req.Header.Get(SignatureInputHeader), `sig=("@method")`)
+		}
+
+		// requests with a body are still digested
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+
+		assert.NoError(t, s.SignRequest(req))
+		assert.Equal(t, `sha-256=:RK/0qy18MlBSVnWgjwz6lZEWjP/lF5HF9bvEF8FabDg=:`, req.Header.Get(ContentDigestHeader))
+		assert.Contains(t, req.Header.Get(SignatureInputHeader), `sig=("@method" "content-digest")`)
+	})
+}
+
+func TestSignRequestBody(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	s := NewSigner(
+		WithHmacSha256("test-shared-secret", k),
+		WithSignFields("@method", "content-digest"),
+	)
+	v := NewVerifier(WithHmacSha256("test-shared-secret", k))
+
+	t.Run("signs the given body", func(t *testing.T) {
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString("{}"))
+		assert.NoError(t, err)
+
+		body := "{\"hello\": \"world\"}\n"
+		assert.NoError(t, s.SignRequestBody(req, []byte(body)))
+		assert.Equal(t, `sha-256=:RK/0qy18MlBSVnWgjwz6lZEWjP/lF5HF9bvEF8FabDg=:`, req.Header.Get(ContentDigestHeader))
+		assert.Equal(t, int64(len(body)), req.ContentLength)
+
+		read, err := io.ReadAll(req.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, body, string(read))
+
+		// the body can be sent again, eg: to follow a redirect
+		again, err := req.GetBody()
+		assert.NoError(t, err)
+		read, err = io.ReadAll(again)
+		assert.NoError(t, err)
+		assert.Equal(t, body, string(read))
+
+		req.Body = io.NopCloser(bytes.NewBufferString(body))
+		assert.NoError(t, v.VerifyRequest(req))
+	})
+	t.Run("signs an empty body", func(t *testing.T) {
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString("{}"))
+		assert.NoError(t, err)
+
+		assert.NoError(t, s.SignRequestBody(req, nil))
+		assert.Equal(t, http.NoBody, req.Body)
+		assert.NoError(t, v.VerifyRequest(req))
+	})
+}
+
+func TestSignRequestFields(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	s := NewSigner(
+		WithHmacSha256("test-shared-secret", k),
+		WithSignFields("@method", "content-digest"),
+	)
+	v := NewVerifier(WithHmacSha256("test-shared-secret", k))
+	body := "{\"hello\": \"world\"}\n"
+
+	t.Run("covers the given fields", func(t *testing.T) {
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		req.Header.Set("Idempotency-Key", "8e03978e-40d5-43e8-bc93-6894a57f9324")
+
+		assert.NoError(t, s.SignRequestFields(req, "@method", "@path", "Idempotency-Key", "content-digest"))
+		assert.Contains(t, req.Header.Get(SignatureInputHeader), `sig=("@method" "@path" "idempotency-key" "content-digest")`)
+		assert.Equal(t, `sha-256=:RK/0qy18MlBSVnWgjwz6lZEWjP/lF5HF9bvEF8FabDg=:`, req.Header.Get(ContentDigestHeader))
+		assert.NoError(t, v.VerifyRequest(req))
+
+		// the configured fields are unchanged
+		req, err = http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		assert.NoError(t, s.SignRequest(req))
+		assert.Contains(t, req.Header.Get(SignatureInputHeader), `sig=("@method" "content-digest")`)
+	})
+	t.Run("leaves out the digest when skipped", func(t *testing.T) {
+		s := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignSkipDigest(true))
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+
+		assert.NoError(t, s.SignRequestFields(req, "@method", "content-digest"))
+		assert.Contains(t, req.Header.Get(SignatureInputHeader), `sig=("@method")`)
+		assert.Empty(t, req.Header.Get(ContentDigestHeader))
+	})
+	t.Run("rejects invalid fields", func(t *testing.T) {
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		assert.Error(t, s.SignRequestFields(req, "@method", "idempotency-key"))
+		assert.Empty(t, req.Header.Get(SignatureHeader))
+
+		req, err = http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		assert.Error(t, s.SignRequestFields(req, "@unknown"))
+		assert.Empty(t, req.Header.Get(SignatureHeader))
+	})
+}
+
+func TestSignAll(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	s := NewSigner(
+		WithHmacSha256("test-shared-secret", k),
+		WithSignFields("@method", "@path", "content-digest"),
+		WithBodyBufferLimit(16),
+	)
+	v := NewVerifier(WithHmacSha256("test-shared-secret", k), WithVerifyAll(true))
+
+	batch := func(t *testing.T, bodies ...string) []*http.Request {
+		var reqs []*http.Request
+		for i, body := range bodies {
+			req, err := http.NewRequest("POST", fmt.Sprintf("https://example.com/items/%d", i), bytes.NewBufferString(body))
+			assert.NoError(t, err)
+			reqs = append(reqs, req)
+		}
+		return reqs
+	}
+
+	t.Run("signs every request", func(t *testing.T) {
+		reqs := batch(t, "{}", "[]", `{"a": 1}`)
+		assert.NoError(t, s.SignAll(reqs))
+
+		// each request is signed independently of the others
+		for _, req := range reqs {
+			inputs, err := ParseSignatureHeaders(req.Header)
+			assert.NoError(t, err)
+			assert.Len(t, inputs, 1)
+			assert.NoError(t, v.VerifyRequest(req))
+		}
+		assert.NotEqual(t, reqs[0].Header.Get(SignatureHeader), reqs[1].Header.Get(SignatureHeader))
+	})
+	t.Run("stops at the first failure", func(t *testing.T) {
+		reqs := batch(t, "{}", "this body is too large to sign", "[]")
+		err := s.SignAll(reqs)
+		assert.ErrorIs(t, err, ErrBodyTooLarge)
+		assert.EqualError(t, err, "unable to sign request 1: body too large")
+
+		assert.NotEmpty(t, reqs[0].Header.Get(SignatureHeader))
+		assert.Empty(t, reqs[2].Header.Get(SignatureHeader))
+	})
+}
+
+func TestSign_MultipleSignatures(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+
+	s := NewSigner(
+		WithHmacSha256("test-shared-secret", k),
+		WithSignName("client"),
+		WithSignFields("@method", "@authority", "content-digest"),
+		WithSignParams(ParamKeyID),
+		WithSignSignature(
+			WithSignEd25519("legacy-key", priv),
+			WithSignName("legacy"),
+			WithSignFields("@method", "@authority"),
+			WithSignParams(ParamKeyID),
+		),
+	)
+
+	req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString("{}"))
+	assert.NoError(t, err)
+	assert.NoError(t, s.SignRequest(req))
+
+	assert.Equal(t, `client=("@method" "@authority" "content-digest");keyid="test-shared-secret", legacy=("@method" "@authority");keyid="legacy-key"`, req.Header.Get(SignatureInputHeader))
+
+	v := NewVerifier(
+		WithHmacSha256("test-shared-secret", k),
+		WithVerifyEd25519("legacy-key", pub),
+		WithVerifyAll(true),
+	)
+	assert.NoError(t, v.VerifyRequest(req))
+
+	t.Run("numbers the names in the order of the key ids", func(t *testing.T) {
+		s := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithSignNamePrefix("sig"),
+			WithSignFields("@method"),
+			WithSignParams(ParamKeyID),
+			WithSignSignature(WithSignEd25519("legacy-key", priv), WithSignFields("@method"), WithSignParams(ParamKeyID)),
+			WithSignSignature(WithHmacSha256("other-key", k), WithSignName("other"), WithSignFields("@method"), WithSignParams(ParamKeyID)),
+			WithSignSignature(WithHmacSha256("backup-key", k), WithSignFields("@method"), WithSignParams(ParamKeyID)),
+		)
+
+		for i := 0; i < 2; i++ {
+			hdr, err := s.Sign(MessageFromRequest(testReq()))
+			assert.NoError(t, err)
+			assert.Equal(t, `sig2=("@method");keyid="test-shared-secret", sig1=("@method");keyid="legacy-key", other=("@method");keyid="other-key", sig0=("@method");keyid="backup-key"`, hdr.Get(SignatureInputHeader))
+		}
+	})
+	t.Run("numbers names already in use", func(t *testing.T) {
+		s := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields("@method"))
+		msg := MessageFromRequest(testReq())
+		for i := 0; i < 3; i++ {
+			hdr, err := s.Sign(msg)
+			assert.NoError(t, err)
+			msg.Header = hdr
+		}
+
+		inputs, err := ParseSignatureHeaders(msg.Header)
+		assert.NoError(t, err)
+		var names []string
+		for _, input := range inputs {
+			names = append(names, input.Name)
+		}
+		assert.Equal(t, []string{"sig", "sig1", "sig2"}, names)
+	})
+	t.Run("signs identically every time", func(t *testing.T) {
+		// with deterministic algorithms and no created time, signing the same message again
+		// gives the same headers, byte for byte: signatures are added in the order configured and
+		// named in the order of their key ids
+		newSigner := func() *Signer {
+			return NewSigner(
+				WithHmacSha256("test-shared-secret", k),
+				WithSignNamePrefix("sig"),
+				WithSignFields("@method", "@authority"),
+				WithSignParams(ParamKeyID, ParamAlg),
+				WithSignSignature(WithSignEd25519("legacy-key", priv), WithSignFields("@method"), WithSignParams(ParamKeyID, ParamAlg)),
+				WithSignSignature(WithHmacSha256("backup-key", k), WithSignFields("@path"), WithSignParams(ParamKeyID, ParamAlg)),
+			)
+		}
+
+		var want http.Header
+		for _, s := range []*Signer{newSigner(), newSigner(), newSigner()} {
+			for i := 0; i < 2; i++ {
+				hdr, err := s.Sign(MessageFromRequest(testReq()))
+				assert.NoError(t, err)
+				if want == nil {
+					want = hdr
+				}
+				assert.Equal(t, want.Values(SignatureInputHeader), hdr.Values(SignatureInputHeader))
+				assert.Equal(t, want.Values(SignatureHeader), hdr.Values(SignatureHeader))
+			}
+		}
+		assert.Equal(t, `sig2=("@method" "@authority");keyid="test-shared-secret";alg="hmac-sha256", sig1=("@method");keyid="legacy-key";alg="ed25519", sig0=("@path");keyid="backup-key";alg="hmac-sha256"`, want.Get(SignatureInputHeader))
+	})
+	t.Run("shares component resolvers with every signature", func(t *testing.T) {
+		tenant := func(msg *Message) (string, error) { return "acme", nil }
+		s := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithComponentResolver("@tenant", tenant),
+			WithSignFields("@tenant"),
+			WithSignSignature(
+				WithSignEd25519("legacy-key", priv),
+				WithSignName("legacy"),
+				WithSignFields("@method", "@tenant"),
+			),
+		)
+		msg := MessageFromRequest(testReq())
+		hdr, err := s.Sign(msg)
+		assert.NoError(t, err)
+		msg.Header = hdr
+
+		v := NewVerifier(
+			WithHmacSha256("test-shared-secret", k),
+			WithVerifyEd25519("legacy-key", pub),
+			WithComponentResolver("@tenant", tenant),
+			WithVerifyAll(true),
+		)
+		assert.NoError(t, v.Verify(msg))
+	})
+	t.Run("drops a skipped digest from every signature", func(t *testing.T) {
+		s := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithSignFields("@method", "content-digest"),
+			WithSignParams(ParamKeyID),
+			WithSignSkipDigest(true),
+			WithSignSignature(
+				WithSignEd25519("legacy-key", priv),
+				WithSignName("legacy"),
+				WithSignFields("content-digest", "@method"),
+				WithSignParams(ParamKeyID),
+			),
+		)
+
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString("{}"))
+		assert.NoError(t, err)
+		assert.NoError(t, s.SignRequest(req))
+		assert.Equal(t, `sig=("@method");keyid="test-shared-secret", legacy=("@method");keyid="legacy-key"`, req.Header.Get(SignatureInputHeader))
+	})
+}
+
+func TestSign_ExistingSignatures(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+
+	client := NewSigner(
+		WithHmacSha256("test-shared-secret", k),
+		WithSignName("client"),
+		WithSignFields("@method", "@authority", "content-digest"),
+		WithSignParams(ParamKeyID),
+	)
+	signed := func(t *testing.T) *http.Request {
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString("{}"))
+		assert.NoError(t, err)
+		assert.NoError(t, client.SignRequest(req))
+		return req
+	}
+	proxy := func(opts ...signOption) *Signer {
+		return NewSigner(append([]signOption{
+			WithSignEd25519("proxy-key", priv),
+			WithSignName("proxy"),
+			WithSignFields("@method", "@authority", `"signature";key="client"`),
+			WithSignParams(ParamKeyID),
+		}, opts...)...)
+	}
+	v := NewVerifier(
+		WithHmacSha256("test-shared-secret", k),
+		WithVerifyEd25519("proxy-key", pub),
+		WithVerifyAll(true),
+	)
+
+	t.Run("keeps existing signatures", func(t *testing.T) {
+		req := signed(t)
+		assert.NoError(t, proxy().SignRequest(req))
+
+		assert.Equal(t, `client=("@method" "@authority" "content-digest");keyid="test-shared-secret", proxy=("@method" "@authority" "signature";key="client");keyid="proxy-key"`, req.Header.Get(SignatureInputHeader))
+		assert.NoError(t, v.VerifyRequest(req))
+	})
+	t.Run("replaces existing signatures", func(t *testing.T) {
+		req := signed(t)
+		assert.NoError(t, proxy(WithSignReplaceExistingHeaders(true), WithSignFields("@method", "@authority")).SignRequest(req))
+
+		assert.Equal(t, `proxy=("@method" "@authority");keyid="proxy-key"`, req.Header.Get(SignatureInputHeader))
+		assert.NotContains(t, req.Header.Get(SignatureHeader), "client=")
+		assert.NoError(t, v.VerifyRequest(req))
+	})
+	t.Run("keeps additional signatures when replacing", func(t *testing.T) {
+		req := signed(t)
+		s := proxy(
+			WithSignReplaceExistingHeaders(true),
+			WithSignFields("@method"),
+			WithSignSignature(WithHmacSha256("test-shared-secret", k), WithSignName("backup"), WithSignFields("@method"), WithSignParams(ParamKeyID)),
+		)
+		assert.NoError(t, s.SignRequest(req))
+
+		assert.Equal(t, `proxy=("@method");keyid="proxy-key", backup=("@method");keyid="test-shared-secret"`, req.Header.Get(SignatureInputHeader))
+		assert.NoError(t, v.VerifyRequest(req))
+	})
+}
+
+type ctxKey struct{}
+
+// contextSigningKey records the context it was asked to sign with
+type contextSigningKey struct {
+	SigningKey
+	ctx context.Context
+}
+
+func (k *contextSigningKey) SignContext(ctx context.Context, data []byte) ([]byte, error) {
+	k.ctx = ctx
+	return k.Sign(data)
+}
+
+func TestSignRequestContext(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	s := NewSigner(
+		WithHmacSha256("test-shared-secret", k),
+		WithSignFields("@method"),
+	)
+	key := &contextSigningKey{SigningKey: s.config.Key}
+	s.config.Key = key
+
+	t.Run("signs with the given context", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "https://example.com/foo", nil)
+		assert.NoError(t, err)
+
+		ctx := context.WithValue(context.Background(), ctxKey{}, "given")
+		assert.NoError(t, s.SignRequestContext(ctx, req))
+		assert.NotEmpty(t, req.Header.Get(SignatureHeader))
+		assert.Equal(t, "given", key.ctx.Value(ctxKey{}))
+	})
+	t.Run("signs with the request context by default", func(t *testing.T) {
+		ctx := context.WithValue(context.Background(), ctxKey{}, "request")
+		req, err := http.NewRequestWithContext(ctx, "GET", "https://example.com/foo", nil)
+		assert.NoError(t, err)
+
+		assert.NoError(t, s.SignRequest(req))
+		assert.Equal(t, "request", key.ctx.Value(ctxKey{}))
+	})
+	t.Run("signs messages without a context", func(t *testing.T) {
+		_, err := s.Sign(&Message{Method: "GET", Header: http.Header{}, IsRequest: true})
+		assert.NoError(t, err)
+		assert.NotNil(t, key.ctx)
+	})
+}
+
+func TestSign_CanonicalizeContentType(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	signed := func(t *testing.T, opts ...signOption) *http.Request {
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString("{}"))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json;charset=UTF-8")
+		s := NewSigner(append([]signOption{WithHmacSha256("test-shared-secret", k), WithSignFields("@method", "content-type")}, opts...)...)
+		assert.NoError(t, s.SignRequest(req))
+		return req
+	}
+	canonical := NewVerifier(WithHmacSha256("test-shared-secret", k), WithCanonicalizeContentType(true))
+	raw := NewVerifier(WithHmacSha256("test-shared-secret", k))
+
+	t.Run("verifies a reformatted content type", func(t *testing.T) {
+		for _, contentType := range []string{"application/json;  charset=utf-8", "Application/JSON ; charset=\"UTF-8\""} {
+			req := signed(t, WithCanonicalizeContentType(true))
+			req.Header.Set("Content-Type", contentType)
+			assert.NoError(t, canonical.VerifyRequest(req), contentType)
+			assert.ErrorIs(t, raw.VerifyRequest(req), ErrSignatureInvalid, contentType)
+		}
+	})
+	t.Run("rejects a changed content type", func(t *testing.T) {
+		req := signed(t, WithCanonicalizeContentType(true))
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		assert.ErrorIs(t, canonical.VerifyRequest(req), ErrSignatureInvalid)
+	})
+	t.Run("signs the content type as it is by default", func(t *testing.T) {
+		req := signed(t)
+		assert.NoError(t, raw.VerifyRequest(req))
+		assert.ErrorIs(t, canonical.VerifyRequest(req), ErrSignatureInvalid)
+	})
+}
@@ -35,13 +35,18 @@ import (
 	"crypto/ecdsa"
 	"crypto/ed25519"
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
 	"encoding/base64"
 	"encoding/pem"
+	"errors"
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 	"time"
 
@@ -172,6 +177,64 @@ func TestVerify_RSA_PSS_SHA_512_Minimal_B_2_1(t *testing.T) {
 	assert.NoError(t, v.Verify(MessageFromRequest(req)), "verification failed")
 }
 
+// rsa-v1_5-sha256 signature of the test request with the RSA test key, made independently with
+// `openssl dgst -sha256 -sign` over the signature base
+const testRSAv15Signature = `SHOnj05rULN4rqGUxD/hQMQ82NbaVYmRhqBAY4cijlNes6U5Hf85tWJgk/QkbGtY8IIgPrfXK0DGFSD1sPm0Q7be39JvK/QH6WIGksgfdWol55w0p6JmEXI7mXO4H65QgSQkT/PNtgyWyZu2VD/Pm6SPFYCG+KEKntkTcbWlZuTw5Tuqz+4GmJ52CyiKIoOCsZSSGCmQ5rIEF4ctqemqDNmg10Q07FoMATTyEtIwkJF3BqIQf64RaBwFtisYUpKKo5OHhITFErfRXE6C3DLn4o+f6T3SBjXdMKP1ljUe/00jmML4ZtSG2Oc8mVEPon2wIBUBYw4rFOoujFsmFGvS3Q==`
+
+func TestSign_RSA_v1_5_SHA_256(t *testing.T) {
+	block, _ := pem.Decode([]byte(testKeyRSAPSS))
+	assert.NotNil(t, block, "could not decode test private key pem")
+
+	// taken from crypto/x509/pkcs8.go
+	type pkcs8 struct {
+		Version    int
+		Algo       pkix.AlgorithmIdentifier
+		PrivateKey []byte
+		// optional attributes omitted.
+	}
+	var privKey pkcs8
+	if _, err := asn1.Unmarshal(block.Bytes, &privKey); err != nil {
+		assert.NoError(t, err, "could not decode test private key pem")
+	}
+
+	pk, err := x509.ParsePKCS1PrivateKey(privKey.PrivateKey)
+	assert.NoError(t, err, "could not decode test private key")
+
+	s := NewSigner(
+		WithSignName("sig1"),
+		WithSignFields("@method", "@authority", "@path", "content-digest"),
+		WithSignParams(ParamKeyID, ParamAlg),
+		WithSignRsaPkcs1v15Sha256("test-key-rsa", pk),
+	)
+
+	hdr, err := s.Sign(MessageFromRequest(testReq()))
+	assert.NoError(t, err, "signing failed")
+
+	assert.Equal(t, `sig1=("@method" "@authority" "@path" "content-digest");keyid="test-key-rsa";alg="rsa-v1_5-sha256"`, hdr.Get("Signature-Input"), "signature input did not match")
+	// PKCS #1 v1.5 signatures aren't randomised, so match those of other implementations
+	assert.Equal(t, "sig1=:"+testRSAv15Signature+":", hdr.Get("Signature"), "signature did not match")
+}
+
+func TestVerify_RSA_v1_5_SHA_256(t *testing.T) {
+	block, _ := pem.Decode([]byte(testKeyRSAPSSPub))
+	assert.NotNil(t, block, "could not decode test public key pem")
+
+	pki, err := x509.ParsePKIXPublicKey(block.Bytes)
+	assert.NoError(t, err, "could not decode test public key")
+
+	pk := pki.(*rsa.PublicKey)
+
+	v := NewVerifier(
+		WithVerifyRsaPkcs1v15Sha256("test-key-rsa", pk),
+	)
+
+	req := testReq()
+	req.Header.Set("Signature-Input", `sig1=("@method" "@authority" "@path" "content-digest");keyid="test-key-rsa";alg="rsa-v1_5-sha256"`)
+	req.Header.Set("Signature", "sig1=:"+testRSAv15Signature+":")
+
+	assert.NoError(t, v.Verify(MessageFromRequest(req)), "verification failed")
+}
+
 func TestRoundtrip_RSA_PSS_SHA_512_Minimal_B_2_1(t *testing.T) {
 	blockPrivate, _ := pem.Decode([]byte(testKeyRSAPSS))
 	assert.NotNil(t, blockPrivate, "could not decode test private key pem")
@@ -744,6 +807,565 @@ func TestRoundtrip_ED25519_B_2_6(t *testing.T) {
 	assert.NoError(t, v.Verify(MessageFromRequest(req)), "verification failed")
 }
 
+func TestRoundtrip_StructuredField(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	created := time.Unix(1618884473, 0)
+	s := NewSigner(
+		WithSignParamValues(&SignatureParameters{
+			Created: &created,
+		}),
+		WithHmacSha256("test-shared-secret", k),
+		WithSignFields("@authority", `"cache-control";sf`),
+	)
+
+	req := testReq()
+	req.Header.Set("Cache-Control", "max-age=60,   public")
+	hdr, err := s.Sign(MessageFromRequest(req))
+	assert.NoError(t, err, "signing failed")
+	req.Header = hdr
+
+	// an intermediary reformats the header without changing its meaning
+	req.Header.Set("Cache-Control", "max-age=60, public")
+
+	v := NewVerifier(
+		WithHmacSha256("test-shared-secret", k),
+		withClock(&testClock{now: time.Unix(1618884473, 0)}),
+	)
+
+	assert.NoError(t, v.Verify(MessageFromRequest(req)), "verification failed")
+
+	// changing the value itself still breaks the signature
+	req.Header.Set("Cache-Control", "max-age=120, public")
+	assert.Error(t, v.Verify(MessageFromRequest(req)), "verification should have failed")
+}
+
+func TestRoundtrip_DictionaryKey(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	created := time.Unix(1618884473, 0)
+	s := NewSigner(
+		WithSignParamValues(&SignatureParameters{
+			Created: &created,
+		}),
+		WithHmacSha256("test-shared-secret", k),
+		WithSignFields(`"example-dict";key="b"`),
+	)
+
+	req := testReq()
+	req.Header.Set("Example-Dict", "a=1, b=2;x=1, c=3")
+	hdr, err := s.Sign(MessageFromRequest(req))
+	assert.NoError(t, err, "signing failed")
+	req.Header = hdr
+
+	v := NewVerifier(
+		WithHmacSha256("test-shared-secret", k),
+		withClock(&testClock{now: time.Unix(1618884473, 0)}),
+	)
+
+	// members other than the covered one are free to change
+	req.Header.Set("Example-Dict", "a=5, b=2;x=1")
+	assert.NoError(t, v.Verify(MessageFromRequest(req)), "verification failed")
+
+	req.Header.Set("Example-Dict", "a=1, b=3;x=1, c=3")
+	assert.Error(t, v.Verify(MessageFromRequest(req)), "verification should have failed")
+
+	req.Header.Set("Example-Dict", "a=1, c=3")
+	assert.Error(t, v.Verify(MessageFromRequest(req)), "verification should have failed")
+}
+
+func TestRoundtrip_RepeatedHeaders(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	created := time.Unix(1618884473, 0)
+	s := NewSigner(
+		WithSignParamValues(&SignatureParameters{
+			Created: &created,
+		}),
+		WithHmacSha256("test-shared-secret", k),
+		WithSignFields("@authority", "forwarded"),
+	)
+
+	req := testReq()
+	req.Header.Add("Forwarded", "for=192.0.2.60")
+	req.Header.Add("Forwarded", "for=198.51.100.17")
+	hdr, err := s.Sign(MessageFromRequest(req))
+	assert.NoError(t, err, "signing failed")
+	req.Header = hdr
+
+	v := NewVerifier(
+		WithHmacSha256("test-shared-secret", k),
+		withClock(&testClock{now: time.Unix(1618884473, 0)}),
+	)
+
+	assert.NoError(t, v.Verify(MessageFromRequest(req)), "verification failed")
+
+	// dropping the second occurrence must invalidate the signature
+	req.Header["Forwarded"] = req.Header["Forwarded"][:1]
+	assert.Error(t, v.Verify(MessageFromRequest(req)), "verification should have failed")
+}
+
+func TestRoundtrip_Trailer(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	created := time.Unix(1618884473, 0)
+	s := NewSigner(
+		WithSignParamValues(&SignatureParameters{
+			Created: &created,
+		}),
+		WithHmacSha256("test-shared-secret", k),
+		WithSignFields("@status", `"expires";tr`),
+	)
+
+	resp := testResp()
+	resp.Trailer = http.Header{"Expires": []string{"Wed, 9 Nov 2022 07:28:00 GMT"}}
+	hdr, err := s.Sign(MessageFromResponse(resp))
+	assert.NoError(t, err, "signing failed")
+	assert.Contains(t, hdr.Get(SignatureInputHeader), `("@status" "expires";tr)`)
+	resp.Header = hdr
+
+	v := NewVerifier(
+		WithHmacSha256("test-shared-secret", k),
+		withClock(&testClock{now: time.Unix(1618884473, 0)}),
+	)
+	assert.NoError(t, v.Verify(MessageFromResponse(resp)), "verification failed")
+
+	resp.Trailer.Set("Expires", "Thu, 10 Nov 2022 07:28:00 GMT")
+	assert.Error(t, v.Verify(MessageFromResponse(resp)), "verification should have failed")
+
+	// trailers of requests can't be signed
+	s = NewSigner(
+		WithHmacSha256("test-shared-secret", k),
+		WithSignFields(`"expires";tr`),
+	)
+	_, err = s.Sign(MessageFromRequest(testReq()))
+	assert.EqualError(t, err, "tr parameter not valid for requests")
+}
+
+func TestRoundtrip_EncodedPath(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	created := time.Unix(1618884473, 0)
+	s := NewSigner(
+		WithSignParamValues(&SignatureParameters{
+			Created: &created,
+		}),
+		WithHmacSha256("test-shared-secret", k),
+		WithSignFields("@path"),
+	)
+	v := NewVerifier(
+		WithHmacSha256("test-shared-secret", k),
+		withClock(&testClock{now: time.Unix(1618884473, 0)}),
+	)
+
+	for _, tc := range []struct {
+		name   string
+		client *url.URL
+		wire   string
+	}{
+		{"unicode", &url.URL{Scheme: "https", Host: "example.com", Path: "/café/bar baz"}, "/caf%C3%A9/bar%20baz"},
+		{"encoded slash", parse("https://example.com/foo%2Fbar"), "/foo%2Fbar"},
+		{"lowercase encoding", parse("https://example.com/foo%2fbar"), "/foo%2fbar"},
+		{"empty", &url.URL{Scheme: "https", Host: "example.com"}, "/"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// the client may build the URL from a decoded path, while the
+			// server sees the path as sent on the wire
+			client := testReq()
+			client.URL = tc.client
+			hdr, err := s.Sign(MessageFromRequest(client))
+			assert.NoError(t, err, "signing failed")
+
+			server := httptest.NewRequest(http.MethodPost, "https://example.com"+tc.wire, nil)
+			server.Header = hdr
+			assert.NoError(t, v.Verify(MessageFromRequest(server)), "verification failed")
+		})
+	}
+}
+
+func TestRoundtrip_ClientToServer(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	s := NewSigner(
+		WithHmacSha256("test-shared-secret", k),
+		WithSignFields("@method", "@authority", "@scheme", "@target-uri", "@request-target", "@path", "@query"),
+	)
+	v := NewVerifier(
+		WithHmacSha256("test-shared-secret", k),
+		WithVerifyAll(true),
+	)
+
+	// a client request only has the authority in its URL
+	client := &http.Request{Method: "GET", URL: parse("https://example.com/foo?param=Value"), Header: http.Header{}}
+	assert.NoError(t, s.SignRequest(client))
+
+	// a server request only has the path and query in its URL
+	server := &http.Request{
+		Method:     "GET",
+		Host:       "example.com",
+		URL:        parse("/foo?param=Value"),
+		RequestURI: "/foo?param=Value",
+		Header:     client.Header,
+		TLS:        &tls.ConnectionState{},
+	}
+	assert.NoError(t, v.VerifyRequest(server))
+
+	server.Host = "example.org"
+	assert.ErrorIs(t, v.VerifyRequest(server), ErrSignatureInvalid)
+}
+
+func TestRoundtrip_Method(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	s := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields("@method", "@path"))
+	v := NewVerifier(WithHmacSha256("test-shared-secret", k))
+
+	var received string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		received = r.Method
+		if err := v.VerifyRequest(r); err != nil {
+			rw.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	for _, method := range []string{"POST", "purge"} {
+		t.Run(method, func(t *testing.T) {
+			req, err := http.NewRequest(method, srv.URL+"/foo", nil)
+			assert.NoError(t, err)
+			assert.NoError(t, s.SignRequest(req))
+
+			inputs, err := ParseSignatureHeaders(req.Header)
+			assert.NoError(t, err)
+			base, err := SignatureBase(MessageFromRequest(req), inputs[0])
+			assert.NoError(t, err)
+			assert.True(t, strings.HasPrefix(base, `"@method": `+method+"\n"), "the method must be signed verbatim")
+
+			resp, err := srv.Client().Do(req)
+			assert.NoError(t, err)
+			_ = resp.Body.Close()
+			assert.Equal(t, method, received)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	}
+
+	// a method with a different case is a different method
+	req := MessageFromRequest(testReq())
+	hdr, err := s.Sign(req)
+	assert.NoError(t, err)
+	req.Header = hdr
+	req.Method = "post"
+	assert.ErrorIs(t, v.Verify(req), ErrSignatureInvalid)
+}
+
+func TestRoundtrip_ContentLength(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	s := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields("@method", "content-length", "content-digest"))
+	v := NewVerifier(WithHmacSha256("test-shared-secret", k), WithVerifyAll(true))
+
+	var received *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		received = r
+		if err := v.VerifyRequest(r); err != nil {
+			rw.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	send := func(t *testing.T, req *http.Request) {
+		t.Helper()
+		assert.NoError(t, s.SignRequest(req))
+		resp, err := srv.Client().Do(req)
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	t.Run("sets the length of a body without one", func(t *testing.T) {
+		// a body set directly has no length, so would otherwise be sent chunked
+		req, err := http.NewRequest("POST", srv.URL+"/foo", io.NopCloser(strings.NewReader(`{"hello": "world"}`)))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), req.ContentLength)
+
+		send(t, req)
+		assert.Equal(t, int64(18), req.ContentLength)
+		assert.Equal(t, "18", received.Header.Get("Content-Length"))
+		assert.Contains(t, req.Header.Get(SignatureInputHeader), `"content-length"`)
+	})
+	t.Run("leaves content-length out without a body", func(t *testing.T) {
+		req, err := http.NewRequest("GET", srv.URL+"/foo", nil)
+		assert.NoError(t, err)
+
+		send(t, req)
+		assert.NotContains(t, req.Header.Get(SignatureInputHeader), `"content-length"`)
+	})
+	t.Run("signs a length of 0 for an empty body", func(t *testing.T) {
+		for _, method := range []string{"POST", "PUT", "PATCH"} {
+			req, err := http.NewRequest(method, srv.URL+"/foo", io.NopCloser(strings.NewReader("")))
+			assert.NoError(t, err)
+
+			send(t, req)
+			assert.Equal(t, http.NoBody, req.Body)
+			assert.Equal(t, int64(0), req.ContentLength)
+			assert.Equal(t, "0", received.Header.Get("Content-Length"))
+			assert.Contains(t, req.Header.Get(SignatureInputHeader), `"content-length"`)
+			assert.Equal(t, "sha-256=:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=:", req.Header.Get(ContentDigestHeader))
+
+			body, err := req.GetBody()
+			assert.NoError(t, err)
+			assert.Equal(t, http.NoBody, body)
+		}
+	})
+	t.Run("signs a length of 0 for a post without a body", func(t *testing.T) {
+		req, err := http.NewRequest("POST", srv.URL+"/foo", nil)
+		assert.NoError(t, err)
+
+		send(t, req)
+		assert.Equal(t, "0", received.Header.Get("Content-Length"))
+		assert.Contains(t, req.Header.Get(SignatureInputHeader), `"content-length"`)
+	})
+	t.Run("leaves content-length out for an empty body without a length", func(t *testing.T) {
+		req, err := http.NewRequest("DELETE", srv.URL+"/foo", io.NopCloser(strings.NewReader("")))
+		assert.NoError(t, err)
+
+		send(t, req)
+		assert.Empty(t, received.Header.Get("Content-Length"))
+		assert.NotContains(t, req.Header.Get(SignatureInputHeader), `"content-length"`)
+	})
+}
+
+func TestRoundtrip_FieldWhitespace(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	s := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields("@method", "x-value"))
+	v := NewVerifier(WithHmacSha256("test-shared-secret", k))
+
+	msg := MessageFromRequest(testReq())
+	msg.Header.Set("X-Value", "a  b,c")
+	hdr, err := s.Sign(msg)
+	assert.NoError(t, err)
+
+	// whitespace added around a value, as a proxy may do, doesn't change it
+	for _, value := range []string{"a  b,c", " a  b,c ", "\ta  b,c\t"} {
+		received := *msg
+		received.Header = hdr.Clone()
+		received.Header.Set("X-Value", value)
+		assert.NoError(t, v.Verify(&received), "%q", value)
+	}
+
+	// whitespace within a value does
+	for _, value := range []string{"a b,c", "a  b, c"} {
+		received := *msg
+		received.Header = hdr.Clone()
+		received.Header.Set("X-Value", value)
+		assert.ErrorIs(t, v.Verify(&received), ErrSignatureInvalid, "%q", value)
+	}
+}
+
+func TestRoundtrip_CustomComponent(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	// the tenant is assembled from the authority and a header
+	tenant := func(msg *Message) (string, error) {
+		org := msg.Header.Get("X-Org")
+		if org == "" {
+			return "", errors.New("no organisation")
+		}
+		return org + "@" + msg.Authority, nil
+	}
+
+	created := time.Unix(1618884473, 0)
+	s := NewSigner(
+		WithSignParamValues(&SignatureParameters{
+			Created: &created,
+		}),
+		WithHmacSha256("test-shared-secret", k),
+		WithComponentResolver("@Tenant", tenant),
+		WithSignFields("@method", "@tenant"),
+	)
+	v := NewVerifier(
+		WithHmacSha256("test-shared-secret", k),
+		WithComponentResolver("@tenant", tenant),
+		withClock(&testClock{now: time.Unix(1618884473, 0)}),
+	)
+
+	req := testReq()
+	req.Header.Set("X-Org", "acme")
+	hdr, err := s.Sign(MessageFromRequest(req))
+	assert.NoError(t, err, "signing failed")
+	req.Header = hdr
+
+	base, err := v.SignatureBase(MessageFromRequest(req), "sig")
+	assert.NoError(t, err)
+	assert.Equal(t, `"@method": POST
+"@tenant": acme@example.com
+"@signature-params": ("@method" "@tenant");created=1618884473;keyid="test-shared-secret";alg="hmac-sha256"`, base)
+	assert.NoError(t, v.Verify(MessageFromRequest(req)), "verification failed")
+
+	req.Header.Set("X-Org", "umbrella")
+	assert.ErrorIs(t, v.Verify(MessageFromRequest(req)), ErrSignatureInvalid, "verification should have failed")
+
+	req.Header.Del("X-Org")
+	assert.EqualError(t, v.Verify(MessageFromRequest(req)), "unable to resolve component @tenant: no organisation")
+	_, err = s.Sign(MessageFromRequest(req))
+	assert.EqualError(t, err, "unable to resolve component @tenant: no organisation")
+
+	// without a resolver, the component is unknown
+	v = NewVerifier(WithHmacSha256("test-shared-secret", k))
+	assert.EqualError(t, v.Verify(MessageFromRequest(req)), "unknown component: @tenant")
+}
+
+func TestSignatureBase_B_2(t *testing.T) {
+	created := time.Unix(1618884473, 0)
+	ptr := func(s string) *string { return &s }
+
+	for _, tc := range []struct {
+		name     string
+		msg      *Message
+		input    SignatureInput
+		expected string
+	}{
+		{
+			name: "B.2.1 minimal",
+			msg:  MessageFromRequest(testReq()),
+			input: SignatureInput{
+				SignatureParameters: SignatureParameters{Created: &created, KeyID: ptr("test-key-rsa-pss"), Nonce: ptr("b3k2pp5k7z-50gnwp.yemd")},
+			},
+			expected: `"@signature-params": ();created=1618884473;keyid="test-key-rsa-pss";nonce="b3k2pp5k7z-50gnwp.yemd"`,
+		},
+		{
+			name: "B.2.2 selective",
+			msg:  MessageFromRequest(testReq()),
+			input: SignatureInput{
+				SignatureParameters: SignatureParameters{Created: &created, KeyID: ptr("test-key-rsa-pss"), Tag: ptr("header-example")},
+				Fields:              []string{"@authority", "content-digest", `"@query-param";name="Pet"`},
+			},
+			expected: `"@authority": example.com
+"content-digest": sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:
+"@query-param";name="Pet": dog
+"@signature-params": ("@authority" "content-digest" "@query-param";name="Pet");created=1618884473;keyid="test-key-rsa-pss";tag="header-example"`,
+		},
+		{
+			name: "B.2.3 full coverage",
+			msg:  MessageFromRequest(testReq()),
+			input: SignatureInput{
+				SignatureParameters: SignatureParameters{Created: &created, KeyID: ptr("test-key-rsa-pss")},
+				Fields:              []string{"date", "@method", "@path", "@query", "@authority", "content-type", "content-digest", "content-length"},
+			},
+			expected: `"date": Tue, 20 Apr 2021 02:07:55 GMT
+"@method": POST
+"@path": /foo
+"@query": ?param=Value&Pet=dog
+"@authority": example.com
+"content-type": application/json
+"content-digest": sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:
+"content-length": 18
+"@signature-params": ("date" "@method" "@path" "@query" "@authority" "content-type" "content-digest" "content-length");created=1618884473;keyid="test-key-rsa-pss"`,
+		},
+		{
+			name: "B.2.4 response",
+			msg:  MessageFromResponse(testResp()),
+			input: SignatureInput{
+				SignatureParameters: SignatureParameters{Created: func() *time.Time { c := time.Unix(1618884479, 0); return &c }(), KeyID: ptr("test-key-ecc-p256")},
+				Fields:              []string{"@status", "content-type", "content-digest", "content-length"},
+			},
+			expected: `"@status": 200
+"content-type": application/json
+"content-digest": sha-512=:mEWXIS7MaLRuGgxOBdODa3xqM1XdEvxoYhvlCFJ41QJgJc4GTsPp29l5oGX69wWdXymyU0rjJuahq4l5aGgfLQ==:
+"content-length": 23
+"@signature-params": ("@status" "content-type" "content-digest" "content-length");created=1618884479;keyid="test-key-ecc-p256"`,
+		},
+		{
+			name: "B.2.5 hmac",
+			msg:  MessageFromRequest(testReq()),
+			input: SignatureInput{
+				SignatureParameters: SignatureParameters{Created: &created, KeyID: ptr("test-shared-secret")},
+				Fields:              []string{"date", "@authority", "content-type"},
+			},
+			expected: `"date": Tue, 20 Apr 2021 02:07:55 GMT
+"@authority": example.com
+"content-type": application/json
+"@signature-params": ("date" "@authority" "content-type");created=1618884473;keyid="test-shared-secret"`,
+		},
+		{
+			name: "B.2.6 ed25519",
+			msg:  MessageFromRequest(testReq()),
+			input: SignatureInput{
+				SignatureParameters: SignatureParameters{Created: &created, KeyID: ptr("test-key-ed25519")},
+				Fields:              []string{"date", "@method", "@path", "@authority", "content-type", "content-length"},
+			},
+			expected: `"date": Tue, 20 Apr 2021 02:07:55 GMT
+"@method": POST
+"@path": /foo
+"@authority": example.com
+"content-type": application/json
+"content-length": 18
+"@signature-params": ("date" "@method" "@path" "@authority" "content-type" "content-length");created=1618884473;keyid="test-key-ed25519"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			base, err := SignatureBase(tc.msg, tc.input)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, base)
+		})
+	}
+
+	t.Run("matches the parsed input of a signed message", func(t *testing.T) {
+		k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+		if err != nil {
+			panic("could not decode test shared secret")
+		}
+		s := NewSigner(
+			WithSignParamValues(&SignatureParameters{Created: &created}),
+			WithSignParams(ParamCreated, ParamKeyID),
+			WithHmacSha256("test-shared-secret", k),
+			WithSignFields("date", "@authority", "content-type"),
+		)
+		hdr, err := s.Sign(MessageFromRequest(testReq()))
+		assert.NoError(t, err)
+
+		inputs, err := ParseSignatureHeaders(hdr)
+		assert.NoError(t, err)
+		base, err := SignatureBase(MessageFromRequest(testReq()), inputs[0])
+		assert.NoError(t, err)
+		assert.Equal(t, `"date": Tue, 20 Apr 2021 02:07:55 GMT
+"@authority": example.com
+"content-type": application/json
+"@signature-params": ("date" "@authority" "content-type");created=1618884473;keyid="test-shared-secret"`, base)
+	})
+}
+
 // The following keypairs are taken from the Draft Standard, so we may recreate the examples in tests.
 // If your robot scans this repo and says it's leaking keys I will be mildly amused.
 
@@ -818,3 +1440,48 @@ MC4CAQAwBQYDK2VwBCIEIJ+DYvh6SEqVTm50DFtMDoQikTmiCqirVv9mWG9qfSnF
 `
 
 var testSharedSecret = `uzvJfB4u3N0Jy4T7NZ75MDVcr8zSTInedJtkgcu46YW4XByzNJjxBdtjUkdJPBtbmHhIDi6pcl8jsasjlTMtDQ==`
+
+func TestRoundtrip_QuotedParams(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	keyID := `https://keys.example.com/k?"weird"\path`
+	tag := `app "one"\two`
+	created := time.Unix(1618884473, 0)
+	params := &SignatureParameters{Created: &created, Tag: &tag}
+
+	t.Run("rfc 9421", func(t *testing.T) {
+		s := NewSigner(WithHmacSha256(keyID, k), WithSignFields("@method"), WithSignParamValues(params), WithSignParams(ParamCreated, ParamKeyID, ParamTag))
+		v := NewVerifier(WithHmacSha256(keyID, k), withClock(&testClock{now: created}))
+
+		req := testReq()
+		hdr, err := s.Sign(MessageFromRequest(req))
+		assert.NoError(t, err)
+		assert.Equal(t, `sig=("@method");created=1618884473;keyid="https://keys.example.com/k?\"weird\"\\path";tag="app \"one\"\\two"`, hdr.Get(SignatureInputHeader))
+		req.Header = hdr
+
+		inputs, err := ParseSignatureHeaders(hdr)
+		assert.NoError(t, err)
+		if assert.Len(t, inputs, 1) {
+			assert.Equal(t, keyID, *inputs[0].KeyID)
+			assert.Equal(t, tag, *inputs[0].Tag)
+		}
+		assert.NoError(t, v.Verify(MessageFromRequest(req)))
+
+		v = NewVerifier(WithHmacSha256(`https://keys.example.com/k?"weird"`, k), withClock(&testClock{now: created}))
+		assert.ErrorIs(t, v.Verify(MessageFromRequest(req)), ErrUnknownKeyID)
+	})
+	t.Run("cavage", func(t *testing.T) {
+		s := NewSigner(WithHmacSha256(keyID, k), WithSignFields("(request-target)"), WithSignParamValues(params), WithCavageCompat(true))
+		v := NewVerifier(WithHmacSha256(keyID, k), WithCavageCompat(true), withClock(&testClock{now: created}))
+
+		req := testReq()
+		hdr, err := s.Sign(MessageFromRequest(req))
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(hdr.Get(SignatureHeader), `keyId="https://keys.example.com/k?\"weird\"\\path",`), hdr.Get(SignatureHeader))
+		req.Header = hdr
+		assert.NoError(t, v.Verify(MessageFromRequest(req)))
+	})
+}
@@ -32,9 +32,16 @@
 package httpsig
 
 import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
 	"fmt"
+	"maps"
 	"net/http"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/dunglas/httpsfv"
 	"github.com/stretchr/testify/assert"
@@ -48,6 +55,181 @@ func parseItem(s string) httpsfv.Item {
 	return i
 }
 
+func TestMessageFromRequest(t *testing.T) {
+	t.Run("client request", func(t *testing.T) {
+		msg := MessageFromRequest(&http.Request{Method: "GET", URL: parse("https://example.com:8443/foo?a=b")})
+		assert.Equal(t, "example.com:8443", msg.Authority)
+		assert.Equal(t, "https://example.com:8443/foo?a=b", msg.URL.String())
+	})
+	t.Run("client request with a host override", func(t *testing.T) {
+		msg := MessageFromRequest(&http.Request{Method: "GET", Host: "example.org", URL: parse("https://example.com/foo")})
+		assert.Equal(t, "example.org", msg.Authority)
+		assert.Equal(t, "https://example.com/foo", msg.URL.String())
+	})
+	t.Run("server request", func(t *testing.T) {
+		r := &http.Request{Method: "GET", Host: "example.com", URL: parse("/foo?a=b")}
+		msg := MessageFromRequest(r)
+		assert.Equal(t, "example.com", msg.Authority)
+		assert.Equal(t, "http://example.com/foo?a=b", msg.URL.String())
+		assert.Equal(t, "/foo?a=b", r.URL.String(), "the request must not be modified")
+	})
+	t.Run("server request over TLS", func(t *testing.T) {
+		msg := MessageFromRequest(&http.Request{Method: "GET", Host: "example.com", URL: parse("/foo"), TLS: &tls.ConnectionState{}})
+		assert.Equal(t, "https://example.com/foo", msg.URL.String())
+	})
+}
+
+func TestNewMessage(t *testing.T) {
+	t.Run("matches a request", func(t *testing.T) {
+		req := testReq()
+		msg, err := NewMessage("POST", "example.com", "/foo", "param=Value&Pet=dog", req.Header)
+		assert.NoError(t, err)
+
+		fields := []string{"@method", "@authority", "@scheme", "@target-uri", "@request-target", "@path", "@query", `"@query-param";name="Pet"`, "content-type"}
+		expected, err := createSignatureBase(fields, MessageFromRequest(req))
+		assert.NoError(t, err)
+		actual, err := createSignatureBase(fields, msg)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, actual)
+
+		msg.Header.Set("Content-Type", "text/plain")
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"), "the headers must be copied")
+	})
+	t.Run("keeps the encoding of the path", func(t *testing.T) {
+		msg, err := NewMessage("GET", "example.com", "/foo%2Fbar/a%20b", "", nil)
+		assert.NoError(t, err)
+		assert.NotNil(t, msg.Header)
+
+		c, err := canonicaliseComponent("@path", httpsfv.NewParams(), msg)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"/foo%2Fbar/a%20b"}, c)
+	})
+	t.Run("empty path", func(t *testing.T) {
+		msg, err := NewMessage("GET", "example.com", "", "", nil)
+		assert.NoError(t, err)
+
+		c, err := canonicaliseComponent("@path", httpsfv.NewParams(), msg)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"/"}, c)
+	})
+	t.Run("error on an invalid path", func(t *testing.T) {
+		for _, path := range []string{"foo", "https://example.com/foo", "/foo?a=b", "/foo%zz"} {
+			_, err := NewMessage("GET", "example.com", path, "", nil)
+			assert.Error(t, err, path)
+		}
+	})
+}
+
+func TestRequestForms(t *testing.T) {
+	derive := func(t *testing.T, msg *Message) []string {
+		var values []string
+		for _, component := range []string{"@method", "@authority", "@scheme", "@target-uri", "@request-target", "@path"} {
+			c, err := canonicaliseComponent(component, httpsfv.NewParams(), msg)
+			assert.NoError(t, err)
+			values = append(values, c...)
+		}
+		return values
+	}
+	received := func(t *testing.T, raw string) *Message {
+		r, err := http.ReadRequest(bufio.NewReader(strings.NewReader(raw)))
+		assert.NoError(t, err)
+		return MessageFromRequest(r)
+	}
+
+	t.Run("asterisk form", func(t *testing.T) {
+		want := []string{"OPTIONS", "example.com", "http", "http://example.com", "*", "/"}
+		assert.Equal(t, want, derive(t, received(t, "OPTIONS * HTTP/1.1\r\nHost: example.com\r\n\r\n")))
+		assert.Equal(t, want, derive(t, MessageFromRequest(&http.Request{Method: "OPTIONS", URL: &url.URL{Scheme: "http", Host: "example.com", Path: "*"}})))
+	})
+	t.Run("authority form", func(t *testing.T) {
+		want := []string{"CONNECT", "example.com:8443", "http", "http://example.com:8443", "example.com:8443", "/"}
+		assert.Equal(t, want, derive(t, received(t, "CONNECT example.com:8443 HTTP/1.1\r\nHost: example.com:8443\r\n\r\n")))
+		assert.Equal(t, want, derive(t, MessageFromRequest(&http.Request{Method: "CONNECT", Host: "example.com:8443", URL: &url.URL{Host: "example.com:8443"}})))
+	})
+	t.Run("connect with a path", func(t *testing.T) {
+		// as used to bootstrap websockets over HTTP/2, eg: with a :protocol pseudo-header
+		want := []string{"CONNECT", "example.com", "https", "https://example.com/chat", "/chat", "/chat"}
+		assert.Equal(t, want, derive(t, MessageFromRequest(&http.Request{Method: "CONNECT", URL: parse("https://example.com/chat")})))
+	})
+	t.Run("signs and verifies", func(t *testing.T) {
+		k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+		assert.NoError(t, err)
+		s := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields("@method", "@authority", "@target-uri", "@request-target", "@path"))
+		v := NewVerifier(WithHmacSha256("test-shared-secret", k))
+
+		for _, req := range []*http.Request{
+			{Method: "OPTIONS", URL: &url.URL{Scheme: "http", Host: "example.com", Path: "*"}, Header: http.Header{}},
+			{Method: "CONNECT", Host: "example.com:8443", URL: &url.URL{Scheme: "http", Host: "example.com:8443"}, Header: http.Header{}},
+		} {
+			assert.NoError(t, s.SignRequest(req))
+			received, err := NewVerifyTestRequest(req)
+			assert.NoError(t, err)
+			assert.NoError(t, v.VerifyRequest(received), req.Method)
+		}
+	})
+}
+
+func TestMessageFromMetadata(t *testing.T) {
+	md := map[string][]string{
+		":authority":   {"grpc.example.com"},
+		":path":        {"/ignored"},
+		"content-type": {"application/grpc-web+proto"},
+		"x-tenant":     {"acme"},
+		"trace-bin":    {"AAECAw"},
+	}
+
+	t.Run("maps metadata to components", func(t *testing.T) {
+		msg, err := MessageFromMetadata(md, "POST", "/helloworld.Greeter/SayHello")
+		assert.NoError(t, err)
+
+		base, err := createSignatureBase([]string{"@method", "@authority", "@path", "content-type", "x-tenant", "trace-bin"}, msg)
+		assert.NoError(t, err)
+		assert.Equal(t, []signatureItem{
+			{httpsfv.NewItem("@method"), []string{"POST"}},
+			{httpsfv.NewItem("@authority"), []string{"grpc.example.com"}},
+			{httpsfv.NewItem("@path"), []string{"/helloworld.Greeter/SayHello"}},
+			{httpsfv.NewItem("content-type"), []string{"application/grpc-web+proto"}},
+			{httpsfv.NewItem("x-tenant"), []string{"acme"}},
+			{httpsfv.NewItem("trace-bin"), []string{"AAECAw"}},
+		}, base)
+
+		_, err = createSignatureBase([]string{":path"}, msg)
+		assert.Error(t, err, "pseudo-headers aren't fields")
+	})
+	t.Run("takes the authority from host", func(t *testing.T) {
+		msg, err := MessageFromMetadata(map[string][]string{"host": {"grpc.example.com"}}, "POST", "/helloworld.Greeter/SayHello")
+		assert.NoError(t, err)
+		assert.Equal(t, "grpc.example.com", msg.Authority)
+	})
+	t.Run("signs and verifies", func(t *testing.T) {
+		k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+		assert.NoError(t, err)
+
+		msg, err := MessageFromMetadata(md, "POST", "/helloworld.Greeter/SayHello")
+		assert.NoError(t, err)
+		hdr, err := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields("@path", "x-tenant")).Sign(msg)
+		assert.NoError(t, err)
+
+		// the signature is sent in the metadata
+		signed := maps.Clone(md)
+		signed["signature"] = hdr.Values(SignatureHeader)
+		signed["signature-input"] = hdr.Values(SignatureInputHeader)
+		received, err := MessageFromMetadata(signed, "POST", "/helloworld.Greeter/SayHello")
+		assert.NoError(t, err)
+		v := NewVerifier(WithHmacSha256("test-shared-secret", k), WithVerifyAll(true))
+		assert.NoError(t, v.Verify(received))
+
+		signed["x-tenant"] = []string{"other"}
+		received, err = MessageFromMetadata(signed, "POST", "/helloworld.Greeter/SayHello")
+		assert.NoError(t, err)
+		assert.ErrorIs(t, v.Verify(received), ErrSignatureInvalid)
+	})
+	t.Run("error on an invalid path", func(t *testing.T) {
+		_, err := MessageFromMetadata(md, "POST", "helloworld.Greeter/SayHello")
+		assert.Error(t, err)
+	})
+}
+
 func TestCanonicaliseComponent_UnboundComponents(t *testing.T) {
 	t.Run("derives @method component", func(t *testing.T) {
 		req := &http.Request{
@@ -71,7 +253,7 @@ func TestCanonicaliseComponent_UnboundComponents(t *testing.T) {
 			c, err := canonicaliseComponent("@method", httpsfv.NewParams(), MessageFromRequest(r))
 			assert.NoError(t, err)
 
-			assert.Equal(t, []string{"GET"}, c)
+			assert.Equal(t, []string{"get"}, c)
 		})
 	})
 	t.Run("derives @target-uri component", func(t *testing.T) {
@@ -167,6 +349,84 @@ func TestCanonicaliseComponent_UnboundComponents(t *testing.T) {
 			assert.Equal(t, []string{"http"}, c)
 		})
 	})
+	t.Run("derives components of custom schemes", func(t *testing.T) {
+		req := &http.Request{
+			Method:        "POST",
+			Host:          "RPC.example.com:80",
+			URL:           &url.URL{Scheme: "MyRPC", Host: "RPC.example.com:80", Path: "/orders/Create", RawQuery: "v=2"},
+			Header:        http.Header{},
+			ContentLength: 18,
+		}
+
+		for _, tc := range []struct {
+			component string
+			want      string
+		}{
+			{"@scheme", "myrpc"},
+			{"@target-uri", "myrpc://RPC.example.com:80/orders/Create?v=2"},
+			// the port is kept, as it isn't known to be the default port of the scheme
+			{"@authority", "rpc.example.com:80"},
+			{"@request-target", "/orders/Create?v=2"},
+			{"@path", "/orders/Create"},
+		} {
+			c, err := canonicaliseComponent(tc.component, httpsfv.NewParams(), MessageFromRequest(req))
+			assert.NoError(t, err, tc.component)
+			assert.Equal(t, []string{tc.want}, c, tc.component)
+		}
+
+		t.Run("parsed", func(t *testing.T) {
+			r := req.Clone(req.Context())
+			r.Host = "rpc.example.com:443"
+			r.URL = parse("myrpc://rpc.example.com:443/orders/Create")
+
+			c, err := canonicaliseComponent("@authority", httpsfv.NewParams(), MessageFromRequest(r))
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"rpc.example.com:443"}, c)
+
+			c, err = canonicaliseComponent("@target-uri", httpsfv.NewParams(), MessageFromRequest(r))
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"myrpc://rpc.example.com:443/orders/Create"}, c)
+		})
+		t.Run("with empty port", func(t *testing.T) {
+			r := req.Clone(req.Context())
+			r.Host = "rpc.example.com:"
+
+			c, err := canonicaliseComponent("@authority", httpsfv.NewParams(), MessageFromRequest(r))
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"rpc.example.com:"}, c)
+		})
+		t.Run("with websocket default port", func(t *testing.T) {
+			r := req.Clone(req.Context())
+			r.URL.Scheme = "wss"
+			r.Host = "rpc.example.com:443"
+
+			c, err := canonicaliseComponent("@authority", httpsfv.NewParams(), MessageFromRequest(r))
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"rpc.example.com"}, c)
+		})
+		t.Run("roundtrip", func(t *testing.T) {
+			k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+			if err != nil {
+				panic("could not decode test shared secret")
+			}
+
+			fields := []string{"@method", "@scheme", "@authority", "@target-uri", "@path", "@query"}
+			s := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields(fields...))
+			msg := MessageFromRequest(req)
+			hdr, err := s.Sign(msg)
+			assert.NoError(t, err)
+
+			// a verifier holding the same URL as parsed, and so with a lowercase scheme
+			r := req.Clone(req.Context())
+			r.URL = parse("myrpc://RPC.example.com:80/orders/Create?v=2")
+			r.Header = hdr
+			v := NewVerifier(WithHmacSha256("test-shared-secret", k))
+			assert.NoError(t, v.Verify(MessageFromRequest(r)))
+
+			r.Host = "rpc.example.com"
+			assert.ErrorIs(t, v.Verify(MessageFromRequest(r)), ErrSignatureInvalid)
+		})
+	})
 	t.Run("derives @request-target component", func(t *testing.T) {
 		req := &http.Request{
 			Method:        "GET",
@@ -214,6 +474,55 @@ func TestCanonicaliseComponent_UnboundComponents(t *testing.T) {
 
 			assert.Equal(t, []string{"/foo%20bar"}, c)
 		})
+		t.Run("with encoded slash", func(t *testing.T) {
+			r := req.Clone(req.Context())
+			r.URL = parse("https://example.com/foo%2Fbar/baz")
+
+			c, err := canonicaliseComponent("@path", httpsfv.NewParams(), MessageFromRequest(r))
+			assert.NoError(t, err)
+
+			assert.Equal(t, []string{"/foo%2Fbar/baz"}, c)
+		})
+		t.Run("with lowercase encoding", func(t *testing.T) {
+			r := req.Clone(req.Context())
+			r.URL = parse("https://example.com/foo%2fbar%c3%a9")
+
+			c, err := canonicaliseComponent("@path", httpsfv.NewParams(), MessageFromRequest(r))
+			assert.NoError(t, err)
+
+			assert.Equal(t, []string{"/foo%2Fbar%C3%A9"}, c)
+		})
+		t.Run("with unicode", func(t *testing.T) {
+			r := req.Clone(req.Context())
+			r.URL = &url.URL{Scheme: "https", Host: "example.com", Path: "/café/bar baz"}
+
+			c, err := canonicaliseComponent("@path", httpsfv.NewParams(), MessageFromRequest(r))
+			assert.NoError(t, err)
+
+			assert.Equal(t, []string{"/caf%C3%A9/bar%20baz"}, c)
+		})
+		t.Run("matches between decoded and raw paths", func(t *testing.T) {
+			raw := req.Clone(req.Context())
+			raw.URL = parse("https://example.com/caf%C3%A9")
+			decoded := req.Clone(req.Context())
+			decoded.URL = &url.URL{Scheme: "https", Host: "example.com", Path: "/café"}
+
+			c1, err := canonicaliseComponent("@path", httpsfv.NewParams(), MessageFromRequest(raw))
+			assert.NoError(t, err)
+			c2, err := canonicaliseComponent("@path", httpsfv.NewParams(), MessageFromRequest(decoded))
+			assert.NoError(t, err)
+
+			assert.Equal(t, c1, c2)
+		})
+		t.Run("with invalid raw path", func(t *testing.T) {
+			r := req.Clone(req.Context())
+			r.URL = &url.URL{Scheme: "https", Host: "example.com", Path: "/foo bar", RawPath: "/other"}
+
+			c, err := canonicaliseComponent("@path", httpsfv.NewParams(), MessageFromRequest(r))
+			assert.NoError(t, err)
+
+			assert.Equal(t, []string{"/foo%20bar"}, c)
+		})
 		t.Run("empty", func(t *testing.T) {
 			r := req.Clone(req.Context())
 			r.URL = parse("https://example.com")
@@ -257,6 +566,33 @@ func TestCanonicaliseComponent_UnboundComponents(t *testing.T) {
 
 			assert.Equal(t, []string{"?param=Value%20bar&Pet=dog"}, c)
 		})
+		t.Run("with empty query string", func(t *testing.T) {
+			r := req.Clone(req.Context())
+			r.URL = parse("https://example.com/foo?")
+
+			c, err := canonicaliseComponent("@query", httpsfv.NewParams(), MessageFromRequest(r))
+			assert.NoError(t, err)
+
+			assert.Equal(t, []string{"?"}, c)
+		})
+		t.Run("preserves order and repeated names", func(t *testing.T) {
+			r := req.Clone(req.Context())
+			r.URL = parse("https://example.com/foo?b=2&a=b&a=c")
+
+			c, err := canonicaliseComponent("@query", httpsfv.NewParams(), MessageFromRequest(r))
+			assert.NoError(t, err)
+
+			assert.Equal(t, []string{"?b=2&a=b&a=c"}, c)
+		})
+		t.Run("preserves original encoding", func(t *testing.T) {
+			r := req.Clone(req.Context())
+			r.URL = parse("https://example.com/foo?q=a+b&r=%7e")
+
+			c, err := canonicaliseComponent("@query", httpsfv.NewParams(), MessageFromRequest(r))
+			assert.NoError(t, err)
+
+			assert.Equal(t, []string{"?q=a+b&r=%7e"}, c)
+		})
 	})
 	t.Run("derives @query-param component", func(t *testing.T) {
 		req := &http.Request{
@@ -332,6 +668,59 @@ func TestCanonicaliseComponent_UnboundComponents(t *testing.T) {
 
 			assert.Equal(t, []string{"something"}, c)
 		})
+		for _, tt := range []struct {
+			name   string
+			query  string
+			param  string
+			values []string
+			err    string
+		}{
+			{"plus and encoded spaces", "a=b+c%20d", "a", []string{"b%20c%20d"}, ""},
+			{"plus in the name", "a+b=c", "a+b", []string{"c"}, ""},
+			{"encoded space in the name", "a+b=c", "a%20b", []string{"c"}, ""},
+			{"decodes once", "a=%2525", "a", []string{"%2525"}, ""},
+			{"invalid encoding", "a=100%&b=%zz", "a", []string{"100%25"}, ""},
+			{"invalid encoding", "a=100%&b=%zz", "b", []string{"%25zz"}, ""},
+			{"reserved characters", "a=~!'()*-._:/?@", "a", []string{"%7E%21%27%28%29*-._%3A%2F%3F%40"}, ""},
+			{"lowercase hex", "a=%c3%a7", "a", []string{"%C3%A7"}, ""},
+			{"repeated", "a=1&b=2&a=3&a", "a", nil, `query parameter "a" is repeated`},
+			{"semicolons", "a=1;b=2", "a", []string{"1%3Bb%3D2"}, ""},
+			{"without a value", "a&b=", "a", []string{""}, ""},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				params := httpsfv.NewParams()
+				params.Add("name", tt.param)
+				r := req.Clone(req.Context())
+				r.URL = &url.URL{Scheme: "https", Host: "example.com", Path: "/parameters", RawQuery: tt.query}
+
+				c, err := canonicaliseComponent("@query-param", params, MessageFromRequest(r))
+				if tt.err != "" {
+					assert.EqualError(t, err, tt.err)
+					return
+				}
+				assert.NoError(t, err)
+				assert.Equal(t, tt.values, c)
+			})
+		}
+		t.Run("rejects repeated parameters when signing and verifying", func(t *testing.T) {
+			k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+			if err != nil {
+				panic("could not decode test shared secret")
+			}
+
+			s := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields(`"@query-param";name="a"`))
+			v := NewVerifier(WithHmacSha256("test-shared-secret", k))
+
+			r := req.Clone(req.Context())
+			r.URL = &url.URL{Scheme: "https", Host: "example.com", Path: "/parameters", RawQuery: "a=1&a=2"}
+			assert.ErrorContains(t, s.SignRequest(r), `query parameter "a" is repeated`)
+
+			r.URL.RawQuery = "a=1"
+			assert.NoError(t, s.SignRequest(r))
+			assert.NoError(t, v.VerifyRequest(r))
+			r.URL.RawQuery = "a=1&a=2"
+			assert.ErrorContains(t, v.VerifyRequest(r), `query parameter "a" is repeated`)
+		})
 	})
 	t.Run("derives @status component", func(t *testing.T) {
 		resp := &http.Response{
@@ -377,11 +766,13 @@ func TestCanonicaliseComponent_Request_Response_BoundComponents(t *testing.T) {
 		t.Run("lowercase", func(t *testing.T) {
 			r := req.Clone(req.Context())
 			r.Method = "get"
+			resp := *resp
+			resp.Request = r
 
-			c, err := canonicaliseComponent("@method", params, MessageFromResponse(resp))
+			c, err := canonicaliseComponent("@method", params, MessageFromResponse(&resp))
 			assert.NoError(t, err)
 
-			assert.Equal(t, []string{"GET"}, c)
+			assert.Equal(t, []string{"get"}, c)
 		})
 	})
 	t.Run("derives @target-uri component", func(t *testing.T) {
@@ -918,6 +1309,9 @@ func TestCanonocaliseHeaders(t *testing.T) {
 				"Cache-Control":     []string{"max-age=60", "   must-revalidate"},
 				"Example-Dict":      []string{" a=1,    b=2;x=1;y=2,   c=(a   b   c)"},
 				"X-Empty-Header":    []string{""},
+				"X-Tab-Header":      []string{"\t value\t "},
+				"X-Crlf-Fold":       []string{"Obsolete \r\n\t line\r\n  folding."},
+				"X-Inner-Space":     []string{"  keeps   inner\twhitespace "},
 			},
 			ContentLength: 18,
 		}
@@ -928,8 +1322,11 @@ func TestCanonocaliseHeaders(t *testing.T) {
 			"x-ows-header":      {"Leading and trailing whitespace."},
 			"x-obs-fold-header": {"Obsolete line folding."},
 			"cache-control":     {"max-age=60", "must-revalidate"},
-			"example-dict":      {"a=1, b=2;x=1;y=2, c=(a b c)"},
+			"example-dict":      {"a=1,    b=2;x=1;y=2,   c=(a   b   c)"},
 			"x-empty-header":    {""},
+			"x-tab-header":      {"value"},
+			"x-crlf-fold":       {"Obsolete line folding."},
+			"x-inner-space":     {"keeps   inner\twhitespace"},
 		} {
 			t.Run(fmt.Sprintf("extracts %s", key), func(t *testing.T) {
 				c, err := canonicaliseHeader(key, httpsfv.NewParams(), MessageFromRequest(req))
@@ -961,10 +1358,43 @@ func TestCanonocaliseHeaders(t *testing.T) {
 		t.Run("extracts example-dict", func(t *testing.T) {
 			params := httpsfv.NewParams()
 			params.Add("sf", true)
-			c, err := canonicaliseHeader("example-dict", httpsfv.NewParams(), MessageFromRequest(req))
+			c, err := canonicaliseHeader("example-dict", params, MessageFromRequest(req))
 			assert.NoError(t, err)
 			assert.Equal(t, []string{"a=1, b=2;x=1;y=2, c=(a b c)"}, c)
 		})
+		t.Run("combines multiple values of a list", func(t *testing.T) {
+			params := httpsfv.NewParams()
+			params.Add("sf", true)
+			c, err := canonicaliseHeader("cache-control", params, MessageFromRequest(req))
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"max-age=60, must-revalidate"}, c)
+		})
+		t.Run("normalises whitespace in dictionaries", func(t *testing.T) {
+			params := httpsfv.NewParams()
+			params.Add("sf", true)
+			for _, v := range []string{"max-age=60, public", "max-age=60,public", "  max-age=60 ,   public  "} {
+				r := req.Clone(req.Context())
+				r.Header.Set("Cache-Control", v)
+				c, err := canonicaliseHeader("cache-control", params, MessageFromRequest(r))
+				assert.NoError(t, err)
+				assert.Equal(t, []string{"max-age=60, public"}, c)
+			}
+		})
+		t.Run("normalises items", func(t *testing.T) {
+			params := httpsfv.NewParams()
+			params.Add("sf", true)
+			r := req.Clone(req.Context())
+			r.Header.Set("Example-Item", `  "value";a=1  `)
+			c, err := canonicaliseHeader("example-item", params, MessageFromRequest(r))
+			assert.NoError(t, err)
+			assert.Equal(t, []string{`"value";a=1`}, c)
+		})
+		t.Run("error on unparseable structured field", func(t *testing.T) {
+			params := httpsfv.NewParams()
+			params.Add("sf", true)
+			_, err := canonicaliseHeader("date", params, MessageFromRequest(req))
+			assert.Error(t, err)
+		})
 	})
 	t.Run("key from structured header", func(t *testing.T) {
 		req := &http.Request{
@@ -1005,6 +1435,48 @@ func TestCanonocaliseHeaders(t *testing.T) {
 			assert.NoError(t, err)
 			assert.Equal(t, []string{"(a b c)"}, c)
 		})
+		t.Run("extract from dictionary of bare keys", func(t *testing.T) {
+			params := httpsfv.NewParams()
+			params.Add("key", "b")
+			r := req.Clone(req.Context())
+			r.Header.Set("Example-Dict", "a, b;x=1")
+			c, err := canonicaliseHeader("example-dict", params, MessageFromRequest(r))
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"?1;x=1"}, c)
+		})
+		t.Run("extract from multiple header values", func(t *testing.T) {
+			params := httpsfv.NewParams()
+			params.Add("key", "y")
+			r := req.Clone(req.Context())
+			r.Header["Example-Dict"] = []string{"x=1", "y=2"}
+			c, err := canonicaliseHeader("example-dict", params, MessageFromRequest(r))
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"2"}, c)
+		})
+	})
+	t.Run("repeated headers", func(t *testing.T) {
+		req := &http.Request{
+			Method: "GET",
+			Host:   "example.com",
+			URL:    parse("https://example.com/path"),
+			Header: http.Header{
+				"Forwarded": []string{"for=192.0.2.60;proto=http  ", "  for=198.51.100.17"},
+			},
+		}
+		t.Run("combines all values", func(t *testing.T) {
+			c, err := createSignatureBase([]string{"forwarded"}, MessageFromRequest(req))
+			assert.NoError(t, err)
+			f, err := formatSignatureBase(c)
+			assert.NoError(t, err)
+			assert.Equal(t, `"forwarded": for=192.0.2.60;proto=http, for=198.51.100.17`, f)
+		})
+		t.Run("includes values stored under non-canonical keys", func(t *testing.T) {
+			r := req.Clone(req.Context())
+			r.Header["forwarded"] = []string{"for=203.0.113.43"}
+			c, err := canonicaliseHeader("forwarded", httpsfv.NewParams(), MessageFromRequest(r))
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"for=192.0.2.60;proto=http", "for=198.51.100.17", "for=203.0.113.43"}, c)
+		})
 	})
 	t.Run("bs from structured header", func(t *testing.T) {
 		req := &http.Request{
@@ -1034,6 +1506,16 @@ func TestCanonocaliseHeaders(t *testing.T) {
 			assert.NoError(t, err)
 			assert.Equal(t, []string{":dmFsdWUsIHdpdGgsIGxvdHMsIG9mLCBjb21tYXM=:"}, c)
 		})
+		t.Run("trims but keeps the bytes of each header", func(t *testing.T) {
+			params := httpsfv.NewParams()
+			params.Add("bs", true)
+			r := req.Clone(req.Context())
+			r.Header.Set("Example-Header", "\t value,with,   lots  ")
+
+			c, err := canonicaliseHeader("example-header", params, MessageFromRequest(r))
+			assert.NoError(t, err)
+			assert.Equal(t, []string{":" + base64.StdEncoding.EncodeToString([]byte("value,with,   lots")) + ":"}, c)
+		})
 	})
 	t.Run("request-response bound header", func(t *testing.T) {
 		/*
@@ -1080,6 +1562,54 @@ func TestCanonocaliseHeaders(t *testing.T) {
 			assert.Equal(t, []string{":LAH8BjcfcOcLojiuOBFWn0P5keD3xAOuJRGziCLuD8r5MW9S0RoXXLzLSRfGY/3SF8kVIkHjE13SEFdTo4Af/fJ/Pu9wheqoLVdwXyY/UkBIS1M8Brc8IODsn5DFIrG0IrburbLi0uCc+E2ZIIb6HbUJ+o+jP58JelMTe0QE3IpWINTEzpxjqDf5/Df+InHCAkQCTuKsamjWXUpyOT1Wkxi7YPVNOjW4MfNuTZ9HdbD2Tr65+BXeTG9ZS/9SWuXAc+BZ8WyPz0QRz//ec3uWXd7bYYODSjRAxHqX+S1ag3LZElYyUKaAIjZ8MGOt4gXEwCSLDv/zqxZeWLj/PDkn6w==:"}, c)
 		})
 	})
+	t.Run("trailer fields", func(t *testing.T) {
+		req := &http.Request{
+			Method: "GET",
+			Host:   "example.com",
+			URL:    parse("https://example.com/foo"),
+			Header: http.Header{},
+			Trailer: http.Header{
+				"Expires": []string{"Wed, 9 Nov 2022 07:28:00 GMT"},
+			},
+		}
+		resp := &http.Response{
+			StatusCode: 200,
+			Header: http.Header{
+				"Expires": []string{"Tue, 8 Nov 2022 07:28:00 GMT"},
+			},
+			Trailer: http.Header{
+				"Expires": []string{"Wed, 9 Nov 2022 07:28:00 GMT"},
+			},
+			Request: req,
+		}
+		params := httpsfv.NewParams()
+		params.Add("tr", true)
+
+		t.Run("extracts the trailer of a response", func(t *testing.T) {
+			c, err := canonicaliseHeader("expires", params, MessageFromResponse(resp))
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"Wed, 9 Nov 2022 07:28:00 GMT"}, c)
+
+			c, err = canonicaliseHeader("expires", httpsfv.NewParams(), MessageFromResponse(resp))
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"Tue, 8 Nov 2022 07:28:00 GMT"}, c)
+		})
+		t.Run("error on missing trailer", func(t *testing.T) {
+			resp := &http.Response{StatusCode: 200, Header: resp.Header, Request: req}
+			_, err := canonicaliseHeader("expires", params, MessageFromResponse(resp))
+			assert.EqualError(t, err, "header not found: expires")
+		})
+		t.Run("error for requests", func(t *testing.T) {
+			_, err := canonicaliseHeader("expires", params, MessageFromRequest(req))
+			assert.EqualError(t, err, "tr parameter not valid for requests")
+
+			params := httpsfv.NewParams()
+			params.Add("req", true)
+			params.Add("tr", true)
+			_, err = canonicaliseHeader("expires", params, MessageFromResponse(resp))
+			assert.EqualError(t, err, "tr parameter not valid for requests")
+		})
+	})
 }
 
 func TestCanonocaliseHeaders_ErrorConditions(t *testing.T) {
@@ -1213,7 +1743,7 @@ func TestCreateSignatureBase_Headers(t *testing.T) {
 					{httpsfv.NewItem("x-ows-header"), []string{"Leading and trailing whitespace."}},
 					{httpsfv.NewItem("x-obs-fold-header"), []string{"Obsolete line folding."}},
 					{httpsfv.NewItem("cache-control"), []string{"max-age=60", "must-revalidate"}},
-					{httpsfv.NewItem("example-dict"), []string{"a=1, b=2;x=1;y=2, c=(a b c), d"}},
+					{httpsfv.NewItem("example-dict"), []string{"a=1,    b=2;x=1;y=2,   c=(a   b   c), d"}},
 				},
 			},
 			{
@@ -1284,7 +1814,7 @@ func TestCreateSignatureBase_Headers(t *testing.T) {
 					{httpsfv.NewItem("x-ows-header"), []string{"Leading and trailing whitespace."}},
 					{httpsfv.NewItem("x-obs-fold-header"), []string{"Obsolete line folding."}},
 					{httpsfv.NewItem("cache-control"), []string{"max-age=60", "must-revalidate"}},
-					{httpsfv.NewItem("example-dict"), []string{"a=1, b=2;x=1;y=2, c=(a b c), d"}},
+					{httpsfv.NewItem("example-dict"), []string{"a=1,    b=2;x=1;y=2,   c=(a   b   c), d"}},
 				},
 			},
 			{
@@ -1304,7 +1834,7 @@ func TestCreateSignatureBase_Headers(t *testing.T) {
 					{httpsfv.NewItem("x-ows-header"), []string{"Leading and trailing whitespace."}},
 					{httpsfv.NewItem("x-obs-fold-header"), []string{"Obsolete line folding."}},
 					{httpsfv.NewItem("cache-control"), []string{"max-age=60", "must-revalidate"}},
-					{httpsfv.NewItem("example-dict"), []string{"a=1, b=2;x=1;y=2, c=(a b c), d"}},
+					{httpsfv.NewItem("example-dict"), []string{"a=1,    b=2;x=1;y=2,   c=(a   b   c), d"}},
 				},
 			},
 		} {
@@ -1317,6 +1847,46 @@ func TestCreateSignatureBase_Headers(t *testing.T) {
 	})
 }
 
+func TestCreateSignatureBase_CanonicalContentType(t *testing.T) {
+	base := func(t *testing.T, contentType string, canonical bool, field string) []string {
+		req := &http.Request{
+			Method: "POST",
+			URL:    parse("https://www.example.com/"),
+			Header: http.Header{"Content-Type": []string{contentType}},
+		}
+		items, err := createSignatureBaseWith([]string{field}, MessageFromRequest(req), baseOptions{canonicalContentType: canonical})
+		assert.NoError(t, err)
+		return items[0].value
+	}
+
+	t.Run("reformats variants of a media type alike", func(t *testing.T) {
+		for _, contentType := range []string{
+			"application/json; charset=utf-8",
+			"application/json;charset=UTF-8",
+			"Application/JSON ;  Charset=Utf-8",
+			"  application/json;charset=\"utf-8\"  ",
+		} {
+			assert.Equal(t, []string{"application/json; charset=utf-8"}, base(t, contentType, true, "content-type"), contentType)
+		}
+	})
+	t.Run("sorts parameters", func(t *testing.T) {
+		assert.Equal(t, []string{"multipart/form-data; boundary=abc; charset=utf-8"},
+			base(t, "multipart/form-data;charset=UTF-8;boundary=abc", true, "content-type"))
+	})
+	t.Run("keeps the case of other parameter values", func(t *testing.T) {
+		assert.Equal(t, []string{"multipart/form-data; boundary=AbC"}, base(t, "multipart/form-data; Boundary=AbC", true, "content-type"))
+	})
+	t.Run("keeps a value that isn't a media type", func(t *testing.T) {
+		assert.Equal(t, []string{"not a media type"}, base(t, "not a media type", true, "content-type"))
+	})
+	t.Run("keeps values unless configured", func(t *testing.T) {
+		assert.Equal(t, []string{"Application/JSON;charset=UTF-8"}, base(t, "Application/JSON;charset=UTF-8", false, "content-type"))
+	})
+	t.Run("keeps byte sequences", func(t *testing.T) {
+		assert.Equal(t, []string{":YXBwbGljYXRpb24vSlNPTg==:"}, base(t, "application/JSON", true, "content-type;bs"))
+	})
+}
+
 func TestCreateSignatureBase_DerivedComponents(t *testing.T) {
 	t.Run("derived components", func(t *testing.T) {
 		req := &http.Request{
@@ -1426,6 +1996,16 @@ func TestCreateSignatureBase_DerivedComponents(t *testing.T) {
 			{httpsfv.NewItem("@status"), []string{"200"}},
 		}, c)
 	})
+	t.Run("derived component case", func(t *testing.T) {
+		_, err := createSignatureBase([]string{"@Method"}, MessageFromRequest(testReq()))
+		assert.EqualError(t, err, "derived component name must be lowercase: @Method")
+
+		c, err := createSignatureBaseWith([]string{"@Method"}, MessageFromRequest(testReq()), baseOptions{lenientCase: true})
+		assert.NoError(t, err)
+		assert.Equal(t, []signatureItem{
+			{httpsfv.NewItem("@Method"), []string{"POST"}},
+		}, c)
+	})
 }
 
 func TestCreateSignatureBase_FullExample(t *testing.T) {
@@ -1582,3 +2162,142 @@ func TestFormatSignatureBase(t *testing.T) {
 		assert.Equal(t, `"example-dict";sf: a=1, b=2;x=1;y=2, c=(a b c)`, f)
 	})
 }
+
+func TestSignatureParams(t *testing.T) {
+	created := time.Unix(1618884473, 0)
+	expires := created.Add(5 * time.Minute)
+	keyID := `key "one" \ two`
+	alg := AlgorithmHmacSha256
+	nonce := "b3k2pp5k7z-50gnwp.yemd"
+	tag := "header-example"
+	extra := map[string]any{"raw": []byte{0, 1}, "mode": httpsfv.Token("fast"), "flag": true, "count": 3}
+	fields := []string{"date", `"@query-param";name="Pet"`, `"content-type";bs`}
+
+	// component identifiers are quoted strings with their parameters, string parameters are quoted
+	// and escaped, and created and expires are bare integers
+	expected := `("date" "@query-param";name="Pet" "content-type";bs);created=1618884473;expires=1618884773;keyid="key \"one\" \\ two";alg="hmac-sha256";nonce="b3k2pp5k7z-50gnwp.yemd";tag="header-example";count=3;flag;mode=fast;raw=:AAE=:`
+
+	t.Run("serialises the signature base parameters", func(t *testing.T) {
+		base, err := SignatureBase(MessageFromRequest(testReq()), SignatureInput{
+			SignatureParameters: SignatureParameters{Created: &created, Expires: &expires, KeyID: &keyID, Alg: &alg, Nonce: &nonce, Tag: &tag, Extra: extra},
+			Fields:              fields,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, `"date": Tue, 20 Apr 2021 02:07:55 GMT
+"@query-param";name="Pet": dog
+"content-type";bs: :YXBwbGljYXRpb24vanNvbg==:
+"@signature-params": `+expected, base)
+	})
+	t.Run("signs with the same serialisation", func(t *testing.T) {
+		opts := []signOption{
+			WithHmacSha256(keyID, []byte("secret")),
+			WithSignParams(ParamCreated, ParamExpires, ParamKeyID, ParamAlg, ParamNonce, ParamTag),
+			WithSignParamValues(&SignatureParameters{Created: &created, Expires: &expires, Nonce: &nonce, Tag: &tag}),
+			WithSignFields(fields...),
+		}
+		for name, value := range extra {
+			opts = append(opts, WithSignParam(name, value))
+		}
+		hdr, err := NewSigner(opts...).Sign(MessageFromRequest(testReq()))
+		assert.NoError(t, err)
+		assert.Equal(t, "sig="+expected, hdr.Get(SignatureInputHeader))
+
+		inputs, err := ParseSignatureHeaders(hdr)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{`"date"`, `"@query-param";name="Pet"`, `"content-type";bs`}, inputs[0].Fields)
+		assert.Equal(t, keyID, *inputs[0].KeyID)
+		assert.Equal(t, expires, *inputs[0].Expires)
+	})
+}
+
+func TestParseSignatureHeaders(t *testing.T) {
+	t.Run("describes every signature", func(t *testing.T) {
+		hdr := http.Header{}
+		hdr.Set("Signature-Input", `sig1=("@method" "@authority" "@path" "content-digest" "content-length" "content-type");created=1618884475;keyid="test-key-rsa-pss", proxy_sig=("@method" "@target-uri" "forwarded");created=1618884480;expires=1618884540;keyid="test-key-rsa";alg="rsa-v1_5-sha256";nonce="abc";tag="proxy"`)
+		hdr.Set("Signature", `sig1=:aGVsbG8=:, proxy_sig=:aGVsbG8=:`)
+
+		inputs, err := ParseSignatureHeaders(hdr)
+		assert.NoError(t, err)
+		assert.Len(t, inputs, 2)
+
+		assert.Equal(t, "sig1", inputs[0].Name)
+		assert.Equal(t, []string{`"@method"`, `"@authority"`, `"@path"`, `"content-digest"`, `"content-length"`, `"content-type"`}, inputs[0].Fields)
+		assert.Equal(t, "test-key-rsa-pss", *inputs[0].KeyID)
+		assert.Equal(t, int64(1618884475), inputs[0].Created.Unix())
+		assert.Nil(t, inputs[0].Alg)
+		assert.Nil(t, inputs[0].Expires)
+
+		assert.Equal(t, "proxy_sig", inputs[1].Name)
+		assert.Equal(t, []string{`"@method"`, `"@target-uri"`, `"forwarded"`}, inputs[1].Fields)
+		assert.Equal(t, "test-key-rsa", *inputs[1].KeyID)
+		assert.Equal(t, AlgorithmRsaPkcs1v15Sha256, *inputs[1].Alg)
+		assert.Equal(t, int64(1618884540), inputs[1].Expires.Unix())
+		assert.Equal(t, "abc", *inputs[1].Nonce)
+		assert.Equal(t, "proxy", *inputs[1].Tag)
+	})
+	t.Run("keeps component parameters", func(t *testing.T) {
+		hdr := http.Header{}
+		hdr.Set("Signature-Input", `sig1=("@query-param";name="Pet" "example-dict";key="a");keyid="test"`)
+
+		inputs, err := ParseSignatureHeaders(hdr)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{`"@query-param";name="Pet"`, `"example-dict";key="a"`}, inputs[0].Fields)
+	})
+	t.Run("error when not signed", func(t *testing.T) {
+		_, err := ParseSignatureHeaders(http.Header{})
+		assert.ErrorIs(t, err, ErrNoSignature)
+	})
+	t.Run("error on malformed input", func(t *testing.T) {
+		tooMany := `sig1=("@method")`
+		for i := 0; i <= maxSignatureParams; i++ {
+			tooMany += fmt.Sprintf(";p%d=1", i)
+		}
+		for _, v := range []string{
+			`sig1=:aGVsbG8=:`,
+			`sig1=("@method";created="yesterday"`,
+			`sig1=("@method");created="yesterday"`,
+			`sig1=("@method");keyid="test`,
+			`sig1=("@method");created=-1`,
+			`sig1=("@method");expires=-1618884475`,
+			tooMany,
+		} {
+			hdr := http.Header{}
+			hdr.Set("Signature-Input", v)
+			_, err := ParseSignatureHeaders(hdr)
+			assert.ErrorIs(t, err, ErrMalformedSignature, v)
+		}
+	})
+}
+
+func FuzzParseSignatureHeaders(f *testing.F) {
+	f.Add(`sig1=("@method" "@authority" "@path");created=1618884475;keyid="test-key-rsa-pss"`, `sig1=:aGVsbG8=:`)
+	f.Add(`sig1=("@query-param";name="Pet" "example-dict";key="a" "example-dict";sf);alg="hmac-sha256";tag="t"`, `sig1=:aGVsbG8=:`)
+	f.Add(`sig1=("@method";created="yesterday"`, `sig1=:aGVsbG8=`)
+	f.Add(`sig1=("@method");created=-1;expires=99999999999999999`, `sig1=aGVsbG8=:`)
+	f.Add(`sig1=(), sig2=("@status" 1 ?0 :aGVsbG8=:);nonce=""`, `sig1=::, sig2="x"`)
+
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		f.Fatal(err)
+	}
+	v := NewVerifier(WithHmacSha256("test-key-rsa-pss", k))
+	f.Fuzz(func(t *testing.T, input, signature string) {
+		hdr := http.Header{}
+		hdr.Set(SignatureInputHeader, input)
+		hdr.Set(SignatureHeader, signature)
+
+		inputs, err := ParseSignatureHeaders(hdr)
+		if err != nil {
+			assert.ErrorIs(t, err, ErrMalformedSignature)
+		}
+		for _, in := range inputs {
+			if in.Created != nil {
+				assert.GreaterOrEqual(t, in.Created.Unix(), int64(0))
+			}
+		}
+
+		msg := MessageFromRequest(testReq())
+		msg.Header = hdr
+		_ = v.Verify(msg)
+	})
+}
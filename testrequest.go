@@ -0,0 +1,88 @@
+// BSD 3-Clause License
+
+// Copyright (c) 2021, James Bowes
+// Copyright (c) 2023, Alexander Taraymovich, OffBlocks
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package httpsig
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net/http"
+)
+
+// NewVerifyTestRequest returns the request a server receives for the given client request, eg: a
+// request signed by a Signer, so that handlers and verifiers can be tested without a server. Like
+// a request built with httptest.NewRequest, its URL only has the path and query, the authority is
+// in its Host, and requests to https URLs have TLS set, so `@authority`, `@scheme` and
+// `@target-uri` are derived as for a real inbound request.
+//
+// The request is written and read back as it would be sent, so its headers, length and body are
+// those a server sees. The body of the client request is read, and replaced so it can still be
+// sent.
+func NewVerifyTestRequest(r *http.Request) (*http.Request, error) {
+	c, err := clientRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := c.Write(&buf); err != nil {
+		return nil, err
+	}
+
+	received, err := http.ReadRequest(bufio.NewReader(&buf))
+	if err != nil {
+		return nil, err
+	}
+	received.RemoteAddr = "192.0.2.1:1234"
+	if r.URL.Scheme == "https" {
+		received.TLS = &tls.ConnectionState{
+			Version:           tls.VersionTLS12,
+			HandshakeComplete: true,
+			ServerName:        received.Host,
+		}
+	}
+	return received.WithContext(r.Context()), nil
+}
+
+// clientRequest returns a copy of the request with its own copy of the body, reading the body of
+// the request in full and replacing it so it can still be read
+func clientRequest(r *http.Request) (*http.Request, error) {
+	body, err := readBody(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	c := r.Clone(r.Context())
+	if body != nil {
+		c.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return c, nil
+}
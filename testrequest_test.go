@@ -0,0 +1,108 @@
+// BSD 3-Clause License
+
+// Copyright (c) 2021, James Bowes
+// Copyright (c) 2023, Alexander Taraymovich, OffBlocks
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package httpsig
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewVerifyTestRequest(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	body := `{"hello": "world"}`
+	s := NewSigner(
+		WithHmacSha256("test-shared-secret", k),
+		WithSignFields("@method", "@authority", "@scheme", "@target-uri", "@request-target", "@path", "@query", "content-digest", "content-length"),
+	)
+	v := NewVerifier(WithHmacSha256("test-shared-secret", k))
+
+	for _, target := range []string{
+		"https://example.com/foo?a=b",
+		"http://example.com/foo?a=b",
+		"https://example.com:8443/foo",
+		"https://Example.com:443/foo",
+	} {
+		t.Run(target, func(t *testing.T) {
+			req, err := http.NewRequest("POST", target, strings.NewReader(body))
+			assert.NoError(t, err)
+			assert.NoError(t, s.SignRequest(req))
+
+			received, err := NewVerifyTestRequest(req)
+			assert.NoError(t, err)
+			assert.Empty(t, received.URL.Host)
+			assert.Equal(t, req.URL.Scheme == "https", received.TLS != nil)
+			assert.NoError(t, v.VerifyRequest(received))
+
+			// both requests can still be read
+			for _, r := range []*http.Request{req, received} {
+				read, err := io.ReadAll(r.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, body, string(read))
+			}
+		})
+	}
+	t.Run("request without a body", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "https://example.com/foo", nil)
+		assert.NoError(t, err)
+		assert.NoError(t, s.SignRequest(req))
+
+		received, err := NewVerifyTestRequest(req)
+		assert.NoError(t, err)
+		assert.NoError(t, v.VerifyRequest(received))
+	})
+	t.Run("verifies requests built with httptest", func(t *testing.T) {
+		for _, tt := range []struct{ client, server string }{
+			{"http://example.com/foo?a=b", "/foo?a=b"},
+			{"https://example.com/foo?a=b", "https://example.com/foo?a=b"},
+		} {
+			req, err := http.NewRequest("POST", tt.client, strings.NewReader(body))
+			assert.NoError(t, err)
+			assert.NoError(t, s.SignRequest(req))
+
+			received := httptest.NewRequest("POST", tt.server, strings.NewReader(body))
+			for name, values := range req.Header {
+				received.Header[name] = values
+			}
+			assert.NoError(t, v.VerifyRequest(received), tt.server)
+		}
+	})
+}
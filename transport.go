@@ -36,6 +36,9 @@ import "net/http"
 // NewSignTransport returns a new client transport that wraps the provided transport with
 // http message signing and body digest creation.
 //
+// Use `WithSignDigestAlgorithms` to choose the algorithms of the Content-Digest header added
+// to each request, and include `content-digest` in the signing fields to cover it.
+//
 // Use the various `WithSign*` option funcs to configure signature algorithms with their provided
 // key ids. You must provide at least one signing option. A signature for every provided key id is
 // included on each request. Multiple included signatures allow you to gracefully introduce stronger
@@ -44,15 +47,36 @@ func NewSignTransport(transport http.RoundTripper, opts ...signOption) http.Roun
 	s := NewSigner(opts...)
 
 	return rt(func(r *http.Request) (*http.Response, error) {
-		hdr, err := s.Sign(MessageFromRequest(r))
-		if err != nil {
+		// sign a copy, as round trippers mustn't modify the request
+		r = r.Clone(r.Context())
+		if err := s.SignRequest(r); err != nil {
 			return nil, err
 		}
-		r.Header = hdr
 		return transport.RoundTrip(r)
 	})
 }
 
+// NewSignClient returns a copy of the provided client, with its transport wrapped with http
+// message signing as by NewSignTransport. The timeout, cookie jar and redirect policy of the client
+// are kept. If the client is nil, http.DefaultClient is copied, and if it has no transport,
+// http.DefaultTransport is wrapped.
+//
+// Each request made to follow a redirect is signed for its own target.
+func NewSignClient(client *http.Client, opts ...signOption) *http.Client {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	c := *client
+
+	transport := c.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	c.Transport = NewSignTransport(transport, opts...)
+
+	return &c
+}
+
 type rt func(*http.Request) (*http.Response, error)
 
 func (r rt) RoundTrip(req *http.Request) (*http.Response, error) { return r(req) }
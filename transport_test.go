@@ -0,0 +1,228 @@
+// BSD 3-Clause License
+
+// Copyright (c) 2021, James Bowes
+// Copyright (c) 2023, Alexander Taraymovich, OffBlocks
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package httpsig
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSignClient(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	v := NewVerifier(WithHmacSha256("test-shared-secret", k), WithVerifyAll(true))
+
+	var paths []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/start", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/next", http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/next", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(body)
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inputs, err := ParseSignatureHeaders(r.Header)
+		assert.NoError(t, err)
+		assert.Len(t, inputs, 1, "a single signature is expected on each hop")
+		if err := v.VerifyRequest(r); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		paths = append(paths, r.URL.Path)
+		mux.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	base := &http.Client{Timeout: 5 * time.Second}
+	c := NewSignClient(base,
+		WithHmacSha256("test-shared-secret", k),
+		WithSignFields("@method", "@path", "content-digest"),
+	)
+	assert.Nil(t, base.Transport, "the base client must not be modified")
+	assert.Equal(t, base.Timeout, c.Timeout)
+
+	t.Run("signs each hop of a redirect for its target", func(t *testing.T) {
+		paths = nil
+		resp, err := c.Post(srv.URL+"/start", "application/json", bytes.NewBufferString(`{"hello": "world"}`))
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"hello": "world"}`, string(body))
+		assert.Equal(t, []string{"/start", "/next"}, paths)
+	})
+	t.Run("doesn't modify the request", func(t *testing.T) {
+		req, err := http.NewRequest("GET", srv.URL+"/next", nil)
+		assert.NoError(t, err)
+		resp, err := c.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, req.Header.Get(SignatureHeader))
+	})
+	t.Run("defaults to the default client", func(t *testing.T) {
+		c := NewSignClient(nil, WithHmacSha256("test-shared-secret", k))
+		assert.Nil(t, http.DefaultClient.Transport)
+
+		resp, err := c.Get(srv.URL + "/next")
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+}
+
+func TestNewSignTransport_IfAbsent(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	var sent *http.Request
+	base := rt(func(r *http.Request) (*http.Response, error) {
+		sent = r
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: r}, nil
+	})
+	signed := func(opts ...signOption) *http.Request {
+		req, err := http.NewRequest("GET", "https://example.com/foo", nil)
+		assert.NoError(t, err)
+		assert.NoError(t, NewSigner(append([]signOption{WithSignFields("@method")}, opts...)...).SignRequest(req))
+		return req
+	}
+	send := func(req *http.Request, opts ...signOption) http.Header {
+		resp, err := NewSignTransport(base, opts...).RoundTrip(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		return sent.Header
+	}
+
+	t.Run("resigns by default", func(t *testing.T) {
+		req := signed(WithHmacSha256("test-key", k))
+		hdr := send(req, WithHmacSha256("test-key", k), WithSignFields("@method", "@path"))
+
+		inputs, err := ParseSignatureHeaders(hdr)
+		assert.NoError(t, err)
+		if assert.Len(t, inputs, 2) {
+			assert.Equal(t, []string{`"@method"`, `"@path"`}, inputs[1].Fields)
+		}
+	})
+	t.Run("leaves a request signed with the name and key id", func(t *testing.T) {
+		req := signed(WithHmacSha256("test-key", k))
+		hdr := send(req, WithHmacSha256("test-key", k), WithSignFields("@method", "@path"), WithSignIfAbsent(true))
+
+		assert.Equal(t, req.Header, hdr)
+	})
+	t.Run("signs a request signed with another name", func(t *testing.T) {
+		req := signed(WithHmacSha256("test-key", k), WithSignName("other"))
+		hdr := send(req, WithHmacSha256("test-key", k), WithSignIfAbsent(true))
+
+		inputs, err := ParseSignatureHeaders(hdr)
+		assert.NoError(t, err)
+		assert.Len(t, inputs, 2)
+	})
+	t.Run("signs a request signed with another key id", func(t *testing.T) {
+		req := signed(WithHmacSha256("other-key", k))
+		hdr := send(req, WithHmacSha256("test-key", k), WithSignIfAbsent(true))
+
+		inputs, err := ParseSignatureHeaders(hdr)
+		assert.NoError(t, err)
+		if assert.Len(t, inputs, 2) {
+			assert.Equal(t, "test-key", *inputs[1].KeyID)
+		}
+	})
+}
+
+func TestNewSignTransport_SkipDigestMethods(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	var sent *http.Request
+	base := rt(func(r *http.Request) (*http.Response, error) {
+		sent = r
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: r}, nil
+	})
+	transport := NewSignTransport(base,
+		WithHmacSha256("test-shared-secret", k),
+		WithSignFields("@method", "@path", "@authority", "content-digest"),
+		WithSignSkipDigestMethods("GET", "HEAD"),
+	)
+	v := NewVerifier(WithHmacSha256("test-shared-secret", k))
+	send := func(t *testing.T, method string, body io.Reader) *http.Request {
+		req, err := http.NewRequest(method, "https://example.com/foo", body)
+		assert.NoError(t, err)
+		resp, err := transport.RoundTrip(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.NoError(t, v.VerifyRequest(sent))
+		return sent
+	}
+
+	for _, method := range []string{"GET", "HEAD"} {
+		t.Run("signs a "+method+" request without a digest", func(t *testing.T) {
+			req := send(t, method, nil)
+			assert.Empty(t, req.Header.Values(ContentDigestHeader))
+
+			inputs, err := ParseSignatureHeaders(req.Header)
+			assert.NoError(t, err)
+			if assert.Len(t, inputs, 1) {
+				assert.Equal(t, []string{`"@method"`, `"@path"`, `"@authority"`}, inputs[0].Fields)
+			}
+		})
+	}
+	t.Run("digests requests with other methods", func(t *testing.T) {
+		for _, body := range []io.Reader{nil, bytes.NewBufferString(`{"hello": "world"}`)} {
+			req := send(t, "POST", body)
+			assert.NotEmpty(t, req.Header.Get(ContentDigestHeader))
+
+			inputs, err := ParseSignatureHeaders(req.Header)
+			assert.NoError(t, err)
+			if assert.Len(t, inputs, 1) {
+				assert.Contains(t, inputs[0].Fields, `"content-digest"`)
+			}
+		}
+	})
+}
@@ -36,13 +36,20 @@ import (
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/hmac"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/sha512"
 	"errors"
+	"fmt"
+	"hash"
+	"io"
 	"math/big"
+	"net/http"
+	"net/url"
 	"slices"
+	"strings"
 	"time"
 
 	"github.com/dunglas/httpsfv"
@@ -50,12 +57,31 @@ import (
 
 // VerifyConfig is the configuration for a verifier
 type VerifyConfig struct {
-	// The keys to use for signing
-	Keys map[string]VerifyingKey
+	// The keys to use for verifying, by key id. A key id can have a key for each algorithm, and
+	// signatures are verified with the key matching their algorithm. Registering a key replaces
+	// any key with the same key id and algorithm.
+	Keys map[string][]VerifyingKey
 
 	// Resolver for verifying keys
 	KeyResolver VerifyingKeyResolver
 
+	// Selects the key to verify each signature with from the whole message and the signature
+	// input, eg: by a tenant in the path when key ids are only unique per tenant. It takes
+	// precedence over Keys and KeyResolver. A nil key means the key id is unknown. For signatures
+	// verified with VerifyDetached, it is given an empty message and an input without fields.
+	// Default: nil
+	KeyFunc func(msg *Message, input SignatureInput) (VerifyingKey, error)
+
+	// Treat errors resolving keys, from KeyResolver or KeyFunc, as unknown key ids rather than
+	// failing verification with them wrapped in ErrKeyResolution, so another signature may still
+	// be verified
+	// Default: false (verification fails closed)
+	ResolverErrorsAsUnknown bool
+
+	// The key id to verify signatures without a `keyid` parameter with
+	// Default: "" (signatures without a key id are only verified by a VerifyingKeyTagResolver)
+	DefaultKeyID string
+
 	// A date that the signature can't have been marked as `created` after
 	// Default: time.Now() + tolerance
 	NotAfter *time.Time
@@ -64,6 +90,10 @@ type VerifyConfig struct {
 	// signature (unless the expires age is less than the maxAge specified) if provided
 	MaxAge *time.Duration
 
+	// Reject signatures without a created parameter, whose age can't be checked against MaxAge
+	// Default: false
+	RequireCreated bool
+
 	// A clock tolerance when verifying created/expires times
 	// Default: 0
 	Tolerance *time.Duration
@@ -76,10 +106,123 @@ type VerifyConfig struct {
 	// Default: []
 	RequiredFields []string
 
-	// Verify every signature in the request. By default, only 1 signature will need to be valid
-	// for the verification to pass.
+	// HTTP fields that signatures must cover when the message has them, eg: Idempotency-Key, but
+	// that may be absent. Signatures of messages with one of these fields that don't cover it are
+	// rejected with ErrMalformedSignature.
+	// Default: []
+	OptionalFields []string
+
+	// Resolvers of application specific components, by lowercase name. Signatures covering a
+	// component with one of these names use the value it resolves for the message.
+	// Default: nil
+	Components map[string]func(msg *Message) (string, error)
+
+	// Accept signatures covering derived components whose names aren't lowercase, eg: `@Method`,
+	// from non-conformant signers. The names are resolved as if they were lowercase, but are kept
+	// as they were received in the signature base.
+	// Default: false (such signatures are rejected)
+	LenientComponentCase bool
+
+	// Verify the content-type field with its media type reformatted canonically, for signatures
+	// by signers canonicalising it too
+	// Default: false
+	CanonicalContentType bool
+
+	// The algorithms signatures may use. Signatures declaring, or verified by a key using, any
+	// other algorithm are rejected.
+	// Default: [] (all supported algorithms are allowed)
+	AllowedAlgorithms []Algorithm
+
+	// Reject ECDSA signatures with a high s value, ie: greater than half the order of the curve.
+	// For any valid ECDSA signature, another valid signature can be made by negating s, so
+	// requiring the low form makes signatures unique, eg: for deduplicating by signature.
+	// Default: false
+	RequireLowS bool
+
+	// Verify signatures with parameters other than created, expires, nonce, alg, keyid, tag and
+	// ExtraParams, rather than rejecting them. Unknown parameters are still covered by the signature.
+	// Default: false
+	LenientParams bool
+
+	// Names of parameters other than those defined by RFC 9421 that signatures are expected to
+	// carry, which are accepted without LenientParams
+	// Default: []
+	ExtraParams []string
+
+	// The most signatures a message may have. Messages with more members in either the Signature
+	// or Signature-Input header fail with ErrTooManySignatures before any are verified, bounding
+	// the work an attacker can cause with a single message.
+	// A negative limit allows any number of signatures.
+	// Default: 8
+	MaxSignatures int
+
+	// Verify every signature in the request. By default, signatures with unknown key ids are
+	// skipped and only the first with a known key id is verified, so signatures with keys the
+	// verifier doesn't know yet can be added alongside the others. Messages without a signature
+	// with a known key id fail with ErrUnknownKeyID.
 	// Default: false
 	All bool
+
+	// The digest algorithms a Content-Digest header may be verified with. Only digests using these
+	// algorithms are checked, and a header with none fails with ErrDigestAlgorithmNotAllowed.
+	// Default: [] (all supported digest algorithms are allowed)
+	DigestAlgorithms []DigestAlgorithm
+
+	// Verify the signatures of requests before reading the body, then verify the body against the
+	// digest as it is read, rather than reading it in full first. A body that doesn't match is
+	// reported by Read in place of io.EOF, so it must be read to the end before being trusted.
+	// Default: false
+	BeforeBody bool
+
+	// Reject requests with a digest header that no verified signature covers with
+	// ErrUnsignedDigest, rather than ignoring the header. A covered digest header must always be
+	// present and match the body.
+	// Default: false (unsigned digests are ignored)
+	RejectUnsignedDigest bool
+
+	// Media types of request bodies that must be protected by a digest, eg: application/json.
+	// Requests with these content types fail with ErrDigestRequired without a digest header, and
+	// with ErrUnsignedDigest if no verified signature covers it. Media types are matched without
+	// their parameters and regardless of case.
+	// Default: [] (no content type requires a digest)
+	DigestContentTypes []string
+
+	// The header digests of request bodies are verified from, either Content-Digest or Repr-Digest
+	// Default: Content-Digest
+	DigestHeader string
+
+	// Take the scheme and authority of requests from the Forwarded, X-Forwarded-Proto and
+	// X-Forwarded-Host headers set by a reverse proxy, rather than from the request received. Only
+	// enable this behind a proxy that sets or strips these headers, as clients could otherwise
+	// spoof them.
+	// Default: false
+	TrustProxyHeaders bool
+
+	// The largest request body, in bytes, read in full to verify its Content-Digest header.
+	// Verifying requests with larger bodies fails with ErrBodyTooLarge.
+	// Default: 0 (no limit)
+	BodyBufferLimit int64
+
+	// Verify signatures using the format of draft-cavage-http-signatures-12 rather than RFC 9421,
+	// for peers that only support the older draft. Required fields are named as in the draft.
+	// Default: false
+	Cavage bool
+
+	// Called once for every message verified, with the outcome of verifying it
+	// Default: nil
+	Observer func(ev VerifyEvent)
+
+	// Chooses the one signature to verify, by name, from the signatures declared by the message.
+	// Only the chosen signature is verified and it must be valid, regardless of All. Verification
+	// fails with ErrNoSignature if no signature is chosen.
+	// Default: nil (signatures are verified as described by All)
+	SignatureSelector func(inputs []SignatureInput) (name string, ok bool)
+
+	// HTTP fields whose values are masked in signature bases returned by Verifier.SignatureBase,
+	// eg: `authorization`, so logging a base doesn't disclose a credential it covers. Signatures
+	// are always verified with the real values.
+	// Default: none
+	RedactedFields []string
 }
 
 // VerifyingKey is the key to use for verifying a signature
@@ -90,10 +233,67 @@ type VerifyingKey interface {
 }
 
 // VerifyingKeyResolver is used to resolve a key id to a verifying key
+//
+// Resolve is given the context of the message being verified (see VerifyRequestContext), so
+// resolvers doing network lookups should honour its cancellation and deadline. Any error returned
+// fails the verification, wrapped in ErrKeyResolution, unless configured with
+// WithVerifyResolverErrorFailsClosed(false). Return a nil key for unknown key ids.
+//
+// Resolvers running background work, eg: refreshing or evicting cached keys, should implement
+// io.Closer to stop it, with Close returning once it has stopped. Verifiers never close their
+// resolver, so it may be shared, and whoever created it closes it once no longer used.
 type VerifyingKeyResolver interface {
 	Resolve(ctx context.Context, keyID string) (VerifyingKey, error)
 }
 
+// VerifyingKeyTagResolver is a VerifyingKeyResolver that can also resolve the key for a signature
+// without a key id from its tag, for peers that agree keys out of band
+//
+// ResolveTag is only used for signatures with a tag but no key id, when no default key id is
+// configured (see WithVerifyDefaultKeyID). Signatures with neither fail to verify.
+type VerifyingKeyTagResolver interface {
+	VerifyingKeyResolver
+	ResolveTag(ctx context.Context, tag string) (VerifyingKey, error)
+}
+
+// MultiVerifyingKeyResolver is a VerifyingKeyResolver that can resolve a key id to several keys,
+// eg: both the old and new key while the key for a key id is rotated
+//
+// The verifier uses ResolveKeys in place of Resolve. The keys are tried in the order returned and
+// the signature is accepted as soon as one of them verifies it, so the first that verifies wins.
+// A plain VerifyingKeyResolver is treated as resolving a key id to the single key it returns.
+type MultiVerifyingKeyResolver interface {
+	VerifyingKeyResolver
+	ResolveKeys(ctx context.Context, keyID string) ([]VerifyingKey, error)
+}
+
+// singleKeyResolver adapts a VerifyingKeyResolver to resolve a key id to the single key it returns
+type singleKeyResolver struct {
+	VerifyingKeyResolver
+}
+
+func (r singleKeyResolver) ResolveKeys(ctx context.Context, keyID string) ([]VerifyingKey, error) {
+	key, err := r.Resolve(ctx, keyID)
+	if err != nil || key == nil {
+		return nil, err
+	}
+	return []VerifyingKey{key}, nil
+}
+
+// multiKeyResolver returns the resolver as a MultiVerifyingKeyResolver
+func multiKeyResolver(resolver VerifyingKeyResolver) MultiVerifyingKeyResolver {
+	if multi, ok := resolver.(MultiVerifyingKeyResolver); ok {
+		return multi
+	}
+	return singleKeyResolver{resolver}
+}
+
+// Verifier verifies the signatures of HTTP messages.
+//
+// A Verifier is safe for concurrent use by multiple goroutines. Its configuration is fixed when it
+// is created and verification never modifies it, so a single Verifier can be shared, eg: by every
+// request handled by a middleware returned by NewVerifyMiddleware. Any VerifyingKeyResolver
+// configured must itself be safe for concurrent use.
 type Verifier struct {
 	*verifier
 }
@@ -105,20 +305,112 @@ type Verifier struct {
 func NewVerifier(opts ...verifyOption) *Verifier {
 	v := verifier{}
 
-	v.config.Keys = make(map[string]VerifyingKey)
+	v.config.Keys = make(map[string][]VerifyingKey)
 
 	for _, o := range opts {
 		o.configureVerify(&v)
 	}
 
+	// take copies so callers can't change the configuration from under us
+	v.config.RequiredParams = slices.Clone(v.config.RequiredParams)
+	v.config.RequiredFields = slices.Clone(v.config.RequiredFields)
+	v.config.AllowedAlgorithms = slices.Clone(v.config.AllowedAlgorithms)
+	v.config.DigestAlgorithms = slices.Clone(v.config.DigestAlgorithms)
+	v.config.ExtraParams = slices.Clone(v.config.ExtraParams)
+	v.config.RedactedFields = slices.Clone(v.config.RedactedFields)
+	for i, f := range v.config.RedactedFields {
+		v.config.RedactedFields[i] = strings.ToLower(f)
+	}
+	if v.config.DigestHeader == "" {
+		v.config.DigestHeader = ContentDigestHeader
+	}
+	if v.config.MaxSignatures == 0 {
+		v.config.MaxSignatures = defaultMaxSignatures
+	}
+	v.accepts = slices.Clone(v.accepts)
+
 	return &Verifier{&v}
 }
 
-// Verify verifies the given message
+// defaultMaxSignatures is the most signatures a message may have unless configured otherwise, more
+// than enough for a message signed by each party it passes through
+const defaultMaxSignatures = 8
+
+// Verify verifies the given message. It doesn't depend on net/http, so messages built with
+// NewMessage for other transports can be verified too. Bodies aren't verified: a covered
+// Content-Digest header is verified as a header only, eg: the digest of the representation a GET
+// would return, carried by the response to a HEAD request.
 func (v *Verifier) Verify(m *Message) error {
 	return v.verifier.Verify(m)
 }
 
+// VerifyRequest verifies the signatures of the given request and, if a signature covers its
+// Content-Digest header, verifies the digest of its body. Every digest algorithm present that is
+// supported is checked. The body is read in full and replaced so it can still be read by handlers,
+// unless WithVerifyBeforeBody is set. The body is left untouched when the digest isn't covered, and
+// for HEAD requests, whose covered digest is verified as a header only.
+func (v *Verifier) VerifyRequest(r *http.Request) error {
+	return v.verifier.VerifyRequest(r)
+}
+
+// VerifyRequestContext is like VerifyRequest, but verifies using the given context rather than
+// the context of the request, eg: when resolving keys.
+func (v *Verifier) VerifyRequestContext(ctx context.Context, r *http.Request) error {
+	return v.verifier.VerifyRequestContext(ctx, r)
+}
+
+// VerifyRequestResult is like VerifyRequest, but also describes what verification established:
+// the signatures verified, with their parameters and covered fields, and whether the body of the
+// request was protected, eg: so that handlers can make sure it was before trusting it.
+func (v *Verifier) VerifyRequestResult(r *http.Request) (VerificationResult, error) {
+	return v.verifier.verifyRequest(r.Context(), r)
+}
+
+// VerificationResult describes what verifying a request established, about its body and the
+// signatures verified, as returned by VerifyRequestResult and VerificationResultFromContext
+type VerificationResult struct {
+	// The signatures verified, as declared by the Signature-Input header, in the order they were
+	// verified: only the first with a known key id unless WithVerifyAll is set. Signatures verified
+	// with WithCavageCompat have no name.
+	Signatures []SignatureInput
+
+	// Whether a verified signature covers the digest header of the request, so that the body is
+	// protected if the header is present
+	BodyCovered bool
+
+	// Whether the body was checked against the digest header of the request and matched. With
+	// WithVerifyBeforeBody, the body is checked as it is read, failing the read if it doesn't match.
+	DigestVerified bool
+}
+
+type verificationResultKey struct{}
+
+// VerificationResultFromContext returns the result of verifying the request the given context
+// belongs to, set by the middleware returned by NewVerifyMiddleware, and whether there was one
+func VerificationResultFromContext(ctx context.Context) (VerificationResult, bool) {
+	result, ok := ctx.Value(verificationResultKey{}).(VerificationResult)
+	return result, ok
+}
+
+// VerifyDetached verifies a signature made over the given signature base, rather than creating
+// the base from a message, eg: to reproduce a failing verification from a captured base, or for
+// protocols sending the base separately. The keys, algorithms and times of the signature are
+// checked as for messages, using the given parameters of the signature, but the fields and
+// parameters the base covers are not. The name is only passed to the observer and any key func,
+// which is given an empty message. It returns the key id of the key that verified the signature.
+func (v *Verifier) VerifyDetached(base []byte, name string, signature []byte, params SignatureParameters) (string, error) {
+	return v.verifier.VerifyDetached(base, name, signature, params)
+}
+
+// SignatureBase returns the signature base the verifier creates for the signature with the given
+// name, exactly as it is verified, without verifying the signature. Comparing it with the base
+// the signer created is the quickest way to find why a signature fails to verify, eg: due to a
+// difference in canonicalisation. In Cavage compatibility mode the name is ignored and the signing
+// string is returned. The values of fields set with WithVerifyRedactedFields are masked.
+func (v *Verifier) SignatureBase(m *Message, name string) (string, error) {
+	return v.verifier.SignatureBase(m, name)
+}
+
 type clock interface {
 	Now() time.Time
 }
@@ -126,183 +418,860 @@ type clock interface {
 type verifier struct {
 	config VerifyConfig
 
+	// signs responses in middleware
+	responseSigner *Signer
+
+	// challenges clients in middleware, with the given signatures if any
+	challenge bool
+	accepts   []AcceptSignature
+
+	// responds to requests failing verification in middleware
+	errorHandler func(rw http.ResponseWriter, r *http.Request, err error)
+
+	// a configuration error reported on use, as the constructor returns none
+	err error
+
 	// for testing
 	clock clock
 }
 
-// XXX: note about fail fast.
+func (v *verifier) VerifyRequest(r *http.Request) error {
+	return v.VerifyRequestContext(r.Context(), r)
+}
+
+func (v *verifier) VerifyRequestContext(ctx context.Context, r *http.Request) error {
+	_, err := v.verifyRequest(ctx, r)
+	return err
+}
+
+func (v *verifier) verifyRequest(ctx context.Context, r *http.Request) (VerificationResult, error) {
+	msg := MessageFromRequest(r)
+	msg.Context = ctx
+
+	var result VerificationResult
+	err := v.observe(func(ev *VerifyEvent) error {
+		if err := v.verifyCovering(msg, ev, &result.Signatures); err != nil {
+			return err
+		}
+		var covered []string
+		for _, input := range result.Signatures {
+			covered = append(covered, input.Fields...)
+		}
+		result.BodyCovered = coversField(covered, v.config.DigestHeader)
+		required := v.requiresDigest(r)
+		if required && !hasHeader(r.Header, v.config.DigestHeader) {
+			return ErrDigestRequired
+		}
+		if hasHeader(r.Header, v.config.DigestHeader) && !result.BodyCovered && (v.config.RejectUnsignedDigest || required) {
+			return ErrUnsignedDigest
+		}
+		if !v.config.Cavage && !result.BodyCovered {
+			// an unsigned digest proves nothing about the body, so leave it untouched
+			return nil
+		}
+		if r.Method == http.MethodHead {
+			// HEAD requests have no content, so their digest is only verified as a header
+			return nil
+		}
+
+		var err error
+		result.DigestVerified, err = v.verifyDigest(r)
+		return err
+	})
+	if err != nil {
+		return VerificationResult{}, err
+	}
+	return result, nil
+}
+
+// requiresDigest reports whether the content type of the request is one whose body must be
+// protected by a digest
+func (v *verifier) requiresDigest(r *http.Request) bool {
+	if len(v.config.DigestContentTypes) == 0 {
+		return false
+	}
+	// compare the media type however its parameters are written, so a malformed parameter can't
+	// avoid the requirement
+	mediaType, _, _ := strings.Cut(r.Header.Get("Content-Type"), ";")
+	mediaType = strings.TrimSpace(mediaType)
+	return slices.ContainsFunc(v.config.DigestContentTypes, func(t string) bool {
+		return strings.EqualFold(t, mediaType)
+	})
+}
+
+// coversField reports whether any of the given covered components is the named HTTP field
+func coversField(covered []string, name string) bool {
+	field := quoteString(strings.ToLower(name))
+	return slices.ContainsFunc(covered, func(c string) bool {
+		return c == field || strings.HasPrefix(c, field+";")
+	})
+}
+
+// verifyDigest verifies the body of the request against its digest header, if any, reporting
+// whether there was one
+func (v *verifier) verifyDigest(r *http.Request) (bool, error) {
+	if !hasHeader(r.Header, v.config.DigestHeader) {
+		return false, nil
+	}
+
+	algorithms := v.config.DigestAlgorithms
+	if len(algorithms) == 0 {
+		algorithms = supportedDigestAlgorithms()
+	}
+	d := NewDigestor(WithDigestAlgorithms(algorithms...), WithDigestHeader(v.config.DigestHeader))
+
+	var err error
+	if v.config.BeforeBody && r.Body != nil && r.Body != http.NoBody {
+		// verify the body as it is read rather than reading it now
+		var body io.ReadCloser
+		if body, err = d.VerifyReader(r.Body, r.Header); err == nil {
+			r.Body = body
+		}
+	} else {
+		var body []byte
+		if body, err = v.requestBody(r); err == nil {
+			err = d.Verify(body, r.Header)
+		}
+	}
+
+	if errors.Is(err, errNoDigestAlgorithm) && len(v.config.DigestAlgorithms) > 0 {
+		return false, ErrDigestAlgorithmNotAllowed
+	}
+	return err == nil, err
+}
+
+// requestBody reads the body of the request to verify its digest against. A body found empty
+// despite the Content-Length of the request was read already, eg: by a middleware that ran before
+// verification, so is read again from GetBody if set, or fails with ErrBodyConsumed otherwise.
+// Either way, the body is replaced with the bytes verified for the handler to read.
+func (v *verifier) requestBody(r *http.Request) ([]byte, error) {
+	getBody := r.GetBody
+	body, err := readBody(r, v.config.BodyBufferLimit)
+	if err != nil || len(body) > 0 || r.ContentLength <= 0 {
+		return body, err
+	}
+
+	if getBody != nil {
+		body, err = readGetBody(r, getBody, v.config.BodyBufferLimit)
+	}
+	if err == nil && len(body) == 0 {
+		err = ErrBodyConsumed
+	}
+	return body, err
+}
+
 func (v *verifier) Verify(msg *Message) error {
+	return v.observe(func(ev *VerifyEvent) error {
+		return v.verify(msg, ev)
+	})
+}
+
+// observe runs the given verification, passing its outcome to any configured observer
+func (v *verifier) observe(verify func(ev *VerifyEvent) error) error {
+	if v.err != nil {
+		return v.err
+	}
+	if v.config.Observer == nil {
+		return verify(&VerifyEvent{})
+	}
+
+	start := time.Now()
+	ev := VerifyEvent{}
+	ev.Err = verify(&ev)
+	ev.Duration = time.Since(start)
+	v.config.Observer(ev)
+
+	return ev.Err
+}
+
+// XXX: note about fail fast.
+func (v *verifier) verify(msg *Message, ev *VerifyEvent) error {
+	return v.verifyCovering(msg, ev, nil)
+}
+
+// verifyCovering verifies the message, appending each signature verified to verified if it isn't
+// nil
+func (v *verifier) verifyCovering(msg *Message, ev *VerifyEvent, verified *[]SignatureInput) error {
+	if v.config.TrustProxyHeaders {
+		msg = forwardedMessage(msg)
+	}
+	if v.config.Cavage {
+		return v.verifyCavage(msg, ev, verified)
+	}
+
 	signatureHeader, ok := msg.Header[SignatureHeader]
 	if !ok {
-		return errNotSigned
+		return ErrNoSignature
 	}
 	inputHeader, ok := msg.Header[SignatureInputHeader]
 	if !ok {
-		return errNotSigned
+		return ErrNoSignature
 	}
 
+	// every line of each header is combined, as some peers send each signature on its own line
 	signatureHeaderDict, err := httpsfv.UnmarshalDictionary(signatureHeader)
 	if err != nil {
-		return err
+		return malformedSignature(err)
 	}
 	inputHeaderDict, err := httpsfv.UnmarshalDictionary(inputHeader)
 	if err != nil {
-		return err
+		return malformedSignature(err)
 	}
 
 	// no signatures means an indeterminate result
 	if len(signatureHeaderDict.Names()) == 0 && len(inputHeaderDict.Names()) == 0 {
-		return errNotSigned
+		return ErrNoSignature
+	}
+
+	// either header may carry the excess members, so both are bounded
+	count := max(len(signatureHeaderDict.Names()), len(inputHeaderDict.Names()))
+	if v.config.MaxSignatures >= 0 && count > v.config.MaxSignatures {
+		return ErrTooManySignatures
 	}
 
 	// a missing header means we can't verify the signatures
 	if len(signatureHeaderDict.Names()) != len(inputHeaderDict.Names()) {
-		return errNotSigned
+		return ErrNoSignature
 	}
 
-	var now time.Time
-	if v.clock != nil {
-		now = v.clock.Now()
-	} else {
-		now = time.Now()
-	}
-	var tolerance time.Duration
-	if v.config.Tolerance != nil {
-		tolerance = *v.config.Tolerance
-	} else {
-		tolerance = 0
-	}
-	var notAfter time.Time
-	if v.config.NotAfter != nil {
-		notAfter = *v.config.NotAfter
-	} else {
-		notAfter = now.Add(tolerance)
-	}
-	var maxAge *time.Duration
-	if v.config.MaxAge != nil {
-		maxAge = v.config.MaxAge
+	names := signatureHeaderDict.Names()
+	var expired error
+	selected := v.config.SignatureSelector != nil
+	var inputs []SignatureInput
+	if selected {
+		inputs, err = signatureInputs(inputHeaderDict)
+		if err != nil {
+			return err
+		}
+		name, ok := v.config.SignatureSelector(inputs)
+		if !ok {
+			return ErrNoSignature
+		}
+		if _, ok := inputHeaderDict.Get(name); !ok {
+			return ErrNoSignature
+		}
+		names = []string{name}
 	}
 
-	for _, name := range signatureHeaderDict.Names() {
+	// signatures commonly cover the same components, so each is only canonicalised once
+	items := make(map[string]signatureItem)
+	for _, name := range names {
 		sigItem, ok := signatureHeaderDict.Get(name)
 		if !ok {
-			return errMalformedSignature
+			return ErrMalformedSignature
 		}
 		sigInputItem, ok := inputHeaderDict.Get(name)
 		if !ok {
-			return errMalformedSignature
+			return ErrMalformedSignature
 		}
 		signature, ok := sigItem.(httpsfv.Item)
 		if !ok {
-			return errMalformedSignature
+			return ErrMalformedSignature
 		}
 		signatureBytes, ok := signature.Value.([]byte)
 		if !ok {
-			return errMalformedSignature
+			return ErrMalformedSignature
 		}
-		signatureInput, ok := sigInputItem.(httpsfv.InnerList)
+		signatureInputList, ok := sigInputItem.(httpsfv.InnerList)
 		if !ok {
-			return errMalformedSignature
+			return ErrMalformedSignature
 		}
 
-		signatureParams, err := parseParams(signatureInput.Params)
-		if err != nil {
+		// the input of a selected signature was parsed already to select it
+		i := slices.IndexFunc(inputs, func(input SignatureInput) bool { return input.Name == name })
+		var input SignatureInput
+		if i >= 0 {
+			input = inputs[i]
+		} else if input, err = signatureInput(name, signatureInputList); err != nil {
 			return err
 		}
-
-		var fields []string
-		for _, item := range signatureInput.Items {
-			marshalled, err := httpsfv.Marshal(item)
-			if err != nil {
-				return err
+		signatureParams, fields := &input.SignatureParameters, input.Fields
+		if !v.config.LenientParams {
+			if param, ok := unknownParam(signatureInputList.Params, v.config.ExtraParams); ok {
+				return malformedSignature(fmt.Errorf("unknown parameter %q", param))
 			}
-			fields = append(fields, marshalled)
 		}
 
-		var key VerifyingKey
-		key, ok = v.config.Keys[*signatureParams.KeyID]
-		if !ok && v.config.KeyResolver != nil {
-			if signatureParams.KeyID == nil {
-				return errMalformedSignature
-			}
-			key, err = v.config.KeyResolver.Resolve(msg.Context, *signatureParams.KeyID)
-			if err != nil {
-				return err
-			}
+		*ev = VerifyEvent{Name: name, Extra: signatureParams.Extra}
+		if signatureParams.KeyID != nil {
+			ev.KeyID = *signatureParams.KeyID
 		}
-		if v.config.All && key == nil {
-			return errUnknownKey
+		if signatureParams.Alg != nil {
+			ev.Algorithm = *signatureParams.Alg
 		}
-		if key == nil {
+
+		keys, err := v.keysForInput(msg, input)
+		if errors.Is(err, ErrKeyExpired) && !v.config.All && !selected {
+			// a signature with a key still accepted may follow, eg: during a key rotation
+			expired = err
 			continue
 		}
-
-		if signatureParams.Alg != nil && key.GetAlgorithm() != *signatureParams.Alg {
-			return errAlgMismatch
+		if err != nil {
+			return err
+		}
+		if (v.config.All || selected) && len(keys) == 0 {
+			return ErrUnknownKeyID
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		ev.Algorithm = keys[0].GetAlgorithm()
+		if ev.KeyID == "" {
+			ev.KeyID = keys[0].GetKeyID()
 		}
 
 		for _, param := range v.config.RequiredParams {
-			if _, ok := signatureInput.Params.Get(param); !ok {
-				return errMalformedSignature
+			if _, ok := signatureInputList.Params.Get(param); !ok {
+				return ErrMalformedSignature
 			}
 		}
 
 		for _, field := range v.config.RequiredFields {
 			if !slices.Contains(fields, field) {
-				return errMalformedSignature
+				return ErrMalformedSignature
 			}
 		}
-
-		if signatureParams.Created != nil {
-			created := signatureParams.Created.Add(-tolerance)
-			// maxAge overrides expires.
-			// signature is older than maxAge
-			if maxAge != nil && now.Sub(created) > *maxAge || created.After(notAfter) {
-				return errSignatureExpired
+		for _, field := range v.config.OptionalFields {
+			if hasHeader(msg.Header, field) && !coversField(fields, field) {
+				return ErrMalformedSignature
 			}
 		}
 
-		if signatureParams.Expires != nil {
-			expires := signatureParams.Expires.Add(tolerance)
-			// expired signature
-			if now.After(expires) {
-				return errSignatureExpired
-			}
+		if err := v.checkTimes(msg.Context, signatureParams); err != nil {
+			return err
 		}
 
-		signingBase, err := createSignatureBase(fields, msg)
+		base, err := v.receivedSignatureBase(fields, signatureInputList, msg, nil, items)
 		if err != nil {
 			return err
 		}
-		marshalledInput, err := httpsfv.Marshal(signatureInput)
-		if err != nil {
+
+		if _, err := v.verifyWithKeys(keys, []byte(base), signatureBytes, ev); err != nil {
 			return err
 		}
-		signingBase = append(signingBase, signatureItem{httpsfv.NewItem("@signature-params"), []string{marshalledInput}})
+		if verified != nil {
+			*verified = append(*verified, input)
+		}
+		if !v.config.All {
+			// only the first signature with a known key id is checked
+			return nil
+		}
+	}
+
+	if expired != nil {
+		return expired
+	}
+	if !v.config.All {
+		// none of the signatures can be verified
+		return ErrUnknownKeyID
+	}
+	return nil
+}
 
-		base, err := formatSignatureBase(signingBase)
+// verifyWithKeys verifies the signature of the base with the first of the keys that verifies it,
+// returning that key. Without a declared algorithm, any of the keys for the key id may have signed
+// it.
+func (v *verifier) verifyWithKeys(keys []VerifyingKey, base, signature []byte, ev *VerifyEvent) (VerifyingKey, error) {
+	var err error
+	for _, key := range keys {
+		ev.Algorithm = key.GetAlgorithm()
+		if v.config.RequireLowS && highS(key.GetAlgorithm(), signature) {
+			err = errHighS
+			continue
+		}
+		if err = key.Verify(base, signature); err == nil {
+			return key, nil
+		}
+	}
+	return nil, invalidSignature(err)
+}
+
+func (v *verifier) VerifyDetached(base []byte, name string, signature []byte, params SignatureParameters) (keyID string, err error) {
+	err = v.observe(func(ev *VerifyEvent) error {
+		*ev = VerifyEvent{Name: name, Extra: params.Extra}
+		if params.KeyID != nil {
+			ev.KeyID = *params.KeyID
+		}
+		if params.Alg != nil {
+			ev.Algorithm = *params.Alg
+		}
+
+		// a key func is given an empty message, as there is none, so it selects by the input alone
+		msg := &Message{URL: &url.URL{}, Header: http.Header{}, IsRequest: true, Context: context.Background()}
+		keys, err := v.keysForInput(msg, SignatureInput{SignatureParameters: params, Name: name})
 		if err != nil {
 			return err
 		}
+		if len(keys) == 0 {
+			return ErrUnknownKeyID
+		}
+		if err := v.checkTimes(context.Background(), &params); err != nil {
+			return err
+		}
 
-		err = key.Verify([]byte(base), signatureBytes)
+		key, err := v.verifyWithKeys(keys, base, signature, ev)
 		if err != nil {
 			return err
 		}
+		keyID = key.GetKeyID()
+		ev.KeyID = keyID
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return keyID, nil
+}
+
+// receivedSignatureBase creates the signature base for a signature of the message covering the
+// given fields, using the signature input exactly as it was received. The values of the redacted
+// HTTP fields are masked. Components already canonicalised are taken from items, if not nil.
+func (v *verifier) receivedSignatureBase(fields []string, input httpsfv.InnerList, msg *Message, redacted []string, items map[string]signatureItem) (string, error) {
+	signingBase, err := createSignatureBaseWith(fields, msg, baseOptions{
+		lenientCase:          v.config.LenientComponentCase,
+		components:           v.config.Components,
+		canonicalContentType: v.config.CanonicalContentType,
+		items:                items,
+	})
+	if err != nil {
+		return "", err
+	}
+	for i, item := range signingBase {
+		if name, ok := item.key.Value.(string); ok && slices.Contains(redacted, name) {
+			signingBase[i].value = []string{redactedValue}
+		}
+	}
+	marshalledInput, err := httpsfv.Marshal(input)
+	if err != nil {
+		return "", err
+	}
+	signingBase = append(signingBase, signatureItem{httpsfv.NewItem("@signature-params"), []string{marshalledInput}})
+
+	return formatSignatureBase(signingBase)
+}
+
+func (v *verifier) SignatureBase(msg *Message, name string) (string, error) {
+	if v.err != nil {
+		return "", v.err
+	}
+	if v.config.TrustProxyHeaders {
+		msg = forwardedMessage(msg)
+	}
+	if v.config.Cavage {
+		header := msg.Header.Get(SignatureHeader)
+		if header == "" {
+			return "", ErrNoSignature
+		}
+		sig, err := parseCavageSignature(header)
+		if err != nil {
+			return "", err
+		}
+		base, err := createCavageSigningString(sig.Headers, msg, sig.Created, sig.Expires)
+		if err != nil {
+			return "", err
+		}
+		return redactCavageSigningString(base, v.config.RedactedFields), nil
+	}
+
+	inputHeader, ok := msg.Header[SignatureInputHeader]
+	if !ok {
+		return "", ErrNoSignature
+	}
+	inputHeaderDict, err := httpsfv.UnmarshalDictionary(inputHeader)
+	if err != nil {
+		return "", malformedSignature(err)
+	}
+	member, ok := inputHeaderDict.Get(name)
+	if !ok {
+		return "", ErrNoSignature
+	}
+	signatureInput, ok := member.(httpsfv.InnerList)
+	if !ok {
+		return "", ErrMalformedSignature
+	}
+
+	var fields []string
+	for _, item := range signatureInput.Items {
+		marshalled, err := httpsfv.Marshal(item)
+		if err != nil {
+			return "", malformedSignature(err)
+		}
+		fields = append(fields, marshalled)
+	}
+
+	return v.receivedSignatureBase(fields, signatureInput, msg, v.config.RedactedFields, nil)
+}
+
+// redactedValue replaces the values of redacted fields in signature bases
+const redactedValue = "[redacted]"
+
+// redactCavageSigningString masks the values of the redacted headers in the signing string
+func redactCavageSigningString(base string, redacted []string) string {
+	lines := strings.Split(base, "\n")
+	for i, line := range lines {
+		if name, _, ok := strings.Cut(line, ": "); ok && slices.Contains(redacted, name) {
+			lines[i] = name + ": " + redactedValue
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// keysFor returns the keys that may have created a signature with the given parameters, resolving
+// the key id if needed. No keys are returned if the key id is unknown.
+func (v *verifier) keysFor(ctx context.Context, params *SignatureParameters) ([]VerifyingKey, error) {
+	if params.Alg != nil && !v.algorithmAllowed(*params.Alg) {
+		return nil, ErrAlgorithmNotAllowed
+	}
+
+	keyID := params.KeyID
+	if keyID == nil && v.config.DefaultKeyID != "" {
+		keyID = &v.config.DefaultKeyID
+	}
+
+	var keys []VerifyingKey
+	if keyID != nil {
+		keys = v.config.Keys[*keyID]
+	}
+	if len(keys) == 0 && v.config.KeyResolver != nil {
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		var err error
+		resolver, byTag := v.config.KeyResolver.(VerifyingKeyTagResolver)
+		switch {
+		case keyID != nil:
+			keys, err = multiKeyResolver(v.config.KeyResolver).ResolveKeys(ctx, *keyID)
+		case byTag && params.Tag != nil:
+			var key VerifyingKey
+			key, err = resolver.ResolveTag(ctx, *params.Tag)
+			keys = []VerifyingKey{key}
+		default:
+			return nil, ErrMalformedSignature
+		}
+		if err != nil {
+			return nil, v.resolutionError(err)
+		}
+		// resolvers may return nil for unknown keys
+		keys = slices.DeleteFunc(slices.Clone(keys), func(k VerifyingKey) bool { return k == nil })
+	}
+
+	return v.usableKeys(keys, params)
+}
+
+// keysForInput returns the keys that may have created the signature of the message with the given
+// input, selected by the key func if there is one
+func (v *verifier) keysForInput(msg *Message, input SignatureInput) ([]VerifyingKey, error) {
+	if v.config.KeyFunc == nil {
+		return v.keysFor(msg.Context, &input.SignatureParameters)
+	}
+	if input.Alg != nil && !v.algorithmAllowed(*input.Alg) {
+		return nil, ErrAlgorithmNotAllowed
+	}
+
+	key, err := v.config.KeyFunc(msg, input)
+	if err != nil {
+		return nil, v.resolutionError(err)
+	}
+	if key == nil {
+		return nil, nil
+	}
+	return v.usableKeys([]VerifyingKey{key}, &input.SignatureParameters)
+}
+
+// resolutionError returns the error to fail verification with when resolving a key fails, or nil
+// to treat the key id as unknown
+func (v *verifier) resolutionError(err error) error {
+	if v.config.ResolverErrorsAsUnknown {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrKeyResolution, err)
+}
+
+// usableKeys returns the keys able to verify a signature with the given parameters, failing if
+// none of them use an allowed algorithm matching the declared one
+func (v *verifier) usableKeys(keys []VerifyingKey, params *SignatureParameters) ([]VerifyingKey, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	// never trust the declared algorithm over the key's own, to prevent algorithm confusion
+	if params.Alg != nil {
+		keys = slices.DeleteFunc(slices.Clone(keys), func(k VerifyingKey) bool {
+			return k.GetAlgorithm() != *params.Alg
+		})
+		if len(keys) == 0 {
+			return nil, ErrAlgorithmMismatch
+		}
+	}
+	keys = slices.DeleteFunc(slices.Clone(keys), func(k VerifyingKey) bool {
+		return !v.algorithmAllowed(k.GetAlgorithm())
+	})
+	if len(keys) == 0 {
+		return nil, ErrAlgorithmNotAllowed
+	}
+	now := v.now()
+	keys = slices.DeleteFunc(keys, func(k VerifyingKey) bool {
+		expiring, ok := k.(*expiringKey)
+		return ok && now.After(expiring.until)
+	})
+	if len(keys) == 0 {
+		return nil, ErrKeyExpired
+	}
+
+	return keys, nil
+}
+
+// expiringKey is a verifying key only accepted until a cutoff time, as added by WithVerifyKeyUntil
+type expiringKey struct {
+	VerifyingKey
+	keyID string
+	until time.Time
+}
+
+func (k *expiringKey) GetKeyID() string {
+	return k.keyID
+}
+
+// now returns the current time, from the clock of the verifier if it has one
+func (v *verifier) now() time.Time {
+	if v.clock != nil {
+		return v.clock.Now()
+	}
+	return time.Now()
+}
+
+// checkTimes checks the created and expires times of a signature with the given parameters, within
+// any time window of the context
+func (v *verifier) checkTimes(ctx context.Context, params *SignatureParameters) error {
+	var window TimeWindow
+	if ctx != nil {
+		window, _ = ctx.Value(timeWindowKey{}).(TimeWindow)
+	}
+
+	now := v.now()
+	var tolerance time.Duration
+	if v.config.Tolerance != nil {
+		tolerance = *v.config.Tolerance
+	} else {
+		tolerance = 0
+	}
+	var notAfter time.Time
+	if window.CreatedNotAfter != nil {
+		notAfter = *window.CreatedNotAfter
+	} else if v.config.NotAfter != nil {
+		notAfter = *v.config.NotAfter
+	} else {
+		notAfter = now.Add(tolerance)
+	}
+	var maxAge *time.Duration
+	if v.config.MaxAge != nil {
+		maxAge = v.config.MaxAge
+	}
+
+	if params.Created != nil {
+		// maxAge overrides expires.
+		// signature is older than maxAge
+		if maxAge != nil && now.Sub(params.Created.Add(-tolerance)) > *maxAge {
+			return ErrSignatureTooOld
+		}
+		if window.CreatedNotBefore != nil && params.Created.Add(tolerance).Before(*window.CreatedNotBefore) {
+			return ErrSignatureTooOld
+		}
+		if params.Created.Add(-tolerance).After(notAfter) {
+			return ErrSignatureNotYetValid
+		}
+	}
+
+	if params.Created == nil && v.config.RequireCreated {
+		return ErrCreatedRequired
+	}
+	if params.Expires == nil && window.RequireExpires {
+		return ErrExpiresRequired
+	}
+
+	if params.Expires != nil {
+		expires := params.Expires.Add(tolerance)
+		// expired signature
+		if now.After(expires) {
+			return ErrSignatureExpired
+		}
 	}
 
 	return nil
 }
 
+// TimeWindow is a policy for the created and expires times of signatures, for verifying with a
+// context returned by ContextWithTimeWindow. It overrides the policy of the verifier for the times
+// it sets, eg: to verify the requests of some routes more strictly than others.
+type TimeWindow struct {
+	// The earliest time signatures may have been created at. Signatures created earlier fail with
+	// ErrSignatureTooOld. The maximum age of the verifier still applies.
+	// Default: nil (signatures are only limited by the maximum age of the verifier)
+	CreatedNotBefore *time.Time
+
+	// The latest time signatures may have been created at. Signatures created later fail with
+	// ErrSignatureNotYetValid.
+	// Default: nil (as configured by WithVerifyNotAfter)
+	CreatedNotAfter *time.Time
+
+	// Reject signatures without an expires parameter with ErrExpiresRequired
+	// Default: false
+	RequireExpires bool
+}
+
+type timeWindowKey struct{}
+
+// ContextWithTimeWindow returns a copy of the context with the given time window, which messages
+// verified with the context must be signed within
+func ContextWithTimeWindow(ctx context.Context, window TimeWindow) context.Context {
+	return context.WithValue(ctx, timeWindowKey{}, window)
+}
+
+// addKey registers a key for verifying, replacing any key with the same key id and algorithm
+func (v *verifier) addKey(key VerifyingKey) {
+	keys := slices.DeleteFunc(v.config.Keys[key.GetKeyID()], func(k VerifyingKey) bool {
+		return k.GetAlgorithm() == key.GetAlgorithm()
+	})
+	v.config.Keys[key.GetKeyID()] = append(keys, key)
+}
+
+// malformedSignature wraps an error parsing the signature headers
+func malformedSignature(err error) error {
+	if errors.Is(err, ErrMalformedSignature) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrMalformedSignature, err)
+}
+
+// invalidSignature wraps an error verifying a signature
+func invalidSignature(err error) error {
+	if errors.Is(err, ErrSignatureInvalid) || errors.Is(err, ErrMalformedSignature) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrSignatureInvalid, err)
+}
+
+// challengeAccepts returns the signatures clients are challenged to sign requests with. Unless
+// configured, a single signature is requested, covering the required fields and parameters and
+// using the allowed algorithm if there is only one.
+func (v *verifier) challengeAccepts() []AcceptSignature {
+	if len(v.accepts) > 0 {
+		return v.accepts
+	}
+
+	accept := AcceptSignature{Name: "sig", Fields: v.config.RequiredFields}
+	for _, p := range v.config.RequiredParams {
+		accept.Params = append(accept.Params, Param(p))
+	}
+	if len(v.config.AllowedAlgorithms) == 1 {
+		alg := v.config.AllowedAlgorithms[0]
+		if !slices.Contains(accept.Params, ParamAlg) {
+			accept.Params = append(accept.Params, ParamAlg)
+		}
+		accept.ParamValues = &SignatureParameters{Alg: &alg}
+	}
+	return []AcceptSignature{accept}
+}
+
+// highS reports whether the signature is an ECDSA signature with a high s value, ie: greater than
+// half the order of the curve
+func highS(alg Algorithm, signature []byte) bool {
+	var curve elliptic.Curve
+	switch alg {
+	case AlgorithmEcdsaP256Sha256:
+		curve = elliptic.P256()
+	case AlgorithmEcdsaP384Sha384:
+		curve = elliptic.P384()
+	default:
+		return false
+	}
+
+	size := (curve.Params().BitSize + 7) / 8
+	if len(signature) != 2*size {
+		// left for the key to reject
+		return false
+	}
+	s := new(big.Int).SetBytes(signature[size:])
+	return s.Cmp(new(big.Int).Rsh(curve.Params().N, 1)) > 0
+}
+
+// unknownParam returns the first of the given signature parameters that is neither defined nor
+// one of the given extra parameters
+func unknownParam(params *httpsfv.Params, extra []string) (string, bool) {
+	for _, name := range params.Names() {
+		switch Param(name) {
+		case ParamCreated, ParamExpires, ParamNonce, ParamAlg, ParamKeyID, ParamTag:
+		default:
+			if !slices.Contains(extra, name) {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// algorithmAllowed reports whether signatures may use the given algorithm
+func (v *verifier) algorithmAllowed(alg Algorithm) bool {
+	return len(v.config.AllowedAlgorithms) == 0 || slices.Contains(v.config.AllowedAlgorithms, alg)
+}
+
+// Errors returned when verifying signatures, possibly wrapping a more specific cause. Use
+// errors.Is to check for them.
 var (
-	errNotSigned          = errors.New("signature headers not found")
-	errMalformedSignature = errors.New("unable to parse signature headers")
-	errUnknownKey         = errors.New("unknown key id")
-	errAlgMismatch        = errors.New("algorithm mismatch for key id")
-	errSignatureExpired   = errors.New("signature expired")
-	errInvalidSignature   = errors.New("invalid signature")
+	// ErrNoSignature is returned when the message has no signatures to verify
+	ErrNoSignature = errors.New("signature headers not found")
+	// ErrMalformedSignature is returned when the signature headers can't be parsed
+	ErrMalformedSignature = errors.New("unable to parse signature headers")
+	// ErrTooManySignatures is returned when a message has more signatures than the verifier allows.
+	// It wraps ErrMalformedSignature.
+	ErrTooManySignatures = fmt.Errorf("%w: too many signatures", ErrMalformedSignature)
+	// ErrUnknownKeyID is returned when no key is known for the key id of a signature
+	ErrUnknownKeyID = errors.New("unknown key id")
+	// ErrKeyResolution wraps the error a key resolver or key func fails with, eg: when a key
+	// server can't be reached, so it can be told apart from an unknown key id
+	ErrKeyResolution = errors.New("unable to resolve key")
+	// ErrKeyExpired is returned when the only keys for the key id of a signature were accepted until
+	// a cutoff time that has passed, as set by WithVerifyKeyUntil
+	ErrKeyExpired = errors.New("key expired")
+	// ErrSignatureExpired is returned when a signature is too old or has expired
+	ErrSignatureExpired = errors.New("signature expired")
+	// ErrSignatureTooOld is returned when a signature was created too long ago, either before the
+	// maximum age or the start of the time window. It wraps ErrSignatureExpired.
+	ErrSignatureTooOld = fmt.Errorf("%w: created too long ago", ErrSignatureExpired)
+	// ErrSignatureNotYetValid is returned when a signature was created after the latest time
+	// allowed, eg: in the future. It wraps ErrSignatureExpired.
+	ErrSignatureNotYetValid = fmt.Errorf("%w: created too late", ErrSignatureExpired)
+	// ErrCreatedRequired is returned when a signature without a created parameter is verified by a
+	// verifier that requires one. It wraps ErrMalformedSignature.
+	ErrCreatedRequired = fmt.Errorf("%w: created required", ErrMalformedSignature)
+	// ErrExpiresRequired is returned when a signature without an expires parameter is verified
+	// within a time window that requires one. It wraps ErrMalformedSignature.
+	ErrExpiresRequired = fmt.Errorf("%w: expires required", ErrMalformedSignature)
+	// ErrMissingCoveredComponent is returned when a signature covers an HTTP field the message
+	// doesn't have, eg: a header dropped on the way. A field without a value is never treated as
+	// empty, so signatures covering it can't be verified. Signing messages without a field to
+	// sign fails with it too.
+	ErrMissingCoveredComponent = errors.New("header not found")
+	// ErrSignatureInvalid is returned when a signature doesn't match the message
+	ErrSignatureInvalid = errors.New("invalid signature")
+	// ErrAlgorithmNotAllowed is returned when a signature uses an algorithm that isn't one of the
+	// allowed algorithms
+	ErrAlgorithmNotAllowed = errors.New("algorithm not allowed")
+	// ErrAlgorithmMismatch is returned when a signature declares an algorithm that none of the keys
+	// for its key id use. It wraps ErrSignatureInvalid.
+	ErrAlgorithmMismatch = fmt.Errorf("%w: algorithm mismatch for key id", ErrSignatureInvalid)
 )
 
+var errHighS = errors.New("ecdsa signature with high s value")
+
+// checkSignatureLength checks a signature that has a fixed length for its algorithm is that long
+func checkSignatureLength(signature []byte, size int) error {
+	if len(signature) != size {
+		return fmt.Errorf("%w: expected a %d byte signature, got %d bytes", ErrMalformedSignature, size, len(signature))
+	}
+	return nil
+}
+
 type RsaPssSha512VerifyingKey struct {
 	*rsa.PublicKey
 	KeyID string
@@ -342,7 +1311,7 @@ func (k *RsaPkcs1v15Sha256VerifyingKey) Verify(data []byte, signature []byte) er
 
 	bytes := hash.Sum(nil)
 
-	return rsa.VerifyPKCS1v15(k.PublicKey, crypto.SHA512, bytes, signature)
+	return rsa.VerifyPKCS1v15(k.PublicKey, crypto.SHA256, bytes, signature)
 }
 
 func (k *RsaPkcs1v15Sha256VerifyingKey) GetKeyID() string {
@@ -367,8 +1336,8 @@ func (k *EcdsaP256VerifyingKey) Verify(data []byte, signature []byte) error {
 
 	bytes := hash.Sum(nil)
 
-	if len(signature) != 64 {
-		return errInvalidSignature
+	if err := checkSignatureLength(signature, 64); err != nil {
+		return err
 	}
 	rBytes, sBytes := signature[:32], signature[32:]
 	var r, s big.Int
@@ -376,7 +1345,7 @@ func (k *EcdsaP256VerifyingKey) Verify(data []byte, signature []byte) error {
 	s.SetBytes(sBytes)
 
 	if !ecdsa.Verify(k.PublicKey, bytes, &r, &s) {
-		return errInvalidSignature
+		return ErrSignatureInvalid
 	}
 	return nil
 }
@@ -403,8 +1372,8 @@ func (k *EcdsaP384VerifyingKey) Verify(data []byte, signature []byte) error {
 
 	bytes := hash.Sum(nil)
 
-	if len(signature) != 96 {
-		return errInvalidSignature
+	if err := checkSignatureLength(signature, 96); err != nil {
+		return err
 	}
 	rBytes, sBytes := signature[:48], signature[48:]
 
@@ -413,7 +1382,7 @@ func (k *EcdsaP384VerifyingKey) Verify(data []byte, signature []byte) error {
 	s.SetBytes(sBytes)
 
 	if !ecdsa.Verify(k.PublicKey, bytes, &r, &s) {
-		return errInvalidSignature
+		return ErrSignatureInvalid
 	}
 	return nil
 }
@@ -432,8 +1401,11 @@ type Ed25519VerifyingKey struct {
 }
 
 func (k *Ed25519VerifyingKey) Verify(data []byte, signature []byte) error {
+	if err := checkSignatureLength(signature, ed25519.SignatureSize); err != nil {
+		return err
+	}
 	if !ed25519.Verify(k.PublicKey, data, signature) {
-		return errInvalidSignature
+		return ErrSignatureInvalid
 	}
 	return nil
 }
@@ -446,25 +1418,43 @@ func (k *Ed25519VerifyingKey) GetAlgorithm() Algorithm {
 	return AlgorithmEd25519
 }
 
-type HmacSha256VerifyingKey struct {
-	Secret []byte
-	KeyID  string
+// Ed25519phVerifyingKey verifies signatures using the non-standard `ed25519ph` algorithm, made
+// over the SHA-512 hash of the signature base
+type Ed25519phVerifyingKey struct {
+	ed25519.PublicKey
+	KeyID string
 }
 
-func (k *HmacSha256VerifyingKey) Verify(data []byte, signature []byte) error {
-	hash := hmac.New(sha256.New, k.Secret)
-	_, err := hash.Write(data)
-	if err != nil {
+func (k *Ed25519phVerifyingKey) Verify(data []byte, signature []byte) error {
+	if err := checkSignatureLength(signature, ed25519.SignatureSize); err != nil {
 		return err
 	}
-
-	bytes := hash.Sum(nil)
-	if !hmac.Equal(bytes, signature) {
-		return errInvalidSignature
+	digest := sha512.Sum512(data)
+	if err := ed25519.VerifyWithOptions(k.PublicKey, digest[:], signature, &ed25519.Options{Hash: crypto.SHA512}); err != nil {
+		return ErrSignatureInvalid
 	}
 	return nil
 }
 
+func (k *Ed25519phVerifyingKey) GetKeyID() string {
+	return k.KeyID
+}
+
+func (k *Ed25519phVerifyingKey) GetAlgorithm() Algorithm {
+	return AlgorithmEd25519ph
+}
+
+// HmacSha256VerifyingKey verifies `hmac-sha256` signatures. Signatures are compared in constant
+// time, so how long verification takes reveals nothing about the expected MAC.
+type HmacSha256VerifyingKey struct {
+	Secret []byte
+	KeyID  string
+}
+
+func (k *HmacSha256VerifyingKey) Verify(data []byte, signature []byte) error {
+	return verifyMAC(hmac.New(sha256.New, k.Secret), data, signature)
+}
+
 func (k *HmacSha256VerifyingKey) GetKeyID() string {
 	return k.KeyID
 }
@@ -472,3 +1462,15 @@ func (k *HmacSha256VerifyingKey) GetKeyID() string {
 func (k *HmacSha256VerifyingKey) GetAlgorithm() Algorithm {
 	return AlgorithmHmacSha256
 }
+
+// verifyMAC verifies the signature is the MAC of the data. Every HMAC verifying key must verify
+// with this, as it compares in constant time rather than stopping at the first differing byte.
+func verifyMAC(mac hash.Hash, data []byte, signature []byte) error {
+	if _, err := mac.Write(data); err != nil {
+		return err
+	}
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
@@ -0,0 +1,2117 @@
+// BSD 3-Clause License
+
+// Copyright (c) 2021, James Bowes
+// Copyright (c) 2023, Alexander Taraymovich, OffBlocks
+// All rights reserved.
+
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package httpsig
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dunglas/httpsfv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify_Errors(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	now := time.Now()
+	s := NewSigner(
+		WithHmacSha256("test-shared-secret", k),
+		WithSignFields("@method", "@authority", "content-digest"),
+		WithSignParams(ParamKeyID, ParamCreated),
+		WithSignParamValues(&SignatureParameters{Created: &now}),
+	)
+	v := NewVerifier(
+		WithHmacSha256("test-shared-secret", k),
+		WithVerifyAll(true),
+		WithVerifyMaxAge(time.Minute),
+	)
+
+	signed := func(t *testing.T) *http.Request {
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString("{}"))
+		assert.NoError(t, err)
+		assert.NoError(t, s.SignRequest(req))
+		return req
+	}
+
+	t.Run("no signature", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "https://example.com/foo", nil)
+		assert.NoError(t, err)
+		assert.ErrorIs(t, v.VerifyRequest(req), ErrNoSignature)
+	})
+	t.Run("malformed signature", func(t *testing.T) {
+		req := signed(t)
+		req.Header.Set(SignatureHeader, "sig=(")
+		err := v.VerifyRequest(req)
+		assert.ErrorIs(t, err, ErrMalformedSignature)
+		assert.ErrorContains(t, err, "unable to parse signature headers")
+	})
+	t.Run("unknown key id", func(t *testing.T) {
+		v := NewVerifier(
+			WithHmacSha256("another-shared-secret", k),
+			WithVerifyAll(true),
+		)
+		assert.ErrorIs(t, v.VerifyRequest(signed(t)), ErrUnknownKeyID)
+	})
+	t.Run("expired signature", func(t *testing.T) {
+		v := NewVerifier(
+			WithHmacSha256("test-shared-secret", k),
+			WithVerifyMaxAge(time.Minute),
+		)
+		v.clock = &testClock{now: now.Add(time.Hour)}
+		assert.ErrorIs(t, v.VerifyRequest(signed(t)), ErrSignatureExpired)
+	})
+	t.Run("invalid signature", func(t *testing.T) {
+		req := signed(t)
+		req.Method = "PUT"
+		assert.ErrorIs(t, v.VerifyRequest(req), ErrSignatureInvalid)
+	})
+	t.Run("digest mismatch", func(t *testing.T) {
+		req := signed(t)
+		req.Body = io.NopCloser(bytes.NewBufferString("[]"))
+		err := v.VerifyRequest(req)
+		assert.ErrorIs(t, err, ErrDigestMismatch)
+		assert.EqualError(t, err, "digest mismatch")
+	})
+}
+
+func TestVerify_MissingCoveredComponent(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	signed := func(t *testing.T, cavage bool, apiKey string) *http.Request {
+		req, err := http.NewRequest("GET", "https://example.com/foo", nil)
+		assert.NoError(t, err)
+		req.Header.Set("X-Api-Key", apiKey)
+		fields := []string{"@method", "x-api-key"}
+		if cavage {
+			fields[0] = "(request-target)"
+		}
+		s := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields(fields...), WithCavageCompat(cavage))
+		assert.NoError(t, s.SignRequest(req))
+		return req
+	}
+
+	for _, cavage := range []bool{false, true} {
+		v := NewVerifier(WithHmacSha256("test-shared-secret", k), WithCavageCompat(cavage))
+
+		t.Run(fmt.Sprintf("rejects a dropped header (cavage %t)", cavage), func(t *testing.T) {
+			req := signed(t, cavage, "secret")
+			req.Header.Del("X-Api-Key")
+			err := v.VerifyRequest(req)
+			assert.ErrorIs(t, err, ErrMissingCoveredComponent)
+			assert.ErrorContains(t, err, "x-api-key")
+		})
+		t.Run(fmt.Sprintf("verifies an empty header (cavage %t)", cavage), func(t *testing.T) {
+			assert.NoError(t, v.VerifyRequest(signed(t, cavage, "")))
+
+			req := signed(t, cavage, "")
+			req.Header.Del("X-Api-Key")
+			assert.ErrorIs(t, v.VerifyRequest(req), ErrMissingCoveredComponent)
+		})
+	}
+	t.Run("fails to sign without the header", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "https://example.com/foo", nil)
+		assert.NoError(t, err)
+		s := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields("@method", "x-api-key"))
+		assert.ErrorIs(t, s.SignRequest(req), ErrMissingCoveredComponent)
+	})
+}
+
+func TestOptionalFields(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	request := func(t *testing.T, method, idempotencyKey string) *http.Request {
+		req, err := http.NewRequest(method, "https://example.com/foo", nil)
+		assert.NoError(t, err)
+		if idempotencyKey != "" {
+			req.Header.Set("Idempotency-Key", idempotencyKey)
+		}
+		return req
+	}
+	sign := func(t *testing.T, req *http.Request, opts ...signOption) *http.Request {
+		s := NewSigner(append([]signOption{WithHmacSha256("test-shared-secret", k), WithSignSkipDigest(true)}, opts...)...)
+		assert.NoError(t, s.SignRequest(req))
+		return req
+	}
+	fields := func(t *testing.T, req *http.Request) []string {
+		inputs, err := ParseSignatureHeaders(req.Header)
+		assert.NoError(t, err)
+		if !assert.Len(t, inputs, 1) {
+			return nil
+		}
+		return inputs[0].Fields
+	}
+	v := NewVerifier(WithHmacSha256("test-shared-secret", k), WithOptionalFields("Idempotency-Key"))
+
+	for _, cavage := range []bool{false, true} {
+		method := "@method"
+		if cavage {
+			method = "(request-target)"
+		}
+		v := NewVerifier(WithHmacSha256("test-shared-secret", k), WithOptionalFields("Idempotency-Key"), WithCavageCompat(cavage))
+		opts := []signOption{WithSignFields(method), WithOptionalFields("Idempotency-Key"), WithCavageCompat(cavage)}
+
+		t.Run(fmt.Sprintf("signs an optional field when present (cavage %t)", cavage), func(t *testing.T) {
+			req := sign(t, request(t, "POST", "abc"), opts...)
+			assert.NoError(t, v.VerifyRequest(req))
+
+			req.Header.Set("Idempotency-Key", "def")
+			assert.ErrorIs(t, v.VerifyRequest(req), ErrSignatureInvalid)
+		})
+		t.Run(fmt.Sprintf("leaves out an absent optional field (cavage %t)", cavage), func(t *testing.T) {
+			assert.NoError(t, v.VerifyRequest(sign(t, request(t, "GET", ""), opts...)))
+		})
+		t.Run(fmt.Sprintf("rejects an optional field not covered (cavage %t)", cavage), func(t *testing.T) {
+			req := sign(t, request(t, "GET", ""), opts...)
+			req.Header.Set("Idempotency-Key", "abc")
+			assert.ErrorIs(t, v.VerifyRequest(req), ErrMalformedSignature)
+		})
+	}
+	t.Run("covers the field only when present", func(t *testing.T) {
+		opts := []signOption{WithSignFields("@method"), WithOptionalFields("Idempotency-Key")}
+		assert.Equal(t, []string{`"@method"`, `"idempotency-key"`}, fields(t, sign(t, request(t, "POST", "abc"), opts...)))
+		assert.Equal(t, []string{`"@method"`}, fields(t, sign(t, request(t, "GET", ""), opts...)))
+	})
+	t.Run("doesn't cover an optional field twice", func(t *testing.T) {
+		req := sign(t, request(t, "POST", "abc"), WithSignFields("@method", "idempotency-key"), WithOptionalFields("Idempotency-Key"))
+		assert.Equal(t, []string{`"@method"`, `"idempotency-key"`}, fields(t, req))
+		assert.NoError(t, v.VerifyRequest(req))
+	})
+	t.Run("accepts optional fields covered when required", func(t *testing.T) {
+		req := sign(t, request(t, "POST", "abc"), WithSignFields("@method", "idempotency-key"))
+		assert.NoError(t, v.VerifyRequest(req))
+	})
+}
+
+func TestVerify_UnknownParams(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	// sign by hand to declare a parameter the signer doesn't support
+	input := `("@method" "@authority");keyid="test-shared-secret";context="payments"`
+	base := "\"@method\": POST\n\"@authority\": example.com\n\"@signature-params\": " + input
+	sig, err := (&HmacSha256SigningKey{Secret: k, KeyID: "test-shared-secret"}).Sign([]byte(base))
+	assert.NoError(t, err)
+
+	msg := MessageFromRequest(testReq())
+	msg.Header.Set(SignatureInputHeader, "sig1="+input)
+	msg.Header.Set(SignatureHeader, "sig1=:"+base64.StdEncoding.EncodeToString(sig)+":")
+
+	t.Run("rejects unknown parameters by default", func(t *testing.T) {
+		v := NewVerifier(WithHmacSha256("test-shared-secret", k))
+		err := v.Verify(msg)
+		assert.ErrorIs(t, err, ErrMalformedSignature)
+		assert.ErrorContains(t, err, `unknown parameter "context"`)
+	})
+	t.Run("ignores unknown parameters when lenient", func(t *testing.T) {
+		v := NewVerifier(
+			WithHmacSha256("test-shared-secret", k),
+			WithVerifyLenientParams(true),
+		)
+		assert.NoError(t, v.Verify(msg))
+	})
+	t.Run("unknown parameters are covered by the signature", func(t *testing.T) {
+		tampered := MessageFromRequest(testReq())
+		tampered.Header.Set(SignatureInputHeader, `sig1=("@method" "@authority");keyid="test-shared-secret";context="refunds"`)
+		tampered.Header.Set(SignatureHeader, msg.Header.Get(SignatureHeader))
+
+		v := NewVerifier(WithHmacSha256("test-shared-secret", k), WithVerifyLenientParams(true))
+		assert.ErrorIs(t, v.Verify(tampered), ErrSignatureInvalid)
+	})
+	t.Run("accepts known parameters", func(t *testing.T) {
+		nonce, tag := "abc", "app"
+		s := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithSignParams(ParamKeyID, ParamAlg, ParamCreated, ParamNonce, ParamTag),
+			WithSignParamValues(&SignatureParameters{Nonce: &nonce, Tag: &tag}),
+		)
+		signed := MessageFromRequest(testReq())
+		hdr, err := s.Sign(signed)
+		assert.NoError(t, err)
+		signed.Header = hdr
+
+		v := NewVerifier(WithHmacSha256("test-shared-secret", k))
+		assert.NoError(t, v.Verify(signed))
+	})
+	t.Run("accepts expected extra parameters", func(t *testing.T) {
+		var ev VerifyEvent
+		v := NewVerifier(
+			WithHmacSha256("test-shared-secret", k),
+			WithVerifyExtraParams("context"),
+			WithVerifyObserver(func(e VerifyEvent) { ev = e }),
+		)
+		assert.NoError(t, v.Verify(msg))
+		assert.Equal(t, map[string]any{"context": "payments"}, ev.Extra)
+	})
+}
+
+func TestVerify_ExtraParams(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	s := NewSigner(
+		WithHmacSha256("test-shared-secret", k),
+		WithSignFields("@method", "@authority"),
+		WithSignParams(ParamKeyID),
+		WithSignParam("tenant", "acme"),
+		WithSignParam("version", 2),
+		WithSignParam("beta", true),
+	)
+	msg := MessageFromRequest(testReq())
+	hdr, err := s.Sign(msg)
+	assert.NoError(t, err)
+	msg.Header = hdr
+	assert.Equal(t, `sig=("@method" "@authority");keyid="test-shared-secret";beta;tenant="acme";version=2`, hdr.Get(SignatureInputHeader))
+
+	var ev VerifyEvent
+	v := NewVerifier(
+		WithHmacSha256("test-shared-secret", k),
+		WithVerifyExtraParams("tenant", "version", "beta"),
+		WithVerifyObserver(func(e VerifyEvent) { ev = e }),
+	)
+	assert.NoError(t, v.Verify(msg))
+	assert.Equal(t, map[string]any{"tenant": "acme", "version": int64(2), "beta": true}, ev.Extra)
+
+	inputs, err := ParseSignatureHeaders(hdr)
+	assert.NoError(t, err)
+	assert.Equal(t, ev.Extra, inputs[0].Extra)
+
+	// the parameters are covered by the signature
+	tampered := *msg
+	tampered.Header = hdr.Clone()
+	tampered.Header.Set(SignatureInputHeader, `sig=("@method" "@authority");keyid="test-shared-secret";beta;tenant="other";version=2`)
+	assert.ErrorIs(t, v.Verify(&tampered), ErrSignatureInvalid)
+}
+
+func TestVerify_TimeWindow(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	now := time.Unix(1618884473, 0)
+	signed := func(t *testing.T, created time.Time, expires *time.Time) *Message {
+		params := []Param{ParamKeyID, ParamCreated}
+		if expires != nil {
+			params = append(params, ParamExpires)
+		}
+		s := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithSignFields("@method"),
+			WithSignParams(params...),
+			WithSignParamValues(&SignatureParameters{Created: &created, Expires: expires}),
+		)
+		msg := MessageFromRequest(testReq())
+		hdr, err := s.Sign(msg)
+		assert.NoError(t, err)
+		msg.Header = hdr
+		return msg
+	}
+	verify := func(msg *Message, window TimeWindow) error {
+		v := NewVerifier(WithHmacSha256("test-shared-secret", k), WithVerifyMaxAge(time.Hour))
+		v.clock = &testClock{now: now}
+		msg.Context = ContextWithTimeWindow(context.Background(), window)
+		return v.Verify(msg)
+	}
+
+	t.Run("uses the verifier's policy without a window", func(t *testing.T) {
+		assert.NoError(t, verify(signed(t, now.Add(-time.Minute), nil), TimeWindow{}))
+
+		err := verify(signed(t, now.Add(-2*time.Hour), nil), TimeWindow{})
+		assert.ErrorIs(t, err, ErrSignatureTooOld)
+		assert.ErrorIs(t, err, ErrSignatureExpired)
+		assert.ErrorIs(t, verify(signed(t, now.Add(time.Minute), nil), TimeWindow{}), ErrSignatureNotYetValid)
+	})
+	t.Run("rejects signatures created before the window", func(t *testing.T) {
+		notBefore := now.Add(-10 * time.Second)
+		window := TimeWindow{CreatedNotBefore: &notBefore}
+		assert.NoError(t, verify(signed(t, now.Add(-5*time.Second), nil), window))
+
+		err := verify(signed(t, now.Add(-time.Minute), nil), window)
+		assert.ErrorIs(t, err, ErrSignatureTooOld)
+		assert.ErrorIs(t, err, ErrSignatureExpired)
+	})
+	t.Run("rejects signatures created after the window", func(t *testing.T) {
+		notAfter := now.Add(-time.Minute)
+		window := TimeWindow{CreatedNotAfter: &notAfter}
+		assert.NoError(t, verify(signed(t, now.Add(-2*time.Minute), nil), window))
+
+		err := verify(signed(t, now.Add(-time.Second), nil), window)
+		assert.ErrorIs(t, err, ErrSignatureNotYetValid)
+		assert.ErrorIs(t, err, ErrSignatureExpired)
+
+		// the window replaces the verifier's latest time, leaving signatures from the future valid
+		later := now.Add(time.Hour)
+		assert.NoError(t, verify(signed(t, now.Add(time.Minute), nil), TimeWindow{CreatedNotAfter: &later}))
+	})
+	t.Run("requires an expires time", func(t *testing.T) {
+		window := TimeWindow{RequireExpires: true}
+		expires := now.Add(time.Minute)
+		assert.NoError(t, verify(signed(t, now, &expires), window))
+
+		err := verify(signed(t, now, nil), window)
+		assert.ErrorIs(t, err, ErrExpiresRequired)
+		assert.ErrorIs(t, err, ErrMalformedSignature)
+		assert.NoError(t, verify(signed(t, now, nil), TimeWindow{}))
+	})
+}
+
+func TestVerify_RequireCreated(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	for _, cavage := range []bool{false, true} {
+		signed := func(t *testing.T, params ...Param) *Message {
+			s := NewSigner(
+				WithHmacSha256("test-shared-secret", k),
+				WithSignFields("date"),
+				WithSignParams(params...),
+				WithCavageCompat(cavage),
+			)
+			msg := MessageFromRequest(testReq())
+			hdr, err := s.Sign(msg)
+			assert.NoError(t, err)
+			msg.Header = hdr
+			return msg
+		}
+
+		t.Run(fmt.Sprintf("cavage %t", cavage), func(t *testing.T) {
+			v := NewVerifier(
+				WithHmacSha256("test-shared-secret", k),
+				WithVerifyMaxAge(time.Minute),
+				WithCavageCompat(cavage),
+			)
+			assert.NoError(t, v.Verify(signed(t, ParamKeyID)))
+
+			v = NewVerifier(
+				WithHmacSha256("test-shared-secret", k),
+				WithVerifyMaxAge(time.Minute),
+				WithVerifyRequireCreated(true),
+				WithCavageCompat(cavage),
+			)
+			assert.NoError(t, v.Verify(signed(t, ParamKeyID, ParamCreated)))
+			err := v.Verify(signed(t, ParamKeyID))
+			assert.ErrorIs(t, err, ErrCreatedRequired)
+			assert.ErrorIs(t, err, ErrMalformedSignature)
+		})
+	}
+}
+
+func TestVerify_MaxSignatures(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	signed := func(t *testing.T, n int) *Message {
+		msg := MessageFromRequest(testReq())
+		for i := 0; i < n; i++ {
+			s := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignName(fmt.Sprintf("sig%d", i)), WithSignFields("@method"))
+			hdr, err := s.Sign(msg)
+			assert.NoError(t, err)
+			msg.Header = hdr
+		}
+		return msg
+	}
+	resolver := &contextResolver{key: &HmacSha256VerifyingKey{Secret: k, KeyID: "test-shared-secret"}}
+
+	t.Run("allows up to 8 signatures by default", func(t *testing.T) {
+		v := NewVerifier(WithVerifyingKeyResolver(resolver), WithVerifyAll(true))
+		assert.NoError(t, v.Verify(signed(t, 8)))
+
+		resolver.ctx = nil
+		err := v.Verify(signed(t, 9))
+		assert.ErrorIs(t, err, ErrTooManySignatures)
+		assert.ErrorIs(t, err, ErrMalformedSignature)
+		assert.Nil(t, resolver.ctx, "no key should be resolved")
+	})
+	t.Run("uses the configured limit", func(t *testing.T) {
+		v := NewVerifier(WithHmacSha256("test-shared-secret", k), WithVerifyMaxSignatures(1))
+		assert.NoError(t, v.Verify(signed(t, 1)))
+		assert.ErrorIs(t, v.Verify(signed(t, 2)), ErrTooManySignatures)
+
+		v = NewVerifier(WithHmacSha256("test-shared-secret", k), WithVerifyMaxSignatures(-1), WithVerifyAll(true))
+		assert.NoError(t, v.Verify(signed(t, 20)))
+	})
+	t.Run("counts the members of either header", func(t *testing.T) {
+		v := NewVerifier(WithHmacSha256("test-shared-secret", k), WithVerifyMaxSignatures(2))
+		many := signed(t, 3)
+
+		msg := signed(t, 1)
+		msg.Header.Set(SignatureHeader, many.Header.Get(SignatureHeader))
+		assert.ErrorIs(t, v.Verify(msg), ErrTooManySignatures)
+
+		msg = signed(t, 1)
+		msg.Header.Set(SignatureInputHeader, many.Header.Get(SignatureInputHeader))
+		assert.ErrorIs(t, v.Verify(msg), ErrTooManySignatures)
+	})
+}
+
+func TestVerify_SkipsUnknownKeyIDs(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+	_, newPriv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+
+	// signatures are declared in the order they are signed
+	signed := func(t *testing.T, opts ...signOption) *Message {
+		msg := MessageFromRequest(testReq())
+		for _, opt := range opts {
+			hdr, err := NewSigner(opt, WithSignName("sig"), WithSignFields("@method")).Sign(msg)
+			assert.NoError(t, err)
+			msg.Header = hdr
+		}
+		return msg
+	}
+	v := NewVerifier(WithHmacSha256("test-shared-secret", k))
+
+	t.Run("verifies a known key id after an unknown one", func(t *testing.T) {
+		msg := signed(t, WithSignEd25519("new-key", newPriv), WithHmacSha256("test-shared-secret", k))
+		inputs, err := ParseSignatureHeaders(msg.Header)
+		assert.NoError(t, err)
+		assert.Equal(t, "new-key", *inputs[0].KeyID)
+
+		assert.NoError(t, v.Verify(msg))
+		assert.ErrorIs(t, NewVerifier(WithHmacSha256("test-shared-secret", k), WithVerifyAll(true)).Verify(msg), ErrUnknownKeyID)
+	})
+	t.Run("only checks the first known key id", func(t *testing.T) {
+		msg := signed(t, WithHmacSha256("test-shared-secret", []byte("wrong")), WithHmacSha256("test-shared-secret", k))
+		assert.ErrorIs(t, v.Verify(msg), ErrSignatureInvalid)
+
+		msg = signed(t, WithHmacSha256("test-shared-secret", k), WithHmacSha256("test-shared-secret", []byte("wrong")))
+		assert.NoError(t, v.Verify(msg))
+	})
+	t.Run("fails without a known key id", func(t *testing.T) {
+		msg := signed(t, WithSignEd25519("new-key", newPriv), WithHmacSha256("other-key", k))
+		assert.ErrorIs(t, v.Verify(msg), ErrUnknownKeyID)
+	})
+}
+
+func TestVerify_LenientComponentCase(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	// sign by hand, as a non-conformant signer would
+	input := `("@Method" "@authority");keyid="test-shared-secret"`
+	base := "\"@Method\": POST\n\"@authority\": example.com\n\"@signature-params\": " + input
+	sig, err := (&HmacSha256SigningKey{Secret: k, KeyID: "test-shared-secret"}).Sign([]byte(base))
+	assert.NoError(t, err)
+
+	msg := MessageFromRequest(testReq())
+	msg.Header.Set(SignatureInputHeader, "sig1="+input)
+	msg.Header.Set(SignatureHeader, "sig1=:"+base64.StdEncoding.EncodeToString(sig)+":")
+
+	t.Run("rejects uppercase derived components by default", func(t *testing.T) {
+		v := NewVerifier(WithHmacSha256("test-shared-secret", k))
+		assert.EqualError(t, v.Verify(msg), "derived component name must be lowercase: @Method")
+	})
+	t.Run("accepts uppercase derived components when lenient", func(t *testing.T) {
+		v := NewVerifier(
+			WithHmacSha256("test-shared-secret", k),
+			WithVerifyLenientComponentCase(true),
+		)
+		assert.NoError(t, v.Verify(msg))
+
+		tampered := *msg
+		tampered.Method = "PUT"
+		assert.ErrorIs(t, v.Verify(&tampered), ErrSignatureInvalid)
+	})
+}
+
+func TestVerify_MultipleKeysPerKeyID(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+
+	v := NewVerifier(
+		WithHmacSha256("k1", k),
+		WithVerifyEd25519("k1", pub),
+	)
+
+	signed := func(t *testing.T, opts ...signOption) *Message {
+		s := NewSigner(append([]signOption{WithSignFields("@method", "@authority")}, opts...)...)
+		msg := MessageFromRequest(testReq())
+		hdr, err := s.Sign(msg)
+		assert.NoError(t, err)
+		msg.Header = hdr
+		return msg
+	}
+
+	t.Run("verifies with the key matching the algorithm", func(t *testing.T) {
+		assert.NoError(t, v.Verify(signed(t, WithHmacSha256("k1", k))))
+		assert.NoError(t, v.Verify(signed(t, WithSignEd25519("k1", priv))))
+	})
+	t.Run("verifies with any key without a declared algorithm", func(t *testing.T) {
+		assert.NoError(t, v.Verify(signed(t, WithHmacSha256("k1", k), WithSignParams(ParamKeyID))))
+		assert.NoError(t, v.Verify(signed(t, WithSignEd25519("k1", priv), WithSignParams(ParamKeyID))))
+	})
+	t.Run("rejects an algorithm without a key", func(t *testing.T) {
+		ecPriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.NoError(t, err)
+		assert.ErrorIs(t, v.Verify(signed(t, WithSignEcdsaP256Sha256("k1", ecPriv))), ErrAlgorithmMismatch)
+	})
+	t.Run("replaces a key with the same algorithm", func(t *testing.T) {
+		other, _, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err)
+		v := NewVerifier(
+			WithVerifyEd25519("k1", pub),
+			WithVerifyEd25519("k1", other),
+		)
+		assert.Len(t, v.config.Keys["k1"], 1)
+		assert.ErrorIs(t, v.Verify(signed(t, WithSignEd25519("k1", priv))), ErrSignatureInvalid)
+	})
+}
+
+func TestVerify_MultipleHeaderLines(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+	_, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+
+	// sign each signature separately, so each is sent as its own header line
+	msg := MessageFromRequest(testReq())
+	hdr := msg.Header.Clone()
+	for _, s := range []*Signer{
+		NewSigner(WithHmacSha256("test-shared-secret", k), WithSignName("sig-a"), WithSignFields("@method")),
+		NewSigner(WithSignEd25519("test-key-ed25519", priv), WithSignName("sig-b"), WithSignFields("@authority")),
+	} {
+		m := *msg
+		m.Header = msg.Header.Clone()
+		signed, err := s.Sign(&m)
+		assert.NoError(t, err)
+		hdr.Add(SignatureHeader, signed.Get(SignatureHeader))
+		hdr.Add(SignatureInputHeader, signed.Get(SignatureInputHeader))
+	}
+	assert.Len(t, hdr.Values(SignatureHeader), 2)
+	assert.Len(t, hdr.Values(SignatureInputHeader), 2)
+
+	inputs, err := ParseSignatureHeaders(hdr)
+	assert.NoError(t, err)
+	var names []string
+	for _, input := range inputs {
+		names = append(names, input.Name)
+	}
+	assert.Equal(t, []string{"sig-a", "sig-b"}, names)
+
+	v := NewVerifier(
+		WithHmacSha256("test-shared-secret", k),
+		WithVerifyEd25519("test-key-ed25519", priv.Public().(ed25519.PublicKey)),
+		WithVerifyAll(true),
+	)
+	msg.Header = hdr
+	assert.NoError(t, v.Verify(msg))
+
+	// the signature on the second line is verified too
+	tampered := *msg
+	tampered.Header = hdr.Clone()
+	tampered.Header[SignatureHeader][1] = "sig-b=:" + base64.StdEncoding.EncodeToString(make([]byte, ed25519.SignatureSize)) + ":"
+	assert.ErrorIs(t, v.Verify(&tampered), ErrSignatureInvalid)
+}
+
+func TestVerify_SharedComponents(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	// both signatures cover the content type, serialised differently
+	s := NewSigner(
+		WithHmacSha256("test-shared-secret", k), WithSignName("sig-a"), WithSignFields("@method", "content-type"),
+		WithSignSignature(WithHmacSha256("test-shared-secret", k), WithSignName("sig-b"), WithSignFields("@method", `"content-type";bs`)),
+	)
+	msg := MessageFromRequest(testReq())
+	hdr, err := s.Sign(msg)
+	assert.NoError(t, err)
+	msg.Header = hdr
+
+	v := NewVerifier(WithHmacSha256("test-shared-secret", k), WithVerifyAll(true))
+	assert.NoError(t, v.Verify(msg))
+
+	for _, name := range []string{"sig-a", "sig-b"} {
+		v := NewVerifier(WithHmacSha256("test-shared-secret", k), WithVerifySignatureSelector(func(inputs []SignatureInput) (string, bool) {
+			return name, true
+		}))
+		assert.NoError(t, v.Verify(msg), name)
+	}
+}
+
+// rotatingResolver resolves key ids to every key currently valid for them
+type rotatingResolver struct {
+	keys map[string][]VerifyingKey
+}
+
+func (r *rotatingResolver) Resolve(ctx context.Context, keyID string) (VerifyingKey, error) {
+	if keys := r.keys[keyID]; len(keys) > 0 {
+		return keys[0], nil
+	}
+	return nil, nil
+}
+
+func (r *rotatingResolver) ResolveKeys(ctx context.Context, keyID string) ([]VerifyingKey, error) {
+	return r.keys[keyID], nil
+}
+
+func TestVerify_KeyRotation(t *testing.T) {
+	oldPub, oldPriv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	newPub, newPriv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	_, otherPriv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+
+	signed := func(t *testing.T, pk ed25519.PrivateKey) *Message {
+		s := NewSigner(WithSignEd25519("k1", pk), WithSignFields("@method", "@authority"))
+		msg := MessageFromRequest(testReq())
+		hdr, err := s.Sign(msg)
+		assert.NoError(t, err)
+		msg.Header = hdr
+		return msg
+	}
+
+	t.Run("accepts either key while rotating", func(t *testing.T) {
+		resolver := &rotatingResolver{map[string][]VerifyingKey{
+			"k1": {&Ed25519VerifyingKey{oldPub, "k1"}, &Ed25519VerifyingKey{newPub, "k1"}},
+		}}
+		var events []VerifyEvent
+		v := NewVerifier(WithVerifyingKeyResolver(resolver), WithVerifyObserver(func(ev VerifyEvent) { events = append(events, ev) }))
+
+		assert.NoError(t, v.Verify(signed(t, oldPriv)))
+		assert.NoError(t, v.Verify(signed(t, newPriv)))
+		assert.ErrorIs(t, v.Verify(signed(t, otherPriv)), ErrSignatureInvalid)
+		assert.Len(t, events, 3)
+		assert.Equal(t, "k1", events[1].KeyID)
+	})
+	t.Run("rejects the old key once rotated", func(t *testing.T) {
+		resolver := &rotatingResolver{map[string][]VerifyingKey{
+			"k1": {&Ed25519VerifyingKey{newPub, "k1"}},
+		}}
+		v := NewVerifier(WithVerifyingKeyResolver(resolver))
+
+		assert.ErrorIs(t, v.Verify(signed(t, oldPriv)), ErrSignatureInvalid)
+		assert.NoError(t, v.Verify(signed(t, newPriv)))
+	})
+	t.Run("verifies with a single key resolver", func(t *testing.T) {
+		v := NewVerifier(WithVerifyingKeyResolver(&contextResolver{key: &Ed25519VerifyingKey{newPub, "k1"}}))
+
+		assert.NoError(t, v.Verify(signed(t, newPriv)))
+		assert.ErrorIs(t, v.Verify(signed(t, oldPriv)), ErrSignatureInvalid)
+	})
+	t.Run("ignores unknown key ids", func(t *testing.T) {
+		v := NewVerifier(WithVerifyingKeyResolver(&rotatingResolver{}), WithVerifyAll(true))
+
+		assert.ErrorIs(t, v.Verify(signed(t, newPriv)), ErrUnknownKeyID)
+	})
+}
+
+func TestVerify_KeyUntil(t *testing.T) {
+	oldPub, oldPriv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	newPub, newPriv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+
+	created := time.Unix(1618884473, 0)
+	until := created.Add(time.Hour)
+	signed := func(t *testing.T, opts ...signOption) *Message {
+		s := NewSigner(append([]signOption{WithSignFields("@method", "@authority"), WithSignParamValues(&SignatureParameters{Created: &created})}, opts...)...)
+		msg := MessageFromRequest(testReq())
+		hdr, err := s.Sign(msg)
+		assert.NoError(t, err)
+		msg.Header = hdr
+		return msg
+	}
+	verifier := func(now time.Time, opts ...verifyOption) *Verifier {
+		return NewVerifier(append([]verifyOption{
+			WithVerifyKeyUntil("old", &Ed25519VerifyingKey{oldPub, "old"}, until),
+			WithVerifyEd25519("new", newPub),
+			withClock(&testClock{now: now}),
+		}, opts...)...)
+	}
+	old := WithSignEd25519("old", oldPriv)
+
+	t.Run("accepts the key until the cutoff", func(t *testing.T) {
+		for _, now := range []time.Time{created, until.Add(-time.Second), until} {
+			assert.NoError(t, verifier(now).Verify(signed(t, old)), now)
+		}
+	})
+	t.Run("rejects the key after the cutoff", func(t *testing.T) {
+		for _, now := range []time.Time{until.Add(time.Second), until.Add(24 * time.Hour)} {
+			assert.ErrorIs(t, verifier(now).Verify(signed(t, old)), ErrKeyExpired, now)
+		}
+	})
+	t.Run("still rejects an invalid signature before the cutoff", func(t *testing.T) {
+		_, otherPriv, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err)
+		assert.ErrorIs(t, verifier(created).Verify(signed(t, WithSignEd25519("old", otherPriv))), ErrSignatureInvalid)
+	})
+	t.Run("verifies another signature after the cutoff", func(t *testing.T) {
+		msg := signed(t, old, WithSignName("a"), WithSignSignature(WithSignEd25519("new", newPriv), WithSignName("b"),
+			WithSignFields("@method"), WithSignParamValues(&SignatureParameters{Created: &created})))
+		assert.NoError(t, verifier(until.Add(time.Second)).Verify(msg))
+		assert.ErrorIs(t, verifier(until.Add(time.Second), WithVerifyAll(true)).Verify(msg), ErrKeyExpired)
+	})
+	t.Run("accepts the other keys for the key id after the cutoff", func(t *testing.T) {
+		secret := []byte("test-shared-secret")
+		v := verifier(until.Add(time.Second), WithHmacSha256("old", secret))
+		assert.NoError(t, v.Verify(signed(t, WithHmacSha256("old", secret))))
+		assert.ErrorIs(t, v.Verify(signed(t, old)), ErrKeyExpired)
+	})
+}
+
+// forgedAlgSigningKey signs with the wrapped key but declares a different algorithm
+type forgedAlgSigningKey struct {
+	SigningKey
+	alg Algorithm
+}
+
+func (k *forgedAlgSigningKey) GetAlgorithm() Algorithm {
+	return k.alg
+}
+
+func TestVerify_WithoutAlg(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+
+	s := NewSigner(
+		WithSignEd25519("test-key-ed25519", priv),
+		WithSignFields("@method", "@authority"),
+		WithSignParams(ParamCreated, ParamKeyID),
+	)
+	msg := MessageFromRequest(testReq())
+	hdr, err := s.Sign(msg)
+	assert.NoError(t, err)
+	assert.NotContains(t, hdr.Get(SignatureInputHeader), "alg")
+	msg.Header = hdr
+
+	t.Run("uses the algorithm of the resolved key", func(t *testing.T) {
+		var ev VerifyEvent
+		v := NewVerifier(
+			WithVerifyingKeyResolver(&contextResolver{key: &Ed25519VerifyingKey{pub, "test-key-ed25519"}}),
+			WithVerifyObserver(func(e VerifyEvent) { ev = e }),
+		)
+		assert.NoError(t, v.Verify(msg))
+		assert.Equal(t, AlgorithmEd25519, ev.Algorithm)
+	})
+	t.Run("fails with a key of another algorithm", func(t *testing.T) {
+		v := NewVerifier(
+			WithVerifyingKeyResolver(&contextResolver{key: &HmacSha256VerifyingKey{Secret: k, KeyID: "test-key-ed25519"}}),
+		)
+		assert.ErrorIs(t, v.Verify(msg), ErrSignatureInvalid)
+	})
+}
+
+func TestVerify_AlgorithmMismatch(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	v := NewVerifier(
+		WithHmacSha256("test-shared-secret", k),
+	)
+
+	t.Run("accepts the algorithm of the key", func(t *testing.T) {
+		s := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithSignFields("@method", "@authority"),
+		)
+		msg := MessageFromRequest(testReq())
+		hdr, err := s.Sign(msg)
+		assert.NoError(t, err)
+		assert.Contains(t, hdr.Get(SignatureInputHeader), `alg="hmac-sha256"`)
+
+		msg.Header = hdr
+		assert.NoError(t, v.Verify(msg))
+	})
+	t.Run("rejects a declared algorithm that differs from the key", func(t *testing.T) {
+		s := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithSignFields("@method", "@authority"),
+		)
+		// the signature itself is valid for the key, only the declared algorithm is forged
+		s.config.Key = &forgedAlgSigningKey{s.config.Key, AlgorithmEd25519}
+
+		msg := MessageFromRequest(testReq())
+		hdr, err := s.Sign(msg)
+		assert.NoError(t, err)
+		assert.Contains(t, hdr.Get(SignatureInputHeader), `alg="ed25519"`)
+
+		msg.Header = hdr
+		assert.ErrorIs(t, v.Verify(msg), ErrAlgorithmMismatch)
+	})
+}
+
+func TestVerify_AllowedAlgorithms(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	signed := func(t *testing.T, opts ...signOption) *Message {
+		s := NewSigner(append([]signOption{
+			WithHmacSha256("test-shared-secret", k),
+			WithSignFields("@method", "@authority"),
+		}, opts...)...)
+		msg := MessageFromRequest(testReq())
+		hdr, err := s.Sign(msg)
+		assert.NoError(t, err)
+		msg.Header = hdr
+		return msg
+	}
+
+	t.Run("allows every algorithm by default", func(t *testing.T) {
+		v := NewVerifier(WithHmacSha256("test-shared-secret", k))
+		assert.NoError(t, v.Verify(signed(t)))
+	})
+	t.Run("accepts an allowed algorithm", func(t *testing.T) {
+		v := NewVerifier(
+			WithHmacSha256("test-shared-secret", k),
+			WithVerifyAllowedAlgorithms(AlgorithmEd25519, AlgorithmHmacSha256),
+		)
+		assert.NoError(t, v.Verify(signed(t)))
+	})
+	t.Run("rejects a declared algorithm that isn't allowed", func(t *testing.T) {
+		v := NewVerifier(
+			WithHmacSha256("test-shared-secret", k),
+			WithVerifyAllowedAlgorithms(AlgorithmEd25519),
+		)
+		assert.ErrorIs(t, v.Verify(signed(t)), ErrAlgorithmNotAllowed)
+	})
+	t.Run("rejects a key algorithm that isn't allowed", func(t *testing.T) {
+		v := NewVerifier(
+			WithHmacSha256("test-shared-secret", k),
+			WithVerifyAllowedAlgorithms(AlgorithmEd25519),
+		)
+		assert.ErrorIs(t, v.Verify(signed(t, WithSignParams(ParamKeyID))), ErrAlgorithmNotAllowed)
+	})
+}
+
+func TestVerify_SignatureSelector(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+
+	s := NewSigner(
+		WithHmacSha256("test-shared-secret", k),
+		WithSignName("hmac"),
+		WithSignFields("@method", "@authority"),
+		WithSignParams(ParamKeyID, ParamAlg),
+		WithSignSignature(
+			WithSignEd25519("test-key-ed25519", priv),
+			WithSignName("ed"),
+			WithSignFields("@method", "@authority"),
+			WithSignParams(ParamKeyID, ParamAlg),
+		),
+	)
+	msg := MessageFromRequest(testReq())
+	hdr, err := s.Sign(msg)
+	assert.NoError(t, err)
+	msg.Header = hdr
+
+	preferEd25519 := func(inputs []SignatureInput) (string, bool) {
+		for _, in := range inputs {
+			if in.Alg != nil && *in.Alg == AlgorithmEd25519 {
+				return in.Name, true
+			}
+		}
+		return "", false
+	}
+
+	t.Run("verifies the selected signature", func(t *testing.T) {
+		var names []string
+		v := NewVerifier(
+			WithHmacSha256("test-shared-secret", k),
+			WithVerifyEd25519("test-key-ed25519", pub),
+			WithVerifySignatureSelector(func(inputs []SignatureInput) (string, bool) {
+				for _, in := range inputs {
+					names = append(names, in.Name)
+				}
+				return preferEd25519(inputs)
+			}),
+			WithVerifyObserver(func(ev VerifyEvent) { assert.Equal(t, "ed", ev.Name) }),
+		)
+		assert.NoError(t, v.Verify(msg))
+		assert.Equal(t, []string{"hmac", "ed"}, names)
+	})
+	t.Run("requires the selected signature to be verifiable", func(t *testing.T) {
+		v := NewVerifier(
+			WithHmacSha256("test-shared-secret", k),
+			WithVerifySignatureSelector(preferEd25519),
+		)
+		assert.ErrorIs(t, v.Verify(msg), ErrUnknownKeyID)
+	})
+	t.Run("requires the selected signature to be valid", func(t *testing.T) {
+		other, _, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err)
+		v := NewVerifier(
+			WithHmacSha256("test-shared-secret", k),
+			WithVerifyEd25519("test-key-ed25519", other),
+			WithVerifySignatureSelector(preferEd25519),
+		)
+		assert.ErrorIs(t, v.Verify(msg), ErrSignatureInvalid)
+	})
+	t.Run("fails if no signature is selected", func(t *testing.T) {
+		v := NewVerifier(
+			WithHmacSha256("test-shared-secret", k),
+			WithVerifySignatureSelector(func([]SignatureInput) (string, bool) { return "", false }),
+		)
+		assert.ErrorIs(t, v.Verify(msg), ErrNoSignature)
+	})
+	t.Run("fails if an unknown signature is selected", func(t *testing.T) {
+		v := NewVerifier(
+			WithHmacSha256("test-shared-secret", k),
+			WithVerifySignatureSelector(func([]SignatureInput) (string, bool) { return "missing", true }),
+		)
+		assert.ErrorIs(t, v.Verify(msg), ErrNoSignature)
+	})
+}
+
+// tagResolver resolves keys for signatures without a key id by their tag
+type tagResolver struct {
+	keys map[string]VerifyingKey
+}
+
+func (r *tagResolver) Resolve(ctx context.Context, keyID string) (VerifyingKey, error) {
+	return nil, nil
+}
+
+func (r *tagResolver) ResolveTag(ctx context.Context, tag string) (VerifyingKey, error) {
+	return r.keys[tag], nil
+}
+
+func TestVerify_WithoutKeyID(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	tag := "payments"
+	s := NewSigner(
+		WithHmacSha256("test-shared-secret", k),
+		WithSignFields("@method", "@authority"),
+		WithSignParams(ParamCreated, ParamTag),
+		WithSignParamValues(&SignatureParameters{Tag: &tag}),
+	)
+	msg := MessageFromRequest(testReq())
+	hdr, err := s.Sign(msg)
+	assert.NoError(t, err)
+	assert.NotContains(t, hdr.Get(SignatureInputHeader), "keyid")
+	msg.Header = hdr
+
+	t.Run("isn't verified by default", func(t *testing.T) {
+		v := NewVerifier(
+			WithHmacSha256("test-shared-secret", k),
+			WithVerifyAll(true),
+		)
+		assert.ErrorIs(t, v.Verify(msg), ErrUnknownKeyID)
+	})
+	t.Run("verifies with the default key id", func(t *testing.T) {
+		var ev VerifyEvent
+		v := NewVerifier(
+			WithHmacSha256("test-shared-secret", k),
+			WithVerifyDefaultKeyID("test-shared-secret"),
+			WithVerifyAll(true),
+			WithVerifyObserver(func(e VerifyEvent) { ev = e }),
+		)
+		assert.NoError(t, v.Verify(msg))
+		assert.Equal(t, "test-shared-secret", ev.KeyID)
+	})
+	t.Run("verifies with a key resolved by tag", func(t *testing.T) {
+		v := NewVerifier(
+			WithVerifyingKeyResolver(&tagResolver{keys: map[string]VerifyingKey{
+				"payments": &HmacSha256VerifyingKey{Secret: k, KeyID: "test-shared-secret"},
+			}}),
+			WithVerifyAll(true),
+		)
+		assert.NoError(t, v.Verify(msg))
+	})
+	t.Run("fails if the tag isn't known", func(t *testing.T) {
+		v := NewVerifier(
+			WithVerifyingKeyResolver(&tagResolver{}),
+			WithVerifyAll(true),
+		)
+		assert.ErrorIs(t, v.Verify(msg), ErrUnknownKeyID)
+	})
+	t.Run("requires a key id for other resolvers", func(t *testing.T) {
+		v := NewVerifier(
+			WithVerifyingKeyResolver(&contextResolver{key: &HmacSha256VerifyingKey{Secret: k, KeyID: "test-shared-secret"}}),
+		)
+		assert.ErrorIs(t, v.Verify(msg), ErrMalformedSignature)
+	})
+}
+
+func TestVerifier_SignatureBase(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	created := time.Unix(1618884473, 0)
+	s := NewSigner(
+		WithHmacSha256("test-shared-secret", k),
+		WithSignFields("@method", "@authority"),
+		WithSignParamValues(&SignatureParameters{Created: &created}),
+		WithSignParams(ParamKeyID, ParamCreated),
+	)
+	msg := MessageFromRequest(testReq())
+	hdr, err := s.Sign(msg)
+	assert.NoError(t, err)
+	msg.Header = hdr
+
+	v := NewVerifier(WithHmacSha256("test-shared-secret", k))
+
+	t.Run("returns the base as verified", func(t *testing.T) {
+		base, err := v.SignatureBase(msg, "sig")
+		assert.NoError(t, err)
+		assert.Equal(t, `"@method": POST
+"@authority": example.com
+"@signature-params": ("@method" "@authority");created=1618884473;keyid="test-shared-secret"`, base)
+	})
+	t.Run("keeps the signature input as received", func(t *testing.T) {
+		received := MessageFromRequest(testReq())
+		received.Header.Set(SignatureInputHeader, `sig=("@method" "@authority");keyid="test-shared-secret";created=1618884473`)
+
+		base, err := v.SignatureBase(received, "sig")
+		assert.NoError(t, err)
+		assert.Equal(t, `"@method": POST
+"@authority": example.com
+"@signature-params": ("@method" "@authority");keyid="test-shared-secret";created=1618884473`, base)
+	})
+	t.Run("shows why a signature is invalid", func(t *testing.T) {
+		tampered := *msg
+		tampered.Method = "PUT"
+		assert.ErrorIs(t, v.Verify(&tampered), ErrSignatureInvalid)
+
+		base, err := v.SignatureBase(&tampered, "sig")
+		assert.NoError(t, err)
+		assert.Contains(t, base, `"@method": PUT`)
+	})
+	t.Run("redacts fields", func(t *testing.T) {
+		for cavage, target := range map[bool]string{false: "@method", true: "(request-target)"} {
+			s := NewSigner(
+				WithHmacSha256("test-shared-secret", k),
+				WithSignFields(target, "authorization"),
+				WithCavageCompat(cavage),
+			)
+			msg := MessageFromRequest(testReq())
+			msg.Header.Set("Authorization", "Bearer secret-token")
+			hdr, err := s.Sign(msg)
+			assert.NoError(t, err)
+			msg.Header = hdr
+
+			v := NewVerifier(
+				WithHmacSha256("test-shared-secret", k),
+				WithVerifyRedactedFields("Authorization"),
+				WithCavageCompat(cavage),
+			)
+			assert.NoError(t, v.Verify(msg))
+
+			base, err := v.SignatureBase(msg, "sig")
+			assert.NoError(t, err)
+			assert.NotContains(t, base, "secret-token")
+			assert.Contains(t, base, "authorization")
+			assert.Contains(t, base, ": [redacted]")
+
+			// the real value is still covered
+			tampered := *msg
+			tampered.Header = hdr.Clone()
+			tampered.Header.Set("Authorization", "Bearer other-token")
+			assert.ErrorIs(t, v.Verify(&tampered), ErrSignatureInvalid)
+		}
+	})
+	t.Run("fails for an unknown signature", func(t *testing.T) {
+		_, err := v.SignatureBase(msg, "other")
+		assert.ErrorIs(t, err, ErrNoSignature)
+		_, err = v.SignatureBase(MessageFromRequest(testReq()), "sig")
+		assert.ErrorIs(t, err, ErrNoSignature)
+	})
+}
+
+func TestVerify_RequireLowS(t *testing.T) {
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384()} {
+		t.Run(curve.Params().Name, func(t *testing.T) {
+			pk, err := ecdsa.GenerateKey(curve, rand.Reader)
+			assert.NoError(t, err)
+
+			var signOpt signOption
+			var verifyOpt verifyOption
+			if curve == elliptic.P256() {
+				signOpt, verifyOpt = WithSignEcdsaP256Sha256("test-key-ecc", pk), WithVerifyEcdsaP256Sha256("test-key-ecc", &pk.PublicKey)
+			} else {
+				signOpt, verifyOpt = WithSignEcdsaP384Sha384("test-key-ecc", pk), WithVerifyEcdsaP384Sha384("test-key-ecc", &pk.PublicKey)
+			}
+
+			s := NewSigner(signOpt, WithSignName("sig"), WithSignFields("@method", "@authority"))
+			msg := MessageFromRequest(testReq())
+			hdr, err := s.Sign(msg)
+			assert.NoError(t, err)
+			msg.Header = hdr
+
+			// the signer only makes low s signatures, so malleate one by negating s
+			dict, err := httpsfv.UnmarshalDictionary(hdr.Values(SignatureHeader))
+			assert.NoError(t, err)
+			member, _ := dict.Get("sig")
+			sig := member.(httpsfv.Item).Value.([]byte)
+			size := len(sig) / 2
+			n := curve.Params().N
+			low := new(big.Int).SetBytes(sig[size:])
+			assert.LessOrEqual(t, low.Cmp(new(big.Int).Rsh(n, 1)), 0, "signatures must have a low s value")
+
+			malleated := append(slices.Clone(sig[:size]), new(big.Int).Sub(n, low).FillBytes(make([]byte, size))...)
+			high := *msg
+			high.Header = hdr.Clone()
+			high.Header.Set(SignatureHeader, "sig=:"+base64.StdEncoding.EncodeToString(malleated)+":")
+
+			t.Run("accepts high s by default", func(t *testing.T) {
+				v := NewVerifier(verifyOpt)
+				assert.NoError(t, v.Verify(msg))
+				assert.NoError(t, v.Verify(&high))
+			})
+			t.Run("rejects high s when required", func(t *testing.T) {
+				v := NewVerifier(verifyOpt, WithVerifyRequireLowS(true))
+				assert.NoError(t, v.Verify(msg))
+				err := v.Verify(&high)
+				assert.ErrorIs(t, err, ErrSignatureInvalid)
+				assert.ErrorContains(t, err, "high s value")
+			})
+		})
+	}
+}
+
+func TestVerify_SignatureLength(t *testing.T) {
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.NoError(t, err)
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		signOpt   signOption
+		verifyOpt verifyOption
+		size      int
+	}{
+		{"ecdsa-p256-sha256", WithSignEcdsaP256Sha256("test-key", p256), WithVerifyEcdsaP256Sha256("test-key", &p256.PublicKey), 64},
+		{"ecdsa-p384-sha384", WithSignEcdsaP384Sha384("test-key", p384), WithVerifyEcdsaP384Sha384("test-key", &p384.PublicKey), 96},
+		{"ed25519", WithSignEd25519("test-key", edPriv), WithVerifyEd25519("test-key", edPub), ed25519.SignatureSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSigner(tt.signOpt, WithSignName("sig"), WithSignFields("@method", "@authority"))
+			v := NewVerifier(tt.verifyOpt)
+			msg := MessageFromRequest(testReq())
+			hdr, err := s.Sign(msg)
+			assert.NoError(t, err)
+
+			dict, err := httpsfv.UnmarshalDictionary(hdr.Values(SignatureHeader))
+			assert.NoError(t, err)
+			member, _ := dict.Get("sig")
+			sig := member.(httpsfv.Item).Value.([]byte)
+			assert.Len(t, sig, tt.size)
+
+			msg.Header = hdr
+			assert.NoError(t, v.Verify(msg))
+
+			// every truncation and a padded signature are malformed rather than invalid
+			lengths := []int{tt.size + 1}
+			for n := 0; n < tt.size; n++ {
+				lengths = append(lengths, n)
+			}
+			for _, n := range lengths {
+				bad := append(slices.Clone(sig), 0)[:n]
+				m := *msg
+				m.Header = hdr.Clone()
+				m.Header.Set(SignatureHeader, "sig=:"+base64.StdEncoding.EncodeToString(bad)+":")
+				err := v.Verify(&m)
+				assert.ErrorIs(t, err, ErrMalformedSignature, "length %d", n)
+				assert.NotErrorIs(t, err, ErrSignatureInvalid, "length %d", n)
+			}
+		})
+	}
+}
+
+func TestHmacSha256VerifyingKey(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	assert.NoError(t, err)
+	data := []byte("\"@method\": POST")
+	sig, err := (&HmacSha256SigningKey{Secret: k, KeyID: "test-shared-secret"}).Sign(data)
+	assert.NoError(t, err)
+
+	key := &HmacSha256VerifyingKey{Secret: k, KeyID: "test-shared-secret"}
+	assert.NoError(t, key.Verify(data, sig))
+
+	// a difference in any byte is rejected, however far into the signature it is
+	for i := range sig {
+		bad := slices.Clone(sig)
+		bad[i] ^= 0x01
+		assert.ErrorIs(t, key.Verify(data, bad), ErrSignatureInvalid, "byte %d", i)
+	}
+	assert.ErrorIs(t, key.Verify(data, sig[:len(sig)-1]), ErrSignatureInvalid)
+	assert.ErrorIs(t, key.Verify(data, append(slices.Clone(sig), 0)), ErrSignatureInvalid)
+	assert.ErrorIs(t, key.Verify(data, nil), ErrSignatureInvalid)
+}
+
+func TestEd25519phVerifyingKey(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	data := []byte("\"@method\": POST")
+	sig, err := (&Ed25519phSigningKey{PrivateKey: priv, KeyID: "test-key-ed25519ph"}).Sign(data)
+	assert.NoError(t, err)
+
+	key := &Ed25519phVerifyingKey{PublicKey: pub, KeyID: "test-key-ed25519ph"}
+	assert.NoError(t, key.Verify(data, sig))
+	assert.ErrorIs(t, key.Verify([]byte("\"@method\": GET"), sig), ErrSignatureInvalid)
+
+	// the pre-hashed and pure variants don't verify each other's signatures
+	assert.ErrorIs(t, (&Ed25519VerifyingKey{PublicKey: pub}).Verify(data, sig), ErrSignatureInvalid)
+	assert.ErrorIs(t, key.Verify(data, ed25519.Sign(priv, data)), ErrSignatureInvalid)
+}
+
+func TestVerifyDetached(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	s := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields("@method", "@authority"))
+	v := NewVerifier(WithHmacSha256("test-shared-secret", k))
+
+	t.Run("verifies the base of a message", func(t *testing.T) {
+		msg := MessageFromRequest(testReq())
+		hdr, err := s.Sign(msg)
+		assert.NoError(t, err)
+		msg.Header = hdr
+
+		base, err := v.SignatureBase(msg, "sig")
+		assert.NoError(t, err)
+		sig, err := s.SignDetached([]byte(base))
+		assert.NoError(t, err)
+		assert.Equal(t, "sig=:"+base64.StdEncoding.EncodeToString(sig)+":", hdr.Get(SignatureHeader))
+
+		inputs, err := ParseSignatureHeaders(hdr)
+		assert.NoError(t, err)
+		keyID, err := v.VerifyDetached([]byte(base), "sig", sig, inputs[0].SignatureParameters)
+		assert.NoError(t, err)
+		assert.Equal(t, "test-shared-secret", keyID)
+
+		_, err = v.VerifyDetached([]byte(base+" "), "sig", sig, inputs[0].SignatureParameters)
+		assert.ErrorIs(t, err, ErrSignatureInvalid)
+	})
+	t.Run("checks the key and times of the signature", func(t *testing.T) {
+		base := []byte("\"@method\": POST")
+		sig, err := s.SignDetached(base)
+		assert.NoError(t, err)
+
+		keyID := "other-key"
+		_, err = v.VerifyDetached(base, "sig", sig, SignatureParameters{KeyID: &keyID})
+		assert.ErrorIs(t, err, ErrUnknownKeyID)
+
+		keyID = "test-shared-secret"
+		expires := time.Now().Add(-time.Hour)
+		_, err = v.VerifyDetached(base, "sig", sig, SignatureParameters{KeyID: &keyID, Expires: &expires})
+		assert.ErrorIs(t, err, ErrSignatureExpired)
+
+		alg := AlgorithmEd25519
+		_, err = v.VerifyDetached(base, "sig", sig, SignatureParameters{KeyID: &keyID, Alg: &alg})
+		assert.Error(t, err)
+	})
+	t.Run("selects keys with the key func", func(t *testing.T) {
+		base := []byte("\"@method\": POST")
+		sig, err := s.SignDetached(base)
+		assert.NoError(t, err)
+
+		var got SignatureInput
+		v := NewVerifier(
+			WithHmacSha256("test-shared-secret", []byte("static-shared-secret")),
+			WithVerifyKeyFunc(func(msg *Message, input SignatureInput) (VerifyingKey, error) {
+				assert.Empty(t, msg.URL.Path)
+				got = input
+				return &HmacSha256VerifyingKey{Secret: k, KeyID: *input.KeyID}, nil
+			}),
+		)
+		keyID := "test-shared-secret"
+		_, err = v.VerifyDetached(base, "sig", sig, SignatureParameters{KeyID: &keyID})
+		assert.NoError(t, err)
+		assert.Equal(t, SignatureInput{SignatureParameters: SignatureParameters{KeyID: &keyID}, Name: "sig"}, got)
+
+		v = NewVerifier(
+			WithHmacSha256("test-shared-secret", k),
+			WithVerifyKeyFunc(func(msg *Message, input SignatureInput) (VerifyingKey, error) { return nil, nil }),
+		)
+		_, err = v.VerifyDetached(base, "sig", sig, SignatureParameters{KeyID: &keyID})
+		assert.ErrorIs(t, err, ErrUnknownKeyID)
+	})
+	t.Run("requires a key to sign", func(t *testing.T) {
+		_, err := NewSigner().SignDetached([]byte("\"@method\": POST"))
+		assert.EqualError(t, err, "signer not configured")
+	})
+}
+
+func TestVerifyKeyFunc(t *testing.T) {
+	tenantA, tenantB := []byte("tenant-a-shared-secret"), []byte("tenant-b-shared-secret")
+
+	// both tenants use the same key id with their own secret
+	keyFunc := func(msg *Message, input SignatureInput) (VerifyingKey, error) {
+		assert.Equal(t, []string{`"@method"`, `"@path"`}, input.Fields)
+		switch {
+		case strings.HasPrefix(msg.URL.Path, "/a/"):
+			return &HmacSha256VerifyingKey{Secret: tenantA, KeyID: *input.KeyID}, nil
+		case strings.HasPrefix(msg.URL.Path, "/b/"):
+			return &HmacSha256VerifyingKey{Secret: tenantB, KeyID: *input.KeyID}, nil
+		}
+		return nil, nil
+	}
+	v := NewVerifier(WithHmacSha256("key", []byte("static-shared-secret")), WithVerifyKeyFunc(keyFunc))
+
+	signed := func(t *testing.T, secret []byte, path string) *http.Request {
+		s := NewSigner(WithHmacSha256("key", secret), WithSignFields("@method", "@path"))
+		req, err := http.NewRequest("GET", "https://example.com"+path, nil)
+		assert.NoError(t, err)
+		assert.NoError(t, s.SignRequest(req))
+		return req
+	}
+
+	t.Run("selects the key by path", func(t *testing.T) {
+		assert.NoError(t, v.VerifyRequest(signed(t, tenantA, "/a/foo")))
+		assert.NoError(t, v.VerifyRequest(signed(t, tenantB, "/b/foo")))
+	})
+	t.Run("rejects the key of another tenant", func(t *testing.T) {
+		assert.ErrorIs(t, v.VerifyRequest(signed(t, tenantA, "/b/foo")), ErrSignatureInvalid)
+	})
+	t.Run("takes precedence over the static keys", func(t *testing.T) {
+		assert.ErrorIs(t, v.VerifyRequest(signed(t, []byte("static-shared-secret"), "/a/foo")), ErrSignatureInvalid)
+		assert.ErrorIs(t, v.VerifyRequest(signed(t, []byte("static-shared-secret"), "/c/foo")), ErrUnknownKeyID)
+	})
+	t.Run("fails with the error of the func", func(t *testing.T) {
+		errTenant := errors.New("unknown tenant")
+		v := NewVerifier(WithVerifyKeyFunc(func(msg *Message, input SignatureInput) (VerifyingKey, error) {
+			return nil, errTenant
+		}))
+		assert.ErrorIs(t, v.VerifyRequest(signed(t, tenantA, "/a/foo")), errTenant)
+	})
+	t.Run("checks the declared algorithm", func(t *testing.T) {
+		_, priv, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err)
+		s := NewSigner(WithSignEd25519("key", priv), WithSignFields("@method", "@path"), WithSignParams(ParamKeyID, ParamAlg))
+		req, err := http.NewRequest("GET", "https://example.com/a/foo", nil)
+		assert.NoError(t, err)
+		assert.NoError(t, s.SignRequest(req))
+		assert.Error(t, v.VerifyRequest(req))
+	})
+}
+
+// failingResolver fails to resolve some key ids, eg: as if a key server can't be reached
+type failingResolver struct {
+	keys map[string]VerifyingKey
+	err  error
+}
+
+func (r *failingResolver) Resolve(ctx context.Context, keyID string) (VerifyingKey, error) {
+	if key, ok := r.keys[keyID]; ok {
+		return key, nil
+	}
+	return nil, r.err
+}
+
+func TestVerify_ResolverErrors(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	errUnavailable := errors.New("key server unavailable")
+	resolver := &failingResolver{
+		keys: map[string]VerifyingKey{"test-shared-secret": &HmacSha256VerifyingKey{Secret: k, KeyID: "test-shared-secret"}},
+		err:  errUnavailable,
+	}
+
+	// a signature with a key id the resolver fails for, then one it knows
+	msg := MessageFromRequest(testReq())
+	for _, keyID := range []string{"remote-key", "test-shared-secret"} {
+		hdr, err := NewSigner(WithHmacSha256(keyID, k), WithSignFields("@method")).Sign(msg)
+		assert.NoError(t, err)
+		msg.Header = hdr
+	}
+
+	t.Run("fails closed by default", func(t *testing.T) {
+		err := NewVerifier(WithVerifyingKeyResolver(resolver)).Verify(msg)
+		assert.ErrorIs(t, err, ErrKeyResolution)
+		assert.ErrorIs(t, err, errUnavailable)
+		assert.NotErrorIs(t, err, ErrUnknownKeyID)
+	})
+	t.Run("treats errors as unknown key ids", func(t *testing.T) {
+		v := NewVerifier(WithVerifyingKeyResolver(resolver), WithVerifyResolverErrorFailsClosed(false))
+		assert.NoError(t, v.Verify(msg))
+
+		v = NewVerifier(WithVerifyingKeyResolver(resolver), WithVerifyResolverErrorFailsClosed(false), WithVerifyAll(true))
+		assert.ErrorIs(t, v.Verify(msg), ErrUnknownKeyID)
+	})
+	t.Run("applies to key funcs", func(t *testing.T) {
+		keyFunc := WithVerifyKeyFunc(func(msg *Message, input SignatureInput) (VerifyingKey, error) {
+			return resolver.Resolve(msg.Context, *input.KeyID)
+		})
+		assert.ErrorIs(t, NewVerifier(keyFunc).Verify(msg), ErrKeyResolution)
+		assert.NoError(t, NewVerifier(keyFunc, WithVerifyResolverErrorFailsClosed(false)).Verify(msg))
+	})
+}
+
+// contextResolver records the context it was asked to resolve a key with
+type contextResolver struct {
+	key VerifyingKey
+	ctx context.Context
+}
+
+func (r *contextResolver) Resolve(ctx context.Context, keyID string) (VerifyingKey, error) {
+	r.ctx = ctx
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r.key, nil
+}
+
+func TestVerifyRequestContext(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	s := NewSigner(
+		WithHmacSha256("test-shared-secret", k),
+		WithSignFields("@method"),
+	)
+	resolver := &contextResolver{key: &HmacSha256VerifyingKey{k, "test-shared-secret"}}
+	v := NewVerifier(WithVerifyingKeyResolver(resolver))
+
+	t.Run("resolves keys with the given context", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "https://example.com/foo", nil)
+		assert.NoError(t, err)
+		assert.NoError(t, s.SignRequest(req))
+
+		ctx := context.WithValue(context.Background(), ctxKey{}, "given")
+		assert.NoError(t, v.VerifyRequestContext(ctx, req))
+		assert.Equal(t, "given", resolver.ctx.Value(ctxKey{}))
+	})
+	t.Run("resolves keys with the request context by default", func(t *testing.T) {
+		ctx := context.WithValue(context.Background(), ctxKey{}, "request")
+		req, err := http.NewRequestWithContext(ctx, "GET", "https://example.com/foo", nil)
+		assert.NoError(t, err)
+		assert.NoError(t, s.SignRequest(req))
+
+		assert.NoError(t, v.VerifyRequest(req))
+		assert.Equal(t, "request", resolver.ctx.Value(ctxKey{}))
+	})
+	t.Run("fails when the resolver is cancelled", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "https://example.com/foo", nil)
+		assert.NoError(t, err)
+		assert.NoError(t, s.SignRequest(req))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		assert.ErrorIs(t, v.VerifyRequestContext(ctx, req), context.Canceled)
+	})
+}
+
+func TestVerifyRequest(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	body := "{\"hello\": \"world\"}\n"
+
+	s := NewSigner(
+		WithHmacSha256("test-shared-secret", k),
+		WithSignFields("@method", "content-digest"),
+		WithSignDigestAlgorithms(DigestAlgorithmSha256, DigestAlgorithmSha512),
+	)
+	v := NewVerifier(
+		WithHmacSha256("test-shared-secret", k),
+	)
+
+	signed := func(t *testing.T) *http.Request {
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		assert.NoError(t, s.SignRequest(req))
+		return req
+	}
+
+	t.Run("verifies the body digest", func(t *testing.T) {
+		req := signed(t)
+		assert.NoError(t, v.VerifyRequest(req))
+
+		// the body can still be read
+		read, err := io.ReadAll(req.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, body, string(read))
+	})
+	t.Run("rejects a modified body", func(t *testing.T) {
+		req := signed(t)
+		req.Body = io.NopCloser(bytes.NewBufferString("{\"hello\": \"moon\"}\n"))
+		assert.EqualError(t, v.VerifyRequest(req), "digest mismatch")
+	})
+	t.Run("accepts any supported algorithm", func(t *testing.T) {
+		s := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithSignFields("content-digest"),
+			WithSignDigestAlgorithms(DigestAlgorithmSha512),
+		)
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		assert.NoError(t, s.SignRequest(req))
+
+		assert.NoError(t, v.VerifyRequest(req))
+	})
+	t.Run("restricts the digest algorithms", func(t *testing.T) {
+		v := NewVerifier(
+			WithHmacSha256("test-shared-secret", k),
+			WithVerifyDigestAlgorithms(DigestAlgorithmSha512),
+		)
+		assert.NoError(t, v.VerifyRequest(signed(t)))
+
+		s := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithSignFields("content-digest"),
+		)
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		assert.NoError(t, s.SignRequest(req))
+
+		assert.ErrorIs(t, v.VerifyRequest(req), ErrDigestAlgorithmNotAllowed)
+	})
+	t.Run("only checks allowed digest algorithms", func(t *testing.T) {
+		v := NewVerifier(
+			WithHmacSha256("test-shared-secret", k),
+			WithVerifyDigestAlgorithms(DigestAlgorithmSha256),
+		)
+		req := signed(t)
+		req.Body = io.NopCloser(bytes.NewBufferString("{\"hello\": \"moon\"}\n"))
+		assert.ErrorIs(t, v.VerifyRequest(req), ErrDigestMismatch)
+
+		s := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithSignFields("@method", "content-digest"),
+		)
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		req.Header.Set(ContentDigestHeader, "md5=:aGVsbG8=:")
+		hdr, err := s.Sign(MessageFromRequest(req))
+		assert.NoError(t, err)
+		for k := range hdr {
+			req.Header.Set(k, hdr.Get(k))
+		}
+		assert.ErrorIs(t, v.VerifyRequest(req), ErrDigestAlgorithmNotAllowed)
+	})
+	t.Run("verifies a request signed without a digest", func(t *testing.T) {
+		s := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithSignFields("@method", "content-digest"),
+			WithSignDigestOnlyWhenBody(true),
+		)
+		req, err := http.NewRequest("GET", "https://example.com/foo", nil)
+		assert.NoError(t, err)
+		assert.NoError(t, s.SignRequest(req))
+
+		assert.NoError(t, v.VerifyRequest(req))
+	})
+	t.Run("verifies a request signed without any digest", func(t *testing.T) {
+		s := NewSigner(
+			WithHmacSha256("test-shared-secret", k),
+			WithSignFields("@method", "content-digest"),
+			WithSignSkipDigest(true),
+		)
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		assert.NoError(t, s.SignRequest(req))
+
+		assert.NoError(t, v.VerifyRequest(req))
+	})
+	t.Run("limits the body buffered", func(t *testing.T) {
+		v := NewVerifier(
+			WithHmacSha256("test-shared-secret", k),
+			WithBodyBufferLimit(int64(len(body))),
+		)
+		assert.NoError(t, v.VerifyRequest(signed(t)))
+
+		req := signed(t)
+		req.Body = io.NopCloser(bytes.NewBufferString(body + "!"))
+		assert.ErrorIs(t, v.VerifyRequest(req), ErrBodyTooLarge)
+	})
+	t.Run("leaves the body untouched without a covered digest", func(t *testing.T) {
+		s := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields("@method"))
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		assert.NoError(t, s.SignRequest(req))
+		assert.NotEmpty(t, req.Header.Get(ContentDigestHeader))
+
+		original := &countingReader{Reader: strings.NewReader(body)}
+		req.Body = io.NopCloser(original)
+		assert.NoError(t, v.VerifyRequest(req))
+		assert.Zero(t, original.reads)
+		assert.Equal(t, io.NopCloser(original), req.Body)
+	})
+	t.Run("verifies the body as it's read", func(t *testing.T) {
+		v := NewVerifier(WithHmacSha256("test-shared-secret", k), WithVerifyBeforeBody(true))
+
+		req := signed(t)
+		original := &countingReader{Reader: strings.NewReader(body)}
+		req.Body = io.NopCloser(original)
+		assert.NoError(t, v.VerifyRequest(req))
+		assert.Zero(t, original.reads)
+		read, err := io.ReadAll(req.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, body, string(read))
+
+		req = signed(t)
+		req.Body = io.NopCloser(bytes.NewBufferString("{\"hello\": \"moon\"}\n"))
+		assert.NoError(t, v.VerifyRequest(req))
+		_, err = io.ReadAll(req.Body)
+		assert.ErrorIs(t, err, ErrDigestMismatch)
+
+		req = signed(t)
+		req.Method = "PUT"
+		original = &countingReader{Reader: strings.NewReader(body)}
+		req.Body = io.NopCloser(original)
+		assert.Error(t, v.VerifyRequest(req))
+		assert.Zero(t, original.reads)
+	})
+	t.Run("rejects an invalid signature before reading the body", func(t *testing.T) {
+		req := signed(t)
+		req.Method = "PUT"
+		assert.Error(t, v.VerifyRequest(req))
+	})
+}
+
+func TestVerifyRequestResult(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	body := "{\"hello\": \"world\"}\n"
+	v := NewVerifier(WithHmacSha256("test-shared-secret", k))
+
+	t.Run("verifies a covered digest", func(t *testing.T) {
+		s := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields("@method", "content-digest"))
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		assert.NoError(t, s.SignRequest(req))
+
+		inputs, err := ParseSignatureHeaders(req.Header)
+		assert.NoError(t, err)
+		result, err := v.VerifyRequestResult(req)
+		assert.NoError(t, err)
+		assert.Equal(t, VerificationResult{Signatures: inputs, BodyCovered: true, DigestVerified: true}, result)
+	})
+	t.Run("doesn't verify an uncovered digest", func(t *testing.T) {
+		s := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields("@method"))
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		assert.NoError(t, s.SignRequest(req))
+		assert.NotEmpty(t, req.Header.Get(ContentDigestHeader))
+
+		inputs, err := ParseSignatureHeaders(req.Header)
+		assert.NoError(t, err)
+		result, err := v.VerifyRequestResult(req)
+		assert.NoError(t, err)
+		assert.Equal(t, VerificationResult{Signatures: inputs}, result)
+	})
+	t.Run("doesn't cover the body of a bodyless request", func(t *testing.T) {
+		s := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields("@method"), WithSignSkipDigest(true))
+		req, err := http.NewRequest("GET", "https://example.com/foo", nil)
+		assert.NoError(t, err)
+		assert.NoError(t, s.SignRequest(req))
+
+		result, err := v.VerifyRequestResult(req)
+		assert.NoError(t, err)
+		assert.False(t, result.BodyCovered)
+		assert.False(t, result.DigestVerified)
+	})
+	t.Run("reports nothing when verification fails", func(t *testing.T) {
+		s := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields("@method", "content-digest"))
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		assert.NoError(t, s.SignRequest(req))
+		req.Body = io.NopCloser(bytes.NewBufferString("{\"hello\": \"moon\"}\n"))
+
+		result, err := v.VerifyRequestResult(req)
+		assert.ErrorIs(t, err, ErrDigestMismatch)
+		assert.Equal(t, VerificationResult{}, result)
+	})
+	t.Run("covers the body in cavage compatibility mode", func(t *testing.T) {
+		s := NewSigner(WithHmacSha256("test-shared-secret", k), WithCavageCompat(true), WithSignFields("(request-target)", "content-digest"))
+		v := NewVerifier(WithHmacSha256("test-shared-secret", k), WithCavageCompat(true))
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		assert.NoError(t, s.SignRequest(req))
+
+		result, err := v.VerifyRequestResult(req)
+		assert.NoError(t, err)
+		assert.True(t, result.BodyCovered)
+		assert.True(t, result.DigestVerified)
+		if assert.Len(t, result.Signatures, 1) {
+			assert.Empty(t, result.Signatures[0].Name)
+			assert.Equal(t, "test-shared-secret", *result.Signatures[0].KeyID)
+			assert.Equal(t, []string{`"(request-target)"`, `"content-digest"`}, result.Signatures[0].Fields)
+		}
+	})
+	t.Run("reports only the signatures verified", func(t *testing.T) {
+		s := NewSigner(
+			WithHmacSha256("unknown-key", k), WithSignName("other"), WithSignFields("@method"),
+			WithSignSignature(WithHmacSha256("test-shared-secret", k), WithSignFields("@method", "@path")),
+		)
+		req, err := http.NewRequest("GET", "https://example.com/foo", nil)
+		assert.NoError(t, err)
+		assert.NoError(t, s.SignRequest(req))
+
+		result, err := v.VerifyRequestResult(req)
+		assert.NoError(t, err)
+		if assert.Len(t, result.Signatures, 1) {
+			assert.Equal(t, "sig", result.Signatures[0].Name)
+			assert.Equal(t, "test-shared-secret", *result.Signatures[0].KeyID)
+			assert.Equal(t, []string{`"@method"`, `"@path"`}, result.Signatures[0].Fields)
+		}
+	})
+}
+
+func TestVerify_UnsignedDigest(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	signed := func(t *testing.T, fields ...string) *http.Request {
+		s := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields(fields...))
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString("{}"))
+		assert.NoError(t, err)
+		assert.NoError(t, s.SignRequest(req))
+		assert.NotEmpty(t, req.Header.Get(ContentDigestHeader))
+		return req
+	}
+	lenient := NewVerifier(WithHmacSha256("test-shared-secret", k))
+	strict := NewVerifier(WithHmacSha256("test-shared-secret", k), WithVerifyAllowUnsignedDigest(false))
+
+	t.Run("ignores an unsigned digest by default", func(t *testing.T) {
+		assert.NoError(t, lenient.VerifyRequest(signed(t, "@method")))
+	})
+	t.Run("rejects an unsigned digest", func(t *testing.T) {
+		assert.ErrorIs(t, strict.VerifyRequest(signed(t, "@method")), ErrUnsignedDigest)
+
+		req := signed(t, "@method")
+		req.Header.Del(ContentDigestHeader)
+		assert.NoError(t, strict.VerifyRequest(req))
+	})
+	t.Run("verifies a signed digest", func(t *testing.T) {
+		assert.NoError(t, strict.VerifyRequest(signed(t, "@method", "content-digest")))
+
+		req := signed(t, "@method", "content-digest")
+		req.Body = io.NopCloser(bytes.NewBufferString("{\"hello\": \"world\"}"))
+		assert.ErrorIs(t, strict.VerifyRequest(req), ErrDigestMismatch)
+	})
+	t.Run("rejects a stripped signed digest", func(t *testing.T) {
+		for _, v := range []*Verifier{lenient, strict} {
+			req := signed(t, "@method", "content-digest")
+			req.Header.Del(ContentDigestHeader)
+			assert.ErrorIs(t, v.VerifyRequest(req), ErrMissingCoveredComponent)
+		}
+	})
+}
+
+func TestVerify_RequireDigestForContentTypes(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	request := func(t *testing.T, contentType string, digest bool, fields ...string) *http.Request {
+		opts := []signOption{WithHmacSha256("test-shared-secret", k), WithSignFields(fields...)}
+		if !digest {
+			opts = append(opts, WithSignSkipDigest(true))
+		}
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString("{}"))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", contentType)
+		assert.NoError(t, NewSigner(opts...).SignRequest(req))
+		return req
+	}
+	v := NewVerifier(WithHmacSha256("test-shared-secret", k), WithVerifyRequireDigestForContentTypes("application/json"))
+
+	t.Run("rejects a listed type without a digest", func(t *testing.T) {
+		assert.ErrorIs(t, v.VerifyRequest(request(t, "application/json", false, "@method")), ErrDigestRequired)
+		assert.ErrorIs(t, v.VerifyRequest(request(t, "Application/JSON; charset=utf-8", false, "@method")), ErrDigestRequired)
+		assert.ErrorIs(t, v.VerifyRequest(request(t, "application/json;;", false, "@method")), ErrDigestRequired)
+	})
+	t.Run("rejects a listed type with an unsigned digest", func(t *testing.T) {
+		assert.ErrorIs(t, v.VerifyRequest(request(t, "application/json", true, "@method")), ErrUnsignedDigest)
+	})
+	t.Run("verifies a listed type with a signed digest", func(t *testing.T) {
+		assert.NoError(t, v.VerifyRequest(request(t, "application/json", true, "@method", "content-digest")))
+
+		req := request(t, "application/json", true, "@method", "content-digest")
+		req.Body = io.NopCloser(bytes.NewBufferString("{\"hello\": \"world\"}"))
+		assert.ErrorIs(t, v.VerifyRequest(req), ErrDigestMismatch)
+	})
+	t.Run("passes other types without a digest", func(t *testing.T) {
+		assert.NoError(t, v.VerifyRequest(request(t, "text/plain", false, "@method")))
+		assert.NoError(t, v.VerifyRequest(request(t, "text/plain", true, "@method")))
+	})
+}
+
+func TestVerifyRequest_DigestHeaderCasing(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	body := "{\"hello\": \"world\"}\n"
+	v := NewVerifier(WithHmacSha256("test-shared-secret", k))
+
+	t.Run("verifies the digest however the header is named", func(t *testing.T) {
+		var verifyErr error
+		srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			// Go presents the header canonically cased to handlers
+			assert.NotEmpty(t, r.Header["Content-Digest"])
+			verifyErr = NewVerifier(WithHmacSha256("test-shared-secret", k), WithDigestHeader("content-digest")).VerifyRequest(r)
+		}))
+		defer srv.Close()
+
+		s := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields("@method", "content-digest"), WithDigestHeader("content-digest"))
+		for _, sent := range []string{body, "{}"} {
+			req, err := http.NewRequest("POST", srv.URL+"/foo", bytes.NewBufferString(body))
+			assert.NoError(t, err)
+			assert.NoError(t, s.SignRequest(req))
+			assert.NotEmpty(t, req.Header.Get(ContentDigestHeader))
+
+			req.Body = io.NopCloser(bytes.NewBufferString(sent))
+			req.ContentLength = int64(len(sent))
+			resp, err := srv.Client().Do(req)
+			assert.NoError(t, err)
+			_ = resp.Body.Close()
+			if sent == body {
+				assert.NoError(t, verifyErr)
+			} else {
+				assert.ErrorIs(t, verifyErr, ErrDigestMismatch)
+			}
+		}
+	})
+	t.Run("verifies a digest header that isn't canonically cased", func(t *testing.T) {
+		s := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields("@method", "content-digest"))
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		assert.NoError(t, s.SignRequest(req))
+
+		req.Header["content-digest"] = req.Header[ContentDigestHeader]
+		delete(req.Header, ContentDigestHeader)
+		assert.NoError(t, v.VerifyRequest(req))
+
+		req.Body = io.NopCloser(bytes.NewBufferString("{}"))
+		assert.ErrorIs(t, v.VerifyRequest(req), ErrDigestMismatch)
+	})
+	t.Run("replaces a digest header that isn't canonically cased", func(t *testing.T) {
+		s := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields("@method", "content-digest"))
+		req, err := http.NewRequest("POST", "https://example.com/foo", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		req.Header["content-digest"] = []string{"sha-256=:AAAA:"}
+		assert.NoError(t, s.SignRequest(req))
+
+		assert.Empty(t, req.Header["content-digest"])
+		assert.Len(t, req.Header.Values(ContentDigestHeader), 1)
+		assert.NoError(t, v.VerifyRequest(req))
+	})
+}
+
+func TestVerify_Head(t *testing.T) {
+	k, err := base64.StdEncoding.DecodeString(testSharedSecret)
+	if err != nil {
+		panic("could not decode test shared secret")
+	}
+
+	// the digest of the representation a GET would return
+	digest, err := NewDigestor().Digest([]byte(`{"hello": "world"}`))
+	assert.NoError(t, err)
+	s := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields("@method", "content-digest"))
+	v := NewVerifier(WithHmacSha256("test-shared-secret", k))
+
+	t.Run("verifies a signed HEAD response", func(t *testing.T) {
+		req, err := http.NewRequest("HEAD", "https://example.com/foo", nil)
+		assert.NoError(t, err)
+		resp := &http.Response{StatusCode: http.StatusOK, Header: digest.Clone(), Body: http.NoBody, Request: req}
+
+		hdr, err := NewSigner(WithHmacSha256("test-shared-secret", k), WithSignFields("@status", "content-digest")).Sign(MessageFromResponse(resp))
+		assert.NoError(t, err)
+		resp.Header = hdr
+		assert.NoError(t, v.Verify(MessageFromResponse(resp)))
+
+		resp.Header.Set(ContentDigestHeader, "sha-256=:AAAA:")
+		assert.ErrorIs(t, v.Verify(MessageFromResponse(resp)), ErrSignatureInvalid)
+	})
+	signed := func(t *testing.T, method string) *http.Request {
+		msg, err := NewMessage(method, "example.com", "/foo", "", digest.Clone())
+		assert.NoError(t, err)
+		hdr, err := s.Sign(msg)
+		assert.NoError(t, err)
+
+		req, err := http.NewRequest(method, "https://example.com/foo", nil)
+		assert.NoError(t, err)
+		req.Header = hdr
+		return req
+	}
+	t.Run("verifies the digest of a HEAD request as a header", func(t *testing.T) {
+		result, err := v.VerifyRequestResult(signed(t, "HEAD"))
+		assert.NoError(t, err)
+		assert.True(t, result.BodyCovered)
+		assert.False(t, result.DigestVerified)
+
+		assert.ErrorIs(t, v.VerifyRequest(signed(t, "GET")), ErrDigestMismatch)
+	})
+}
+
+// countingReader counts the reads made of it
+type countingReader struct {
+	io.Reader
+	reads int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	r.reads++
+	return r.Reader.Read(p)
+}
+
+func BenchmarkVerify(b *testing.B) {
+	secret := []byte("test-shared-secret")
+	fields := []string{"@method", "@authority", "@path", "@query", "content-type", "content-digest", "content-length", "date"}
+	body := []byte(`{"hello": "world"}`)
+	signed := func(b *testing.B, opts ...signOption) *http.Request {
+		s := NewSigner(append([]signOption{WithHmacSha256("test-key", secret), WithSignFields(fields...)}, opts...)...)
+		r := testReq()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if err := s.SignRequest(r); err != nil {
+			b.Fatal(err)
+		}
+		return r
+	}
+	nested := func(name string) signOption {
+		return WithSignSignature(WithHmacSha256("test-key", secret), WithSignName(name), WithSignFields(fields...))
+	}
+
+	b.Run("one signature", func(b *testing.B) {
+		v := NewVerifier(WithHmacSha256("test-key", secret))
+		msg := MessageFromRequest(signed(b))
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := v.Verify(msg); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("all of several signatures", func(b *testing.B) {
+		v := NewVerifier(WithHmacSha256("test-key", secret), WithVerifyAll(true))
+		msg := MessageFromRequest(signed(b, WithSignName("a"), nested("b"), nested("c")))
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := v.Verify(msg); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("selected signature", func(b *testing.B) {
+		v := NewVerifier(WithHmacSha256("test-key", secret), WithVerifySignatureSelector(func(inputs []SignatureInput) (string, bool) {
+			return inputs[len(inputs)-1].Name, true
+		}))
+		msg := MessageFromRequest(signed(b, WithSignName("a"), nested("b"), nested("c")))
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := v.Verify(msg); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("request with digest", func(b *testing.B) {
+		v := NewVerifier(WithHmacSha256("test-key", secret))
+		r := signed(b)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			if err := v.VerifyRequest(r); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}